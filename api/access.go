@@ -14,13 +14,18 @@ var AccessInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 		"type": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
 		},
+		"group": &graphql.InputObjectFieldConfig{
+			Type:        graphql.Boolean,
+			Description: "id is a group id",
+		},
 	},
 })
 
-// Access object for giving user access
+// Access object for giving user or group access
 type Access struct {
-	ID   string `json:"id"`
-	Type string `json:"type"`
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Group bool   `json:"group"`
 }
 
 // AccessType - type of graphql input
@@ -33,14 +38,24 @@ var AccessType = graphql.NewObject(graphql.ObjectConfig{
 		"type": &graphql.Field{
 			Type: graphql.String,
 		},
+		"group": &graphql.Field{
+			Type: graphql.Boolean,
+		},
 	},
 })
 
 // LinkAccess object for link user access
 type LinkAccess struct {
-	ShortLink string `json:"shortlink"`
-	Secret    string `json:"secret"`
-	Type      string `json:"type"`
+	ShortLink string   `json:"shortlink"`
+	Secret    string   `json:"secret"`
+	Type      string   `json:"type"`
+	Expires   int64    `json:"expires"`
+	MaxUses   int64    `json:"maxuses"`
+	Uses      int64    `json:"uses"`
+	Domain    string   `json:"domain"`
+	Protected bool     `json:"protected"`
+	Password  string   `json:"password"`
+	Users     []string `json:"users"`
 }
 
 // LinkAccessType - type of graphql input
@@ -56,5 +71,22 @@ var LinkAccessType = graphql.NewObject(graphql.ObjectConfig{
 		"type": &graphql.Field{
 			Type: graphql.String,
 		},
+		"expires": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"maxuses": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"uses": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "number of users that opened the link",
+		},
+		"domain": &graphql.Field{
+			Type: graphql.String,
+		},
+		"protected": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "link needs a password",
+		},
 	},
 })
@@ -105,8 +105,29 @@ type Account struct {
 	StripeIDs      map[string]*PaymentIDs `json:"stripids"` // customer ids
 	Storage        int64                  `json:"storage"`
 	Billing        *Billing               `json:"billing"`
+	TOTP           *TOTPData              `json:"totp"`
+	Identities     []*Identity            `json:"identities"`
+	Emails         []*AccountEmail        `json:"emails"`
+	PendingEmail   string                 `json:"pendingemail"`
 }
 
+// IdentityType graphql external login object
+var IdentityType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Identity",
+	Fields: graphql.Fields{
+		"provider": &graphql.Field{
+			Type: graphql.String,
+		},
+		"email": &graphql.Field{
+			Type:        graphql.String,
+			Description: "email at the provider when linked",
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
 // AccountType account type object for user accounts graphql
 var AccountType = graphql.NewObject(graphql.ObjectConfig{
 	Name: "Account",
@@ -146,6 +167,38 @@ var AccountType = graphql.NewObject(graphql.ObjectConfig{
 		"billing": &graphql.Field{
 			Type: BillingType,
 		},
+		"totpenabled": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "two factor authentication is on",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				// accountChanged resolves with the published account as a map
+				if accountData, ok := params.Source.(map[string]interface{}); ok {
+					totpData, ok := accountData["totp"].(map[string]interface{})
+					if !ok {
+						return false, nil
+					}
+					enabled, _ := totpData["enabled"].(bool)
+					return enabled, nil
+				}
+				account, ok := params.Source.(*Account)
+				if !ok || account.TOTP == nil {
+					return false, nil
+				}
+				return account.TOTP.Enabled, nil
+			},
+		},
+		"identities": &graphql.Field{
+			Type:        graphql.NewList(IdentityType),
+			Description: "linked external logins",
+		},
+		"emails": &graphql.Field{
+			Type:        graphql.NewList(AccountEmailType),
+			Description: "secondary emails",
+		},
+		"pendingemail": &graphql.Field{
+			Type:        graphql.String,
+			Description: "new login email waiting for confirmation",
+		},
 	},
 })
 
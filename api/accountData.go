@@ -0,0 +1,349 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"github.com/olivere/elastic/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+// exports are zips of everything the user owns or submitted, written to storage by a task and
+// sent to the user as a signed url. the zip is deleted by another task once the url expires.
+// erasing an account also removes the user from the responses they sent to other forms.
+
+var exportEmailTemplate = template.Must(template.ParseFiles("templates/exportEmail.html"))
+
+var exportRequestPath = "export-requested-"
+
+// ExportEmailData data export email object
+type ExportEmailData struct {
+	Email   string `json:"email"`
+	Link    string `json:"link"`
+	Expires string `json:"expires"`
+}
+
+func getExportPath(userIDString string) string {
+	return exportFileIndex + "/" + userIDString + "/"
+}
+
+func writeExportJSON(zipWriter *zip.Writer, name string, data interface{}) error {
+	dataBytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	fileWriter, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fileWriter.Write(dataBytes)
+	return err
+}
+
+// writeExportFiles copies the original uploads stored under the prefix into the zip
+func writeExportFiles(zipWriter *zip.Writer, prefix string) error {
+	objects := storageBucket.Objects(ctxStorage, &storage.Query{
+		Prefix: prefix,
+	})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// blurred and placeholder versions are generated from the original
+		if !strings.HasSuffix(attrs.Name, originalPath) || strings.HasSuffix(attrs.Name, placeholderPath+originalPath) {
+			continue
+		}
+		fileReader, err := storageBucket.Object(attrs.Name).NewReader(ctxStorage)
+		if err != nil {
+			return err
+		}
+		fileWriter, err := zipWriter.Create("files/" + attrs.Name)
+		if err == nil {
+			_, err = io.Copy(fileWriter, fileReader)
+		}
+		fileReader.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getOwnedIDs returns the ids of the documents in the collection matching the filter
+func getOwnedIDs(collection *mongo.Collection, filter bson.M) ([]primitive.ObjectID, error) {
+	cursor, err := collection.Find(ctxMongo, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctxMongo)
+	ids := []primitive.ObjectID{}
+	for cursor.Next(ctxMongo) {
+		id, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			return nil, errors.New("cannot find id")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func writeAccountExport(zipWriter *zip.Writer, account *Account) error {
+	// secrets stay out of the export
+	account.Password = ""
+	account.TOTP = nil
+	if err := writeExportJSON(zipWriter, "account.json", account); err != nil {
+		return err
+	}
+	projectIDs, err := getOwnedIDs(projectCollection, bson.M{
+		"owner": account.ID,
+	})
+	if err != nil {
+		return err
+	}
+	projects := make([]*Project, len(projectIDs))
+	for i, projectID := range projectIDs {
+		if projects[i], err = getProject(projectID, false); err != nil {
+			return err
+		}
+		if projects[i].LinkAccess != nil {
+			projects[i].LinkAccess.Password = ""
+		}
+	}
+	if err = writeExportJSON(zipWriter, "projects.json", projects); err != nil {
+		return err
+	}
+	formIDs, err := getOwnedIDs(formCollection, bson.M{
+		"owner": account.ID,
+	})
+	if err != nil {
+		return err
+	}
+	forms := make([]*Form, len(formIDs))
+	for i, formID := range formIDs {
+		if forms[i], err = getForm(formID, false); err != nil {
+			return err
+		}
+		if forms[i].LinkAccess != nil {
+			forms[i].LinkAccess.Password = ""
+		}
+	}
+	if err = writeExportJSON(zipWriter, "forms.json", forms); err != nil {
+		return err
+	}
+	// responses the user submitted, the responses to their forms are in the form results
+	responseIDs, err := getOwnedIDs(responseCollection, bson.M{
+		"user": account.ID,
+	})
+	if err != nil {
+		return err
+	}
+	responses := make([]*Response, len(responseIDs))
+	for i, responseID := range responseIDs {
+		if responses[i], err = getResponse(responseID, false); err != nil {
+			return err
+		}
+		responses[i].PaymentIntent = ""
+		responses[i].UniqueKeys = nil
+	}
+	if err = writeExportJSON(zipWriter, "responses.json", responses); err != nil {
+		return err
+	}
+	for _, formID := range formIDs {
+		if err = writeExportFiles(zipWriter, formFileIndex+"/"+formID.Hex()+"/"); err != nil {
+			return err
+		}
+	}
+	for _, responseID := range responseIDs {
+		if err = writeExportFiles(zipWriter, responseFileIndex+"/"+responseID.Hex()+"/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportAccountData writes the zip of the user's data to storage and emails a link to it
+func exportAccountData(userIDString string) error {
+	userID, err := primitive.ObjectIDFromHex(userIDString)
+	if err != nil {
+		return err
+	}
+	account, err := getAccount(userID, false)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	exportPath := getExportPath(userIDString) + now.Format("20060102150405") + ".zip"
+	storageWriter := storageBucket.Object(exportPath).NewWriter(ctxStorage)
+	storageWriter.ContentType = "application/zip"
+	storageWriter.ContentDisposition = "attachment; filename=\"data.zip\""
+	zipWriter := zip.NewWriter(storageWriter)
+	if err = writeAccountExport(zipWriter, account); err == nil {
+		err = zipWriter.Close()
+	}
+	if err != nil {
+		storageWriter.CloseWithError(err)
+		return err
+	}
+	if err = storageWriter.Close(); err != nil {
+		return err
+	}
+	expiration := time.Duration(exportExpiration) * time.Hour
+	msg := deleteAccountExportTask.WithArgs(ctxMessageQueue, exportPath)
+	msg.Delay = expiration
+	if err = messageQueue.Add(msg); err != nil {
+		logger.Error("cannot queue export deletion: " + err.Error())
+	}
+	exportURL, err := storage.SignedURL(storageBucketName, exportPath, &storage.SignedURLOptions{
+		Expires:        now.Add(expiration),
+		Method:         "GET",
+		GoogleAccessID: storageAccessID,
+		PrivateKey:     storagePrivateKey,
+	})
+	if err != nil {
+		return err
+	}
+	var templateData bytes.Buffer
+	err = exportEmailTemplate.Execute(&templateData, &ExportEmailData{
+		Email:   account.Email,
+		Link:    exportURL,
+		Expires: now.Add(expiration).UTC().Format("January 2, 2006 15:04 MST"),
+	})
+	if err != nil {
+		return err
+	}
+	emailContent, err := minifier.String("text/html", templateData.String())
+	if err != nil {
+		return err
+	}
+	if _, err = sendEmail(account.Email, "Your data export is ready", emailContent); err != nil {
+		return err
+	}
+	logger.Info("User data export",
+		zap.String("id", userIDString),
+	)
+	return nil
+}
+
+func deleteAccountExport(exportPath string) error {
+	err := storageBucket.Object(exportPath).Delete(ctxStorage)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+func deleteAccountExports(userIDString string) error {
+	objects := storageBucket.Objects(ctxStorage, &storage.Query{
+		Prefix: getExportPath(userIDString),
+	})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = deleteAccountExport(attrs.Name); err != nil {
+			return err
+		}
+	}
+}
+
+// anonymizeUserResponses removes the user from the responses they submitted
+func anonymizeUserResponses(userIDString string) error {
+	_, err := responseCollection.UpdateMany(ctxMongo, bson.M{
+		"user": userIDString,
+	}, bson.M{
+		"$set": bson.M{
+			"user": "",
+		},
+		"$unset": bson.M{
+			"nullifier":  "",
+			"uniquekeys": "",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	script := elastic.NewScriptInline("ctx._source.user = ''; ctx._source.remove('nullifier'); ctx._source.remove('uniquekeys')")
+	_, err = elasticClient.UpdateByQuery(responseElasticIndex).
+		Query(elastic.NewTermQuery("user", userIDString)).
+		Script(script).
+		Do(ctxElastic)
+	return err
+}
+
+// revokeAccountAccess logs the user out everywhere and deletes their api keys
+func revokeAccountAccess(userIDString string) error {
+	if err := revokeUserSessions(userIDString, ""); err != nil {
+		return err
+	}
+	_, err := apiKeyCollection.DeleteMany(ctxMongo, bson.M{
+		"owner": userIDString,
+	})
+	return err
+}
+
+// eraseAccountData removes what deleting the account leaves behind
+func eraseAccountData(userIDString string) error {
+	if err := anonymizeUserResponses(userIDString); err != nil {
+		return err
+	}
+	if err := redisClient.Del(exportRequestPath + userIDString).Err(); err != nil {
+		return err
+	}
+	return deleteAccountExports(userIDString)
+}
+
+// releaseAccountExportRequest lets the user request another export once the export task gave up
+func releaseAccountExportRequest(userIDString string) error {
+	logger.Error("cannot export account data",
+		zap.String("id", userIDString),
+	)
+	return redisClient.Del(exportRequestPath + userIDString).Err()
+}
+
+var accountDataMutationFields = graphql.Fields{
+	"exportAccountData": &graphql.Field{
+		Type:        graphql.String,
+		Description: "Email a link to a zip of your data once it is ready",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			idString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast id to string")
+			}
+			requested, err := redisClient.SetNX(exportRequestPath+idString, getParamsIP(params), time.Duration(exportRequestInterval)*time.Hour).Result()
+			if err != nil {
+				return nil, err
+			}
+			if !requested {
+				return nil, errors.New("a data export was already requested recently")
+			}
+			msg := exportAccountDataTask.WithArgs(ctxMessageQueue, idString)
+			if err = messageQueue.Add(msg); err != nil {
+				redisClient.Del(exportRequestPath + idString)
+				return nil, err
+			}
+			return "data export requested", nil
+		},
+	},
+}
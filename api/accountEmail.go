@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"github.com/stripe/stripe-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accounts log in with their primary email. changing it stores the new address as the pending
+// email until the link sent there is opened. secondary emails only count once verified, and
+// are used to find the account when something is shared with them.
+
+var confirmEmailTemplate = template.Must(template.ParseFiles("templates/confirmEmail.html"))
+
+var emailChangedTemplate = template.Must(template.ParseFiles("templates/emailChanged.html"))
+
+// AccountEmail secondary email of an account
+type AccountEmail struct {
+	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	Added    int64  `json:"added"`
+}
+
+// AccountEmailType graphql secondary email object
+var AccountEmailType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "AccountEmail",
+	Fields: graphql.Fields{
+		"email": &graphql.Field{
+			Type: graphql.String,
+		},
+		"verified": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+		"added": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+// ConfirmEmailData email confirmation object
+type ConfirmEmailData struct {
+	Email       string `json:"email"`
+	Primary     bool   `json:"primary"`
+	ConfirmLink string `json:"confirmlink"`
+}
+
+// EmailChangedData email changed notice object
+type EmailChangedData struct {
+	OldEmail  string `json:"oldemail"`
+	NewEmail  string `json:"newemail"`
+	ResetLink string `json:"resetlink"`
+}
+
+// getEmailAccountFilter matches the account using the email as primary or verified secondary email
+func getEmailAccountFilter(email string) bson.M {
+	return bson.M{
+		"$or": bson.A{
+			bson.M{
+				"email": email,
+			},
+			bson.M{
+				"emails": bson.M{
+					"$elemMatch": bson.M{
+						"email":    email,
+						"verified": true,
+					},
+				},
+			},
+		},
+	}
+}
+
+// checkEmailAvailable returns an error if another account already uses the email
+func checkEmailAvailable(email string) error {
+	count, err := userCollection.CountDocuments(ctxMongo, getEmailAccountFilter(email))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("email is already taken")
+	}
+	return nil
+}
+
+func validateEmailAddress(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
+
+func sendEmailConfirmation(accountID primitive.ObjectID, email string, primary bool) error {
+	if err := checkSlidingRateLimit("email-confirm-"+accountID.Hex(), emailConfirmLimit, time.Duration(emailConfirmWindow)*time.Minute); err != nil {
+		return err
+	}
+	expirationTime := time.Now().Add(time.Duration(tokenExpiration) * time.Hour)
+	// the token does not carry an id claim, so it cannot be used to log in
+	tokenString, err := signToken(jwt.MapClaims{
+		"account":      accountID.Hex(),
+		"email":        email,
+		"primary":      primary,
+		"confirmemail": true,
+		"exp":          expirationTime.Unix(),
+		"iss":          jwtIssuer,
+	})
+	if err != nil {
+		return err
+	}
+	var templateData bytes.Buffer
+	err = confirmEmailTemplate.Execute(&templateData, &ConfirmEmailData{
+		Email:       email,
+		Primary:     primary,
+		ConfirmLink: websiteURL + "/profile?confirmemail=true&token=" + tokenString,
+	})
+	if err != nil {
+		return err
+	}
+	emailContent, err := minifier.String("text/html", templateData.String())
+	if err != nil {
+		return err
+	}
+	_, err = sendEmail(email, "Please Confirm Email", emailContent)
+	return err
+}
+
+func sendEmailChangedNotice(oldEmail string, newEmail string) error {
+	var templateData bytes.Buffer
+	err := emailChangedTemplate.Execute(&templateData, &EmailChangedData{
+		OldEmail:  oldEmail,
+		NewEmail:  newEmail,
+		ResetLink: websiteURL + "/reset",
+	})
+	if err != nil {
+		return err
+	}
+	emailContent, err := minifier.String("text/html", templateData.String())
+	if err != nil {
+		return err
+	}
+	_, err = sendEmail(oldEmail, "Your email was changed", emailContent)
+	return err
+}
+
+// completeEmailChange makes the email the primary email of the account, and tells the old address
+func completeEmailChange(account *Account, email string, ip string) error {
+	accountID, err := primitive.ObjectIDFromHex(account.ID)
+	if err != nil {
+		return err
+	}
+	_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": accountID,
+	}, bson.M{
+		"$set": bson.M{
+			"email":         email,
+			"emailverified": true,
+			"pendingemail":  "",
+			"updated":       time.Now().Unix(),
+		},
+		"$pull": bson.M{
+			"emails": bson.M{
+				"email": email,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, stripeData := range account.StripeIDs {
+		if _, err = stripeClient.Customers.Update(stripeData.Customer, &stripe.CustomerParams{
+			Email: &email,
+		}); err != nil {
+			logger.Error("cannot update customer email: " + err.Error())
+		}
+	}
+	recordAudit(auditEmailChangeAction, account.ID, userType, account.ID, account.ID, ip, bson.M{
+		"from": account.Email,
+		"to":   email,
+	})
+	publishAccountChanged(accountID)
+	logger.Info("User email change",
+		zap.String("id", account.ID),
+		zap.String("email", email),
+	)
+	if err = sendEmailChangedNotice(account.Email, email); err != nil {
+		logger.Error("cannot send email changed notice: " + err.Error())
+	}
+	return nil
+}
+
+func getAccountEmailArgs(params graphql.ResolveParams) (*Account, string, error) {
+	claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+	if err != nil {
+		return nil, "", err
+	}
+	idString, ok := claims["id"].(string)
+	if !ok {
+		return nil, "", errors.New("cannot cast id to string")
+	}
+	id, err := primitive.ObjectIDFromHex(idString)
+	if err != nil {
+		return nil, "", err
+	}
+	if params.Args["email"] == nil {
+		return nil, "", errors.New("no email argument provided")
+	}
+	email, ok := params.Args["email"].(string)
+	if !ok {
+		return nil, "", errors.New("cannot cast email to string")
+	}
+	account, err := getAccount(id, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return account, email, nil
+}
+
+func findAccountEmail(account *Account, email string) *AccountEmail {
+	for _, accountEmail := range account.Emails {
+		if accountEmail.Email == email {
+			return accountEmail
+		}
+	}
+	return nil
+}
+
+var accountEmailMutationFields = graphql.Fields{
+	"changeEmail": &graphql.Field{
+		Type:        AccountType,
+		Description: "Change the login email, after confirming with the link sent to the new email",
+		Args: graphql.FieldConfigArgument{
+			"email": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"password": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "current password, if the account has one",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			account, email, err := getAccountEmailArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if len(account.Password) > 0 {
+				password, _ := params.Args["password"].(string)
+				if err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password)); err != nil {
+					return nil, errors.New("invalid password")
+				}
+			}
+			if email == account.Email {
+				return nil, errors.New("email is already the login email")
+			}
+			if err = validateEmailAddress(email); err != nil {
+				return nil, err
+			}
+			if err = checkSSORequired(email); err != nil {
+				return nil, err
+			}
+			accountID, err := primitive.ObjectIDFromHex(account.ID)
+			if err != nil {
+				return nil, err
+			}
+			// a verified secondary email does not need to be confirmed again
+			if accountEmail := findAccountEmail(account, email); accountEmail != nil && accountEmail.Verified {
+				if err = completeEmailChange(account, email, getParamsIP(params)); err != nil {
+					return nil, err
+				}
+				return getAccount(accountID, false)
+			}
+			if err = checkEmailAvailable(email); err != nil {
+				return nil, err
+			}
+			if err = sendEmailConfirmation(accountID, email, true); err != nil {
+				return nil, err
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": accountID,
+			}, bson.M{
+				"$set": bson.M{
+					"pendingemail": email,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			account.PendingEmail = email
+			return account, nil
+		},
+	},
+	"addEmail": &graphql.Field{
+		Type:        AccountType,
+		Description: "Add a secondary email, after confirming with the link sent to it",
+		Args: graphql.FieldConfigArgument{
+			"email": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			account, email, err := getAccountEmailArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if email == account.Email || findAccountEmail(account, email) != nil {
+				return nil, errors.New("email was already added")
+			}
+			if len(account.Emails) >= maxAccountEmails {
+				return nil, errors.New("maximum number of emails reached")
+			}
+			if err = validateEmailAddress(email); err != nil {
+				return nil, err
+			}
+			if err = checkEmailAvailable(email); err != nil {
+				return nil, err
+			}
+			accountID, err := primitive.ObjectIDFromHex(account.ID)
+			if err != nil {
+				return nil, err
+			}
+			if err = sendEmailConfirmation(accountID, email, false); err != nil {
+				return nil, err
+			}
+			accountEmail := &AccountEmail{
+				Email:    email,
+				Verified: false,
+				Added:    time.Now().Unix(),
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": accountID,
+			}, bson.M{
+				"$push": bson.M{
+					"emails": bson.M{
+						"email":    accountEmail.Email,
+						"verified": accountEmail.Verified,
+						"added":    accountEmail.Added,
+					},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			publishAccountChanged(accountID)
+			account.Emails = append(account.Emails, accountEmail)
+			return account, nil
+		},
+	},
+	"removeEmail": &graphql.Field{
+		Type:        AccountType,
+		Description: "Remove a secondary email, or cancel a pending email change",
+		Args: graphql.FieldConfigArgument{
+			"email": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			account, email, err := getAccountEmailArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			accountID, err := primitive.ObjectIDFromHex(account.ID)
+			if err != nil {
+				return nil, err
+			}
+			if email == account.PendingEmail {
+				_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+					"_id": accountID,
+				}, bson.M{
+					"$set": bson.M{
+						"pendingemail": "",
+					},
+				})
+				if err != nil {
+					return nil, err
+				}
+				account.PendingEmail = ""
+				return account, nil
+			}
+			if findAccountEmail(account, email) == nil {
+				return nil, errors.New("email not found")
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": accountID,
+			}, bson.M{
+				"$pull": bson.M{
+					"emails": bson.M{
+						"email": email,
+					},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			publishAccountChanged(accountID)
+			return getAccount(accountID, false)
+		},
+	},
+}
+
+/**
+ * @api {post} /confirmEmail Confirm email
+ * @apiVersion 0.0.1
+ * @apiParam {String} token Token from the confirmation link
+ * @apiSuccess {String} message Success message for email confirmed
+ * @apiGroup emails
+ */
+func confirmEmail(c *gin.Context) {
+	response := c.Writer
+	request := c.Request
+	if request.Method != http.MethodPost {
+		handleError("confirm http method not POST", http.StatusBadRequest, response)
+		return
+	}
+	var confirmdata map[string]interface{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		handleError("error getting request body: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	err = json.Unmarshal(body, &confirmdata)
+	if err != nil {
+		handleError("error parsing request body: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	giventoken, ok := confirmdata["token"].(string)
+	if !ok {
+		handleError("no token provided", http.StatusBadRequest, response)
+		return
+	}
+	token, err := jwt.Parse(giventoken, getTokenKey)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		handleError("invalid token", http.StatusBadRequest, response)
+		return
+	}
+	if isConfirm, _ := claims["confirmemail"].(bool); !isConfirm {
+		handleError("token is not for confirming an email", http.StatusBadRequest, response)
+		return
+	}
+	accountIDString, ok := claims["account"].(string)
+	if !ok {
+		handleError("account in token cannot be cast to string", http.StatusBadRequest, response)
+		return
+	}
+	accountID, err := primitive.ObjectIDFromHex(accountIDString)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	email, ok := claims["email"].(string)
+	if !ok {
+		handleError("email in token cannot be cast to string", http.StatusBadRequest, response)
+		return
+	}
+	primary, _ := claims["primary"].(bool)
+	account, err := getAccount(accountID, false)
+	if err != nil {
+		handleError("error finding user: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	if err = checkEmailAvailable(email); err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	if primary {
+		if account.PendingEmail != email {
+			handleError("email change was cancelled or replaced", http.StatusBadRequest, response)
+			return
+		}
+		if err = completeEmailChange(account, email, c.ClientIP()); err != nil {
+			handleError("error changing email: "+err.Error(), http.StatusBadRequest, response)
+			return
+		}
+	} else {
+		accountEmail := findAccountEmail(account, email)
+		if accountEmail == nil {
+			handleError("email was removed from the account", http.StatusBadRequest, response)
+			return
+		}
+		if accountEmail.Verified {
+			handleError("email already verified", http.StatusBadRequest, response)
+			return
+		}
+		_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+			"_id":          accountID,
+			"emails.email": email,
+		}, bson.M{
+			"$set": bson.M{
+				"emails.$.verified": true,
+			},
+		})
+		if err != nil {
+			handleError("error updating user in database: "+err.Error(), http.StatusBadRequest, response)
+			return
+		}
+		publishAccountChanged(accountID)
+		logger.Info("User email verify",
+			zap.String("id", accountIDString),
+			zap.String("email", email),
+		)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write([]byte(`{"message":"email successfully confirmed"}`))
+}
@@ -11,7 +11,9 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func deleteAccount(idstring string) (interface{}, error) {
+// deleteAccount deletes the user with everything they own. erasing also anonymizes the responses
+// they submitted to other forms, which are kept otherwise
+func deleteAccount(idstring string, erase bool) (interface{}, error) {
 	id, err := primitive.ObjectIDFromHex(idstring)
 	if err != nil {
 		return nil, err
@@ -72,6 +74,14 @@ func deleteAccount(idstring string) (interface{}, error) {
 			return nil, err
 		}
 	}
+	if err = revokeAccountAccess(idstring); err != nil {
+		return nil, err
+	}
+	if erase {
+		if err = eraseAccountData(idstring); err != nil {
+			return nil, err
+		}
+	}
 	_, err = userCollection.DeleteOne(ctxMongo, bson.M{
 		"_id": id,
 	})
@@ -144,6 +154,10 @@ var userMutationFields = graphql.Fields{
 				},
 			})
 			account.Plan = defaultProduct.ID
+			recordAudit(auditPlanChangeAction, idString, userType, idString, idString, getParamsIP(params), bson.M{
+				"plan": defaultProduct.ID,
+			})
+			publishAccountChanged(id)
 			return account, nil
 		},
 	},
@@ -225,6 +239,13 @@ var userMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			if interval != singlePurchase {
+				recordAudit(auditPlanChangeAction, idString, userType, idString, idString, getParamsIP(params), bson.M{
+					"plan":     productID.Hex(),
+					"interval": interval,
+				})
+			}
+			publishAccountChanged(id)
 			return *clientSecret, nil
 		},
 	},
@@ -388,6 +409,7 @@ var userMutationFields = graphql.Fields{
 					"billing": updatedBillingData,
 				},
 			})
+			publishAccountChanged(id)
 			return account, nil
 		},
 	},
@@ -459,6 +481,7 @@ var userMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			publishAccountChanged(id)
 			return account, nil
 		},
 	},
@@ -516,6 +539,7 @@ var userMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			publishAccountChanged(id)
 			return account, nil
 		},
 	},
@@ -526,6 +550,10 @@ var userMutationFields = graphql.Fields{
 			"id": &graphql.ArgumentConfig{
 				Type: graphql.String,
 			},
+			"erase": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "also anonymize the responses the user submitted",
+			},
 		},
 		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 			_, err := validateAdmin(params.Context.Value(tokenKey).(string))
@@ -539,13 +567,19 @@ var userMutationFields = graphql.Fields{
 			if !ok {
 				return nil, errors.New("cannot cast id to string")
 			}
-			return deleteAccount(idstring)
+			erase, _ := params.Args["erase"].(bool)
+			return deleteAccount(idstring, erase)
 		},
 	},
 	"deleteAccount": &graphql.Field{
 		Type:        AccountType,
 		Description: "Delete a User",
-		Args:        graphql.FieldConfigArgument{},
+		Args: graphql.FieldConfigArgument{
+			"erase": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "also anonymize the responses you submitted",
+			},
+		},
 		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
 			if err != nil {
@@ -555,7 +589,8 @@ var userMutationFields = graphql.Fields{
 			if !ok {
 				return nil, errors.New("cannot cast id to string")
 			}
-			return deleteAccount(idstring)
+			erase, _ := params.Args["erase"].(bool)
+			return deleteAccount(idstring, erase)
 		},
 	},
 }
@@ -19,25 +19,16 @@ var userQueryFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
-			if accountdata["email"] == nil {
-				return nil, errors.New("email not found in token")
+			// the email in the token is stale after an email change, the id is not
+			idString, ok := accountdata["id"].(string)
+			if !ok {
+				return nil, errors.New("id not found in token")
 			}
-			var account Account
-			accountData := map[string]interface{}{}
-			err = userCollection.FindOne(ctxMongo, bson.M{
-				"email": accountdata["email"].(string),
-			}).Decode(&accountData)
+			accountID, err := primitive.ObjectIDFromHex(idString)
 			if err != nil {
 				return nil, err
 			}
-			accountID := accountData["_id"].(primitive.ObjectID)
-			delete(accountData, "_id")
-			if err = mapstructure.Decode(accountData, &account); err != nil {
-				return nil, err
-			}
-			account.Created = objectidTimestamp(accountID).Unix()
-			account.ID = accountID.Hex()
-			return &account, nil
+			return getAccount(accountID, false)
 		},
 	},
 	"user": &graphql.Field{
@@ -91,13 +82,11 @@ var userQueryFields = graphql.Fields{
 			if !ok {
 				return nil, errors.New("cannot cast email to string")
 			}
-			cursor, err := userCollection.Find(ctxMongo, bson.M{
-				"email": emailString,
-			})
-			defer cursor.Close(ctxMongo)
+			cursor, err := userCollection.Find(ctxMongo, getEmailAccountFilter(emailString))
 			if err != nil {
 				return nil, err
 			}
+			defer cursor.Close(ctxMongo)
 			publicUserData := map[string]interface{}{}
 			var foundstuff = false
 			for cursor.Next(ctxMongo) {
@@ -107,11 +96,11 @@ var userQueryFields = graphql.Fields{
 					return nil, err
 				}
 				userData := userDataPrimitive.Map()
-				userID, ok := userData["_id"].(string)
+				userID, ok := userData["_id"].(primitive.ObjectID)
 				if !ok {
-					return nil, errors.New("cannot cast id to string")
+					return nil, errors.New("cannot cast id to object id")
 				}
-				publicUserData["id"] = userID
+				publicUserData["id"] = userID.Hex()
 				publicUserData["email"] = emailString
 				foundstuff = true
 				break
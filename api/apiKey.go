@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// api keys look like <apiKeyPrefix><key id>_<secret> and are used in place of a login token.
+// only a hash of the key is stored. requests with a key can only use the graphql fields of
+// its scopes (apiKeyScopeFields), and only the given projects if there are any.
+
+// APIKey long lived token for scripts
+type APIKey struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Owner    string   `json:"owner"`
+	Hash     string   `json:"hash"`
+	Scopes   []string `json:"scopes"`
+	Projects []string `json:"projects"`
+	LastUsed int64    `json:"lastused"`
+	Created  int64    `json:"created"`
+	Key      string   `json:"key"`
+}
+
+// APIKeyType graphql api key object
+var APIKeyType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "APIKey",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"name": &graphql.Field{
+			Type: graphql.String,
+		},
+		"scopes": &graphql.Field{
+			Type: graphql.NewList(graphql.String),
+		},
+		"projects": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "projects the key can use, all if empty",
+		},
+		"lastused": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"key": &graphql.Field{
+			Type:        graphql.String,
+			Description: "only returned when the key is created",
+		},
+	},
+})
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func getAPIKey(keyID primitive.ObjectID) (*APIKey, error) {
+	var apiKey APIKey
+	err := apiKeyCollection.FindOne(ctxMongo, bson.M{
+		"_id": keyID,
+	}).Decode(&apiKey)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.ID = keyID.Hex()
+	apiKey.Created = objectidTimestamp(keyID).Unix()
+	return &apiKey, nil
+}
+
+// getAPIKeyClaims returns the same claims as a login token for the owner of the key,
+// with the scopes and projects of the key
+func getAPIKeyClaims(key string) (jwt.MapClaims, error) {
+	keyParts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if len(keyParts) != 2 {
+		return nil, errors.New("invalid api key")
+	}
+	keyID, err := primitive.ObjectIDFromHex(keyParts[0])
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+	apiKey, err := getAPIKey(keyID)
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(hashAPIKey(key))) != 1 {
+		return nil, errors.New("invalid api key")
+	}
+	ownerID, err := primitive.ObjectIDFromHex(apiKey.Owner)
+	if err != nil {
+		return nil, err
+	}
+	account, err := getAccount(ownerID, false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if now-apiKey.LastUsed >= int64(apiKeyLastUsedInterval) {
+		_, err = apiKeyCollection.UpdateOne(ctxMongo, bson.M{
+			"_id": keyID,
+		}, bson.M{
+			"$set": bson.M{
+				"lastused": now,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// keys never get admin access
+	return jwt.MapClaims{
+		"id":       apiKey.Owner,
+		"email":    account.Email,
+		"type":     userType,
+		"plan":     account.Plan,
+		"apikey":   apiKey.ID,
+		"scopes":   stringListToInterfaceList(apiKey.Scopes),
+		"projects": stringListToInterfaceList(apiKey.Projects),
+	}, nil
+}
+
+func getAPIKeyClaimsList(claims jwt.MapClaims, name string) []string {
+	listInterface, ok := claims[name].([]interface{})
+	if !ok {
+		return []string{}
+	}
+	list, err := interfaceListToStringList(listInterface)
+	if err != nil {
+		return []string{}
+	}
+	return list
+}
+
+// getAPIKeyProjects returns the projects the token is limited to, empty for no limit
+func getAPIKeyProjects(claims jwt.MapClaims) []string {
+	if claims["apikey"] == nil {
+		return []string{}
+	}
+	return getAPIKeyClaimsList(claims, "projects")
+}
+
+// checkAPIKeyProject returns an error if the token is an api key that cannot use the project
+func checkAPIKeyProject(claims jwt.MapClaims, projectIDString string) error {
+	projects := getAPIKeyProjects(claims)
+	if len(projects) > 0 && !findInArray(projectIDString, projects) {
+		return errors.New("api key cannot access project " + projectIDString)
+	}
+	return nil
+}
+
+// checkAPIKeyScope returns an error if the token is an api key without the scope
+func checkAPIKeyScope(accessToken string, scope string) error {
+	if !isAPIKey(accessToken) {
+		return nil
+	}
+	claims, err := getTokenData(accessToken)
+	if err != nil {
+		return err
+	}
+	if !findInArray(scope, getAPIKeyClaimsList(claims, "scopes")) {
+		return errors.New("api key does not have the " + scope + " scope")
+	}
+	return nil
+}
+
+// checkAPIKeyField returns an error if the token is an api key without a scope for the graphql field
+func checkAPIKeyField(accessToken string, fieldName string) error {
+	if !isAPIKey(accessToken) {
+		return nil
+	}
+	claims, err := getTokenData(accessToken)
+	if err != nil {
+		return err
+	}
+	for _, scope := range getAPIKeyClaimsList(claims, "scopes") {
+		if findInArray(fieldName, apiKeyScopeFields[scope]) {
+			return nil
+		}
+	}
+	return errors.New("api key cannot use " + fieldName)
+}
+
+// addAPIKeyScopeChecks makes every root field check the scopes of api keys before resolving
+func addAPIKeyScopeChecks(fields graphql.Fields) {
+	for fieldName, field := range fields {
+		currentFieldName := fieldName
+		resolve := field.Resolve
+		if resolve == nil {
+			continue
+		}
+		field.Resolve = func(params graphql.ResolveParams) (interface{}, error) {
+			accessToken, _ := params.Context.Value(tokenKey).(string)
+			if err := checkAPIKeyField(accessToken, currentFieldName); err != nil {
+				return nil, err
+			}
+			return resolve(params)
+		}
+	}
+}
+
+var apiKeyQueryFields = graphql.Fields{
+	"apiKeys": &graphql.Field{
+		Type:        graphql.NewList(APIKeyType),
+		Description: "Get your api keys",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			cursor, err := apiKeyCollection.Find(ctxMongo, bson.M{
+				"owner": userIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			apiKeys := []*APIKey{}
+			for cursor.Next(ctxMongo) {
+				var apiKey APIKey
+				if err = cursor.Decode(&apiKey); err != nil {
+					return nil, err
+				}
+				keyID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find api key id")
+				}
+				apiKey.ID = keyID.Hex()
+				apiKey.Created = objectidTimestamp(keyID).Unix()
+				apiKeys = append(apiKeys, &apiKey)
+			}
+			return apiKeys, nil
+		},
+	},
+}
+
+var apiKeyMutationFields = graphql.Fields{
+	"addAPIKey": &graphql.Field{
+		Type:        APIKeyType,
+		Description: "Create an api key, the key is only returned once",
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"scopes": &graphql.ArgumentConfig{
+				Type: graphql.NewList(graphql.String),
+			},
+			"projects": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(graphql.String),
+				Description: "projects the key can use, all if empty",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			accessToken := params.Context.Value(tokenKey).(string)
+			claims, err := getTokenData(accessToken)
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			if params.Args["name"] == nil {
+				return nil, errors.New("name not provided")
+			}
+			name, ok := params.Args["name"].(string)
+			if !ok {
+				return nil, errors.New("problem casting name to string")
+			}
+			if params.Args["scopes"] == nil {
+				return nil, errors.New("scopes not provided")
+			}
+			scopesInterface, ok := params.Args["scopes"].([]interface{})
+			if !ok {
+				return nil, errors.New("problem casting scopes to interface array")
+			}
+			scopes, err := interfaceListToStringList(scopesInterface)
+			if err != nil {
+				return nil, err
+			}
+			if len(scopes) == 0 {
+				return nil, errors.New("no scopes given")
+			}
+			for _, scope := range scopes {
+				if !findInArray(scope, validAPIKeyScopes) {
+					return nil, errors.New("invalid scope " + scope)
+				}
+			}
+			projects := []string{}
+			if params.Args["projects"] != nil {
+				projectsInterface, ok := params.Args["projects"].([]interface{})
+				if !ok {
+					return nil, errors.New("problem casting projects to interface array")
+				}
+				projects, err = interfaceListToStringList(projectsInterface)
+				if err != nil {
+					return nil, err
+				}
+				for _, projectIDString := range projects {
+					projectID, err := primitive.ObjectIDFromHex(projectIDString)
+					if err != nil {
+						return nil, err
+					}
+					if _, _, err = checkProjectAccess(projectID, accessToken, "", viewAccessLevel, false); err != nil {
+						return nil, err
+					}
+				}
+			}
+			secretBytes := make([]byte, apiKeySecretLength)
+			if _, err = rand.Read(secretBytes); err != nil {
+				return nil, err
+			}
+			keyID := primitive.NewObjectID()
+			key := apiKeyPrefix + keyID.Hex() + "_" + hex.EncodeToString(secretBytes)
+			apiKey := &APIKey{
+				ID:       keyID.Hex(),
+				Name:     name,
+				Owner:    userIDString,
+				Scopes:   scopes,
+				Projects: projects,
+				Created:  objectidTimestamp(keyID).Unix(),
+				Key:      key,
+			}
+			_, err = apiKeyCollection.InsertOne(ctxMongo, bson.M{
+				"_id":      keyID,
+				"name":     apiKey.Name,
+				"owner":    apiKey.Owner,
+				"hash":     hashAPIKey(key),
+				"scopes":   apiKey.Scopes,
+				"projects": apiKey.Projects,
+				"lastused": int64(0),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return apiKey, nil
+		},
+	},
+	"deleteAPIKey": &graphql.Field{
+		Type:        APIKeyType,
+		Description: "Revoke an api key",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			if params.Args["id"] == nil {
+				return nil, errors.New("api key id not provided")
+			}
+			keyIDString, ok := params.Args["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast api key id to string")
+			}
+			keyID, err := primitive.ObjectIDFromHex(keyIDString)
+			if err != nil {
+				return nil, err
+			}
+			apiKey, err := getAPIKey(keyID)
+			if err != nil {
+				return nil, err
+			}
+			if apiKey.Owner != userIDString {
+				return nil, errors.New("you do not own the api key")
+			}
+			_, err = apiKeyCollection.DeleteOne(ctxMongo, bson.M{
+				"_id": keyID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return apiKey, nil
+		},
+	},
+}
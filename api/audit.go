@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the audit log is append only, entries are never updated or deleted
+
+// AuditEntry record of a security relevant or sharing action
+type AuditEntry struct {
+	ID         string `json:"id"`
+	Action     string `json:"action"`
+	Actor      string `json:"actor"`
+	Target     string `json:"target"`
+	TargetType string `json:"targettype"`
+	Owner      string `json:"owner"`
+	IP         string `json:"ip"`
+	Details    string `json:"details"`
+	Created    int64  `json:"created"`
+}
+
+// AuditEntryType graphql audit entry object
+var AuditEntryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "AuditEntry",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"action": &graphql.Field{
+			Type: graphql.String,
+		},
+		"actor": &graphql.Field{
+			Type:        graphql.String,
+			Description: "user id that did the action",
+		},
+		"target": &graphql.Field{
+			Type: graphql.String,
+		},
+		"targettype": &graphql.Field{
+			Type: graphql.String,
+		},
+		"owner": &graphql.Field{
+			Type:        graphql.String,
+			Description: "user id of the owner of the target",
+		},
+		"ip": &graphql.Field{
+			Type: graphql.String,
+		},
+		"details": &graphql.Field{
+			Type:        graphql.String,
+			Description: "json of the changes",
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+// recordAudit adds an entry to the audit log. the action already happened, so errors are only logged
+func recordAudit(action string, actorIDString string, targetType string, targetIDString string, ownerIDString string, ip string, details interface{}) {
+	var detailsString = ""
+	if details != nil {
+		detailsBytes, err := json.Marshal(details)
+		if err != nil {
+			logger.Error("cannot encode audit details: " + err.Error())
+		} else {
+			detailsString = string(detailsBytes)
+		}
+	}
+	_, err := auditCollection.InsertOne(ctxMongo, bson.M{
+		"action":     action,
+		"actor":      actorIDString,
+		"target":     targetIDString,
+		"targettype": targetType,
+		"owner":      ownerIDString,
+		"ip":         ip,
+		"details":    detailsString,
+		"created":    time.Now().Unix(),
+	})
+	if err != nil {
+		logger.Error("cannot save audit entry " + action + " for " + targetIDString + ": " + err.Error())
+	}
+}
+
+// getParamsIP returns the ip of the request a resolver is running for
+func getParamsIP(params graphql.ResolveParams) string {
+	ip, _ := params.Context.Value(ipKey).(string)
+	return ip
+}
+
+// getAuditProjectOwner returns the owner of a project to record with its audit entries
+func getAuditProjectOwner(projectID primitive.ObjectID) string {
+	project, err := getProject(projectID, false)
+	if err != nil {
+		logger.Error("cannot find project owner for audit entry: " + err.Error())
+		return ""
+	}
+	return project.Owner
+}
+
+var auditQueryFields = graphql.Fields{
+	"auditLog": &graphql.Field{
+		Type:        graphql.NewList(AuditEntryType),
+		Description: "Get audit entries for resources you own, or all entries as admin",
+		Args: graphql.FieldConfigArgument{
+			"action": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"actor": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"target": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"targettype": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"owner": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "only for admins, others always get their own resources",
+			},
+			"from": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "unix time of the earliest entry",
+			},
+			"to": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "unix time of the latest entry",
+			},
+			"perpage": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"page": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			var isAdmin = claims["type"] == adminType || claims["type"] == superAdminType
+			filter := bson.M{}
+			for _, argName := range []string{"action", "actor", "target", "targettype", "owner"} {
+				if params.Args[argName] == nil {
+					continue
+				}
+				argValue, ok := params.Args[argName].(string)
+				if !ok {
+					return nil, errors.New("cannot cast " + argName + " to string")
+				}
+				filter[argName] = argValue
+			}
+			if action, ok := filter["action"].(string); ok && !findInArray(action, validAuditActions) {
+				return nil, errors.New("invalid action given")
+			}
+			if !isAdmin {
+				filter["owner"] = userIDString
+			}
+			createdFilter := bson.M{}
+			if params.Args["from"] != nil {
+				from, ok := params.Args["from"].(int)
+				if !ok {
+					return nil, errors.New("cannot cast from to int")
+				}
+				createdFilter["$gte"] = int64(from)
+			}
+			if params.Args["to"] != nil {
+				to, ok := params.Args["to"].(int)
+				if !ok {
+					return nil, errors.New("cannot cast to to int")
+				}
+				createdFilter["$lte"] = int64(to)
+			}
+			if len(createdFilter) > 0 {
+				filter["created"] = createdFilter
+			}
+			var perpage = 20
+			if params.Args["perpage"] != nil {
+				perpage, ok = params.Args["perpage"].(int)
+				if !ok {
+					return nil, errors.New("cannot cast perpage to int")
+				}
+			}
+			if perpage <= 0 || perpage > auditMaxPerPage {
+				return nil, errors.New("invalid perpage given")
+			}
+			var page = 0
+			if params.Args["page"] != nil {
+				page, ok = params.Args["page"].(int)
+				if !ok {
+					return nil, errors.New("cannot cast page to int")
+				}
+			}
+			if page < 0 {
+				return nil, errors.New("invalid page given")
+			}
+			cursor, err := auditCollection.Find(ctxMongo, filter, options.Find().
+				SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}).
+				SetSkip(int64(page*perpage)).
+				SetLimit(int64(perpage)))
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			entries := []*AuditEntry{}
+			for cursor.Next(ctxMongo) {
+				var entry AuditEntry
+				if err = cursor.Decode(&entry); err != nil {
+					return nil, err
+				}
+				entryID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find audit entry id")
+				}
+				entry.ID = entryID.Hex()
+				entries = append(entries, &entry)
+			}
+			return entries, nil
+		},
+	},
+}
@@ -31,10 +31,11 @@ import (
 var numHashes = 12
 
 type loginClaims struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
-	Type  string `json:"type"`
-	Plan  string `json:"plan"`
+	ID      string `json:"id"`
+	Email   string `json:"email"`
+	Type    string `json:"type"`
+	Plan    string `json:"plan"`
+	Session string `json:"session"`
 	jwt.StandardClaims
 }
 
@@ -77,6 +78,92 @@ func verifyRecaptcha(recaptchaToken string, recaptchaSecret string) error {
 	return nil
 }
 
+// createAccount creates a user on the default plan, returning the new user id
+func createAccount(email string, passwordHashed string, emailVerified bool) (primitive.ObjectID, error) {
+	userID := primitive.NewObjectID()
+	defaultProduct, err := getProduct(primitive.NilObjectID, true)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	var foundCurrency = false
+	var planStripeID string
+	for _, currencyData := range defaultProduct.Plans[0].Currencies {
+		if currencyData.Currency == defaultCurrency {
+			foundCurrency = true
+			planStripeID = currencyData.StripeID
+			break
+		}
+	}
+	if !foundCurrency {
+		return primitive.NilObjectID, errors.New("cannot find default currency for default plan")
+	}
+	customerParams := &stripe.CustomerParams{
+		Email: &email,
+		Params: stripe.Params{
+			Metadata: map[string]string{
+				"id": userID.Hex(),
+			},
+		},
+	}
+	newCustomer, err := stripeClient.Customers.New(customerParams)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	now := time.Now()
+	subscriptionParams := &stripe.SubscriptionParams{
+		Customer: &newCustomer.ID,
+		Items: []*stripe.SubscriptionItemsParams{&stripe.SubscriptionItemsParams{
+			Plan: &planStripeID,
+		},
+		},
+	}
+	stripeSubscription, err := stripeClient.Subscriptions.New(subscriptionParams)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	accountData := bson.M{
+		"_id":           userID,
+		"email":         email,
+		"password":      passwordHashed,
+		"emailverified": emailVerified,
+		"type":          userType,
+		"updated":       now.Unix(),
+		"categories":    bson.A{},
+		"tags":          bson.A{},
+		"identities":    bson.A{},
+		"emails":        bson.A{},
+		"pendingemail":  "",
+		"stripeids": bson.M{
+			defaultCurrency: bson.M{
+				"customer": newCustomer.ID,
+				"payment":  "",
+			},
+		},
+		"plan":           defaultProduct.ID,
+		"subscriptionid": stripeSubscription.ID,
+		"purchases":      bson.A{},
+		"storage":        int64(0),
+		"billing": bson.M{
+			"firstName": "",
+			"lastName":  "",
+			"company":   "",
+			"address1":  "",
+			"city":      "",
+			"state":     "",
+			"zip":       "",
+			"country":   defaultCountry,
+			"phone":     "",
+			"email":     email,
+			"currency":  defaultCurrency,
+		},
+	}
+	_, err = userCollection.InsertOne(ctxMongo, accountData)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("error inserting user to database: " + err.Error())
+	}
+	return userID, nil
+}
+
 /**
  * @api {post} /register User registration
  * @apiVersion 0.0.1
@@ -135,13 +222,12 @@ func register(c *gin.Context) {
 		handleError("recaptcha error: "+err.Error(), http.StatusUnauthorized, response)
 		return
 	}
-	countemail, err := userCollection.CountDocuments(ctxMongo, bson.M{"email": email})
-	if err != nil {
-		handleError("error counting users with same email: "+err.Error(), http.StatusBadRequest, response)
+	if err = checkSSORequired(email); err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
 		return
 	}
-	if countemail > 0 {
-		handleError("email is already taken", http.StatusBadRequest, response)
+	if err = checkEmailAvailable(email); err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
 		return
 	}
 	passwordhashed, err := bcrypt.GenerateFromPassword([]byte(password), numHashes)
@@ -157,90 +243,15 @@ func register(c *gin.Context) {
 		handleError("error sending email verification: got status code "+strconv.Itoa(emailres.StatusCode), http.StatusBadRequest, response)
 		return
 	}
-	userID := primitive.NewObjectID()
-	defaultProduct, err := getProduct(primitive.NilObjectID, true)
-	if err != nil {
-		handleError(err.Error(), http.StatusBadRequest, response)
-		return
-	}
-	var foundCurrency = false
-	var planStripeID string
-	for _, currencyData := range defaultProduct.Plans[0].Currencies {
-		if currencyData.Currency == defaultCurrency {
-			foundCurrency = true
-			planStripeID = currencyData.StripeID
-			break
-		}
-	}
-	if !foundCurrency {
-		handleError("cannot find default currency for default plan", http.StatusBadRequest, response)
-		return
-	}
-	customerParams := &stripe.CustomerParams{
-		Email: &email,
-		Params: stripe.Params{
-			Metadata: map[string]string{
-				"id": userID.Hex(),
-			},
-		},
-	}
-	newCustomer, err := stripeClient.Customers.New(customerParams)
-	if err != nil {
-		handleError(err.Error(), http.StatusBadRequest, response)
-		return
-	}
-	now := time.Now()
-	subscriptionParams := &stripe.SubscriptionParams{
-		Customer: &newCustomer.ID,
-		Items: []*stripe.SubscriptionItemsParams{&stripe.SubscriptionItemsParams{
-			Plan: &planStripeID,
-		},
-		},
-	}
-	stripeSubscription, err := stripeClient.Subscriptions.New(subscriptionParams)
+	userID, err := createAccount(email, string(passwordhashed), false)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
 	}
-	accountData := bson.M{
-		"_id":           userID,
-		"email":         email,
-		"password":      string(passwordhashed),
-		"emailverified": false,
-		"type":          userType,
-		"updated":       now.Unix(),
-		"categories":    bson.A{},
-		"tags":          bson.A{},
-		"stripeids": bson.M{
-			defaultCurrency: bson.M{
-				"customer": newCustomer.ID,
-				"payment":  "",
-			},
-		},
-		"plan":           defaultProduct.ID,
-		"subscriptionid": stripeSubscription.ID,
-		"purchases":      bson.A{},
-		"storage":        int64(0),
-		"billing": bson.M{
-			"firstName": "",
-			"lastName":  "",
-			"company":   "",
-			"address1":  "",
-			"city":      "",
-			"state":     "",
-			"zip":       "",
-			"country":   defaultCountry,
-			"phone":     "",
-			"email":     email,
-			"currency":  defaultCurrency,
-		},
-	}
-	res, err := userCollection.InsertOne(ctxMongo, accountData)
-	if err != nil {
-		handleError("error inserting user to database: "+err.Error(), http.StatusBadRequest, response)
-		return
-	}
-	id := res.InsertedID.(primitive.ObjectID).Hex()
+	id := userID.Hex()
+	recordAudit(auditRegisterAction, id, userType, id, id, c.ClientIP(), bson.M{
+		"email": email,
+	})
 	logger.Info("User register",
 		zap.String("id", id),
 		zap.String("email", registerdata["email"].(string)),
@@ -255,6 +266,9 @@ func register(c *gin.Context) {
  * @apiParam {String} email User email
  * @apiParam {String} password User password
  * @apiSuccess {String} token User token for authenticated requests
+ * @apiSuccess {String} refreshToken Token to get a new user token with /refreshToken
+ * @apiSuccess {Boolean} totpRequired Two factor authentication is enabled, finish at /loginTOTP
+ * @apiSuccess {String} totpToken Token for /loginTOTP
  * @apiGroup authentication
  */
 func loginEmailPassword(c *gin.Context) {
@@ -302,11 +316,19 @@ func loginEmailPassword(c *gin.Context) {
 		handleError("recaptcha token cannot be cast to string", http.StatusBadRequest, response)
 		return
 	}
+	if err = checkLoginAllowed(email, c.ClientIP()); err != nil {
+		handleError(err.Error(), http.StatusTooManyRequests, response)
+		return
+	}
 	err = verifyRecaptcha(recaptchatoken, mainRecaptchaSecret)
 	if err != nil {
 		handleError("recaptcha error: "+err.Error(), http.StatusUnauthorized, response)
 		return
 	}
+	if err = checkSSORequired(email); err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
+		return
+	}
 	cursor, err := userCollection.Find(ctxMongo, bson.M{
 		"email": email,
 	})
@@ -330,35 +352,47 @@ func loginEmailPassword(c *gin.Context) {
 		}
 		err = bcrypt.CompareHashAndPassword([]byte(userData["password"].(string)), []byte(password))
 		if err != nil {
+			if err := recordLoginFailure(email, c.ClientIP()); err != nil {
+				logger.Error("cannot record login failure: " + err.Error())
+			}
 			handleError("invalid password: "+err.Error(), http.StatusUnauthorized, response)
 			return
 		}
+		if err = clearLoginFailures(email, false); err != nil {
+			logger.Error("cannot clear login failures: " + err.Error())
+		}
 		id := userData["_id"].(primitive.ObjectID).Hex()
-		expirationTime := time.Now().Add(time.Duration(tokenExpiration) * time.Hour)
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, loginClaims{
-			id,
-			userData["email"].(string),
-			userData["type"].(string),
-			userData["plan"].(string),
-			jwt.StandardClaims{
-				ExpiresAt: expirationTime.Unix(),
-				Issuer:    jwtIssuer,
-			},
-		})
-		tokenString, err := token.SignedString(jwtSecret)
+		if totpData, ok := userData["totp"].(bson.D); ok {
+			if enabled, _ := totpData.Map()["enabled"].(bool); enabled {
+				totpToken, err := getTOTPLoginToken(id)
+				if err != nil {
+					handleError("error creating totp token: "+err.Error(), http.StatusBadRequest, response)
+					return
+				}
+				response.Header().Set("Content-Type", "application/json")
+				response.Write([]byte(`{ "totpRequired": true, "totpToken": "` + totpToken + `" }`))
+				foundstuff = true
+				break
+			}
+		}
+		tokenString, refreshToken, err := createSession(userData["_id"].(primitive.ObjectID), getRequestDevice(request), c.ClientIP())
 		if err != nil {
-			handleError("error creating token: "+err.Error(), http.StatusBadRequest, response)
+			handleError("error creating session: "+err.Error(), http.StatusBadRequest, response)
 			return
 		}
+		recordAudit(auditLoginAction, id, userType, id, id, c.ClientIP(), nil)
 		logger.Info("User login",
 			zap.String("id", id),
 		)
 		response.Header().Set("Content-Type", "application/json")
-		response.Write([]byte(`{ "token": "` + tokenString + `" }`))
+		response.Write([]byte(`{ "token": "` + tokenString + `", "refreshToken": "` + refreshToken + `" }`))
 		foundstuff = true
 		break
 	}
 	if !foundstuff {
+		if err := recordLoginFailure(email, c.ClientIP()); err != nil {
+			logger.Error("cannot record login failure: " + err.Error())
+		}
 		handleError("no user data found", http.StatusBadRequest, response)
 	}
 }
@@ -390,12 +424,7 @@ func verifyEmail(c *gin.Context) {
 		handleError("token cannot be cast to string", http.StatusBadRequest, response)
 		return
 	}
-	token, err := jwt.Parse(giventoken, func(token *jwt.Token) (interface{}, error) {
-		if _, success := token.Method.(*jwt.SigningMethodHMAC); !success {
-			return nil, errors.New("There was an error")
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.Parse(giventoken, getTokenKey)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
@@ -499,6 +528,10 @@ func resetPassword(c *gin.Context) {
 		handleError("reset http method not POST", http.StatusBadRequest, response)
 		return
 	}
+	if err := checkSlidingRateLimit("reset-ip-"+c.ClientIP(), resetIPLimit, time.Duration(resetWindow)*time.Minute); err != nil {
+		handleError(err.Error(), http.StatusTooManyRequests, response)
+		return
+	}
 	var resetdata map[string]interface{}
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -528,12 +561,7 @@ func resetPassword(c *gin.Context) {
 		handleError("password cannot be cast to string", http.StatusBadRequest, response)
 		return
 	}
-	token, err := jwt.Parse(giventoken, func(token *jwt.Token) (interface{}, error) {
-		if _, success := token.Method.(*jwt.SigningMethodHMAC); !success {
-			return nil, errors.New("There was an error")
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.Parse(giventoken, getTokenKey)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
@@ -617,6 +645,16 @@ func resetPassword(c *gin.Context) {
 			return
 		}
 		idStr := id.Hex()
+		// sessions from before the reset could be stolen
+		if err = revokeUserSessions(idStr, ""); err != nil {
+			handleError("error revoking sessions: "+err.Error(), http.StatusBadRequest, response)
+			return
+		}
+		// the owner of the email reset the password, so earlier failures do not matter anymore
+		if err = clearLoginFailures(email, true); err != nil {
+			logger.Error("cannot clear login failures: " + err.Error())
+		}
+		recordAudit(auditPasswordResetAction, idStr, userType, idStr, idStr, c.ClientIP(), nil)
 		logger.Info("User password reset",
 			zap.String("id", idStr),
 			zap.String("email", userData["email"].(string)),
@@ -636,9 +674,10 @@ func getTokenData(t string) (jwt.MapClaims, error) {
 	if t == "" {
 		return nil, errors.New("Authorization token must be present")
 	}
-	token, err := jwt.ParseWithClaims(t, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	if isAPIKey(t) {
+		return getAPIKeyClaims(t)
+	}
+	token, err := jwt.ParseWithClaims(t, jwt.MapClaims{}, getTokenKey)
 	if err != nil || !token.Valid {
 		return nil, err
 	}
@@ -646,6 +685,19 @@ func getTokenData(t string) (jwt.MapClaims, error) {
 	if !ok {
 		return nil, errors.New("unable to parse token claims")
 	}
+	// tokens with a kind are handed out for one purpose, and are parsed by their own code
+	if kind, _ := claims["kind"].(string); len(kind) > 0 {
+		return nil, errors.New("token cannot be used for authorization")
+	}
+	// totp login tokens only work for finishing the login
+	if isTOTP, _ := claims["totp"].(bool); isTOTP {
+		return nil, errors.New("two factor authentication not finished")
+	}
+	if sessionIDString, ok := claims["session"].(string); ok && len(sessionIDString) > 0 {
+		if err = checkSession(sessionIDString); err != nil {
+			return nil, err
+		}
+	}
 	return claims, nil
 }
 
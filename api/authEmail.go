@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -20,9 +19,52 @@ var sendgridAPIUrl = "https://api.sendgrid.com"
 
 var sendgridAPIPath = "/v3"
 
+func sendEmail(email string, subject string, content string) (*rest.Response, error) {
+	return sendEmailFrom(serviceEmail, email, subject, content)
+}
+
+func sendEmailFrom(from string, email string, subject string, content string) (*rest.Response, error) {
+	request := sendgrid.GetRequest(sendgridAPIKey, sendgridAPIPath+"/mail/send", sendgridAPIUrl)
+	request.Method = "POST"
+	bodyJSON := map[string]interface{}{
+		"personalizations": []interface{}{
+			map[string]interface{}{
+				"to": []interface{}{
+					map[string]interface{}{
+						"email": email,
+					},
+				},
+				"subject": subject,
+			},
+		},
+		"from": map[string]interface{}{
+			"email": from,
+		},
+		"content": []interface{}{
+			map[string]interface{}{
+				"type":  "text/html",
+				"value": content,
+			},
+		},
+	}
+	bodyBytes, err := json.Marshal(bodyJSON)
+	if err != nil {
+		return nil, err
+	}
+	request.Body = bodyBytes
+	response, err := sendgrid.API(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 202 {
+		return nil, errors.New("invalid response code from email: " + strconv.Itoa(response.StatusCode) + ", body: " + response.Body)
+	}
+	return response, nil
+}
+
 func sendEmailVerification(email string) (*rest.Response, error) {
 	expirationTime := time.Now().Add(time.Duration(tokenExpiration) * time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	tokenString, err := signToken(jwt.MapClaims{
 		"email":  email,
 		"verify": true,
 		"StandardClaims": jwt.StandardClaims{
@@ -30,7 +72,6 @@ func sendEmailVerification(email string) (*rest.Response, error) {
 			Issuer:    jwtIssuer,
 		},
 	})
-	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
 		return nil, err
 	}
@@ -50,45 +91,10 @@ func sendEmailVerification(email string) (*rest.Response, error) {
 	// create http stream response to get upload updates from aws: https://github.com/gin-gonic/gin/issues/285
 	doc.Find("#verify").SetAttr("href", websiteURL+"/login?verify=true&token="+tokenString)
 	template, err := doc.Html()
-	request := sendgrid.GetRequest(sendgridAPIKey, sendgridAPIPath+"/mail/send", sendgridAPIUrl)
-	request.Method = "POST"
-	body := `
-	{
-		"personalizations": [
-			{
-				"to": [
-					{
-						"email": "%s"
-					}
-				],
-				"subject": "Please Verify Email"
-			}
-		],
-		"from": {
-			"email": "%s"
-		},
-		"content": [
-			{
-				"type": "text/html",
-				"value": ""
-			}
-		]
-	}
-	`
-	body = fmt.Sprintf(body, email, serviceEmail)
-	var bodyJSON map[string]interface{}
-	err = json.Unmarshal([]byte(body), &bodyJSON)
 	if err != nil {
 		return nil, err
 	}
-	bodyJSON["content"].([]interface{})[0].(map[string]interface{})["value"] = template
-	bodyBytes, err := json.Marshal(bodyJSON)
-	if err != nil {
-		return nil, err
-	}
-	request.Body = bodyBytes
-	response, err := sendgrid.API(request)
-	return response, err
+	return sendEmail(email, "Please Verify Email", template)
 }
 
 /**
@@ -130,13 +136,21 @@ func sendPasswordResetEmail(c *gin.Context) {
 		handleError("recaptcha token cannot be cast to string", http.StatusBadRequest, response)
 		return
 	}
+	if err = checkSlidingRateLimit("reset-email-ip-"+c.ClientIP(), resetEmailIPLimit, time.Duration(resetEmailWindow)*time.Minute); err != nil {
+		handleError(err.Error(), http.StatusTooManyRequests, response)
+		return
+	}
+	if err = checkSlidingRateLimit("reset-email-"+getLoginProtectionKey(email), resetEmailLimit, time.Duration(resetEmailWindow)*time.Minute); err != nil {
+		handleError(err.Error(), http.StatusTooManyRequests, response)
+		return
+	}
 	err = verifyRecaptcha(recaptchatoken, mainRecaptchaSecret)
 	if err != nil {
 		handleError("recaptcha error: "+err.Error(), http.StatusUnauthorized, response)
 		return
 	}
 	expirationTime := time.Now().Add(time.Duration(tokenExpiration) * time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	tokenString, err := signToken(jwt.MapClaims{
 		"email": email,
 		"reset": true,
 		"StandardClaims": jwt.StandardClaims{
@@ -144,7 +158,6 @@ func sendPasswordResetEmail(c *gin.Context) {
 			Issuer:    jwtIssuer,
 		},
 	})
-	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
@@ -166,58 +179,14 @@ func sendPasswordResetEmail(c *gin.Context) {
 	}
 	doc.Find("#reset").SetAttr("href", websiteURL+"/reset?reset=true&token="+tokenString)
 	template, err := doc.Html()
-	req := sendgrid.GetRequest(sendgridAPIKey, sendgridAPIPath+"/mail/send", sendgridAPIUrl)
-	req.Method = "POST"
-	body := `
-	{
-		"personalizations": [
-			{
-				"to": [
-					{
-						"email": "%s"
-					}
-				],
-				"subject": "Reset Password"
-			}
-		],
-		"from": {
-			"email": "%s"
-		},
-		"content": [
-			{
-				"type": "text/html",
-				"value": ""
-			}
-		]
-	}
-	`
-	body = fmt.Sprintf(body, email, serviceEmail)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
 	}
-	var bodyJSON map[string]interface{}
-	err = json.Unmarshal([]byte(body), &bodyJSON)
-	if err != nil {
-		handleError(err.Error(), http.StatusBadRequest, response)
-		return
-	}
-	bodyJSON["content"].([]interface{})[0].(map[string]interface{})["value"] = template
-	bodyBytes, err := json.Marshal(bodyJSON)
-	if err != nil {
-		handleError(err.Error(), http.StatusBadRequest, response)
-		return
-	}
-	req.Body = bodyBytes
-	res1, err := sendgrid.API(req)
-	if err != nil {
+	if _, err = sendEmail(email, "Reset Password", template); err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
 	}
-	if res1.StatusCode != 202 {
-		handleError("invalid response code from email: "+strconv.Itoa(res1.StatusCode)+", body: "+res1.Body, http.StatusBadRequest, response)
-		return
-	}
 	response.Header().Set("Content-Type", "application/json")
 	response.Write([]byte(`{"message":"reset email sent"}`))
 }
@@ -283,52 +252,27 @@ func sendTestEmail(c *gin.Context) {
 	}
 	doc.Find("#content").SetHtml(emaildata["content"].(string))
 	template, err := doc.Html()
-	req := sendgrid.GetRequest(sendgridAPIKey, sendgridAPIPath+"/mail/send", sendgridAPIUrl)
-	req.Method = "POST"
-	body := `
-	{
-		"personalizations": [
-			{
-				"to": [
-					{
-						"email": "%s"
-					}
-				],
-				"subject": "%s"
-			}
-		],
-		"from": {
-			"email": "%s"
-		},
-		"content": [
-			{
-				"type": "text/html",
-				"value": ""
-			}
-		]
-	}
-	`
-	body = fmt.Sprintf(body, emaildata["to"], emaildata["subject"], emaildata["from"])
-	var bodyJSON map[string]interface{}
-	err = json.Unmarshal([]byte(body), &bodyJSON)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
 	}
-	bodyJSON["content"].([]interface{})[0].(map[string]interface{})["value"] = template
-	bodyBytes, err := json.Marshal(bodyJSON)
-	if err != nil {
-		handleError(err.Error(), http.StatusBadRequest, response)
+	to, ok := emaildata["to"].(string)
+	if !ok {
+		handleError("to cannot be cast to string", http.StatusBadRequest, response)
 		return
 	}
-	req.Body = bodyBytes
-	res1, err := sendgrid.API(req)
-	if err != nil {
-		handleError(err.Error(), http.StatusBadRequest, response)
+	from, ok := emaildata["from"].(string)
+	if !ok {
+		handleError("from cannot be cast to string", http.StatusBadRequest, response)
 		return
 	}
-	if res1.StatusCode != 202 {
-		handleError("invalid response code from email: "+strconv.Itoa(res1.StatusCode)+", body: "+res1.Body, http.StatusBadRequest, response)
+	subject, ok := emaildata["subject"].(string)
+	if !ok {
+		handleError("subject cannot be cast to string", http.StatusBadRequest, response)
+		return
+	}
+	if _, err = sendEmailFrom(from, to, subject, template); err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
 		return
 	}
 	response.Header().Set("Content-Type", "application/json")
@@ -4,8 +4,6 @@ import (
 	"errors"
 
 	"github.com/graphql-go/graphql"
-	"github.com/graphql-go/graphql/language/ast"
-	json "github.com/json-iterator/go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -66,38 +64,7 @@ var collaborationFields = graphql.Fields{
 			},
 		},
 		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-			data := params.Context.Value(dataKey)
-			if data != nil {
-				var dataObj map[string]interface{}
-				if err := json.UnmarshalFromString(data.(string), &dataObj); err != nil {
-					return nil, err
-				}
-				return dataObj, nil
-			} else if params.Context.Value(getConnectionIDKey) != nil {
-				connectionIDString, ok := params.Context.Value(getConnectionIDKey).(string)
-				if !ok {
-					return nil, errors.New("cannot cast connectionID to string")
-				}
-				return map[string]interface{}{
-					"id": "connection-" + connectionIDString,
-				}, nil
-			} else if params.Context.Value(getTokenKey) != nil {
-				fieldarray := params.Info.FieldASTs
-				fieldselections := fieldarray[0].SelectionSet.Selections
-				var foundIDField = false
-				for _, field := range fieldselections {
-					fieldast, ok := field.(*ast.Field)
-					if !ok {
-						return nil, errors.New("field cannot be converted to *ast.FIeld")
-					}
-					if fieldast.Name.Value == "id" {
-						foundIDField = true
-						break
-					}
-				}
-				if !foundIDField {
-					return nil, errors.New("you must query id field, even if you don't use it")
-				}
+			return resolveSubscription(params, func(setup *SubscriptionSetup) (interface{}, error) {
 				if params.Args["updatesAccessToken"] == nil {
 					return nil, errors.New("cannot find update token")
 				}
@@ -105,7 +72,7 @@ var collaborationFields = graphql.Fields{
 				if !ok {
 					return nil, errors.New("cannot cast token to string")
 				}
-				tokenFormIDString, _, _, err := getFormUpdateClaimsData(updatesAccessTokenString, viewAccessLevel)
+				tokenFormIDString, userIDString, connectionIDString, err := getFormUpdateClaimsData(updatesAccessTokenString, viewAccessLevel)
 				if err != nil {
 					return nil, err
 				}
@@ -123,13 +90,25 @@ var collaborationFields = graphql.Fields{
 				if givenFormIDString != tokenFormIDString {
 					return nil, errors.New("token form id does not match given form id")
 				}
-				formData := map[string]interface{}{
-					"id": updatesAccessTokenString,
+				presence := &FormPresence{
+					Connection: connectionIDString,
+					User:       userIDString,
+				}
+				setup.Topic = updateFormPath + givenFormIDString
+				setup.Key = connectionIDString
+				setup.OnStart = func() error {
+					return joinFormPresence(givenFormIDString, presence)
+				}
+				setup.OnAlive = func() error {
+					return touchFormPresence(givenFormIDString, connectionIDString, userIDString)
+				}
+				setup.OnStop = func() error {
+					return removeFormPresence(givenFormIDString, presence)
 				}
-				return formData, nil
-			} else {
-				return nil, nil
-			}
+				return map[string]interface{}{
+					"id": "connection-" + connectionIDString,
+				}, nil
+			})
 		},
 	},
 }
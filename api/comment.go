@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var commentChangedPath = "comment-changed-"
+
+var commentEmailTemplate = template.Must(template.ParseFiles("templates/commentEmail.html"))
+
+// Comment comment on a form item. comments starting a thread have no thread id,
+// replies have the id of the comment starting the thread
+type Comment struct {
+	ID         string   `json:"id"`
+	Form       string   `json:"form"`
+	Item       string   `json:"item"`
+	Thread     string   `json:"thread"`
+	Author     string   `json:"author"`
+	Text       string   `json:"text"`
+	Mentions   []string `json:"mentions"`
+	Resolved   bool     `json:"resolved"`
+	ResolvedBy string   `json:"resolvedby"`
+	Created    int64    `json:"created"`
+	Updated    int64    `json:"updated"`
+}
+
+// CommentType graphql comment object
+var CommentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Comment",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"form": &graphql.Field{
+			Type: graphql.String,
+		},
+		"item": &graphql.Field{
+			Type:        graphql.String,
+			Description: "id of the form item",
+		},
+		"thread": &graphql.Field{
+			Type:        graphql.String,
+			Description: "id of the comment starting the thread, empty for the first comment",
+		},
+		"author": &graphql.Field{
+			Type: graphql.String,
+		},
+		"text": &graphql.Field{
+			Type: graphql.String,
+		},
+		"mentions": &graphql.Field{
+			Type: graphql.NewList(graphql.String),
+		},
+		"resolved": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+		"resolvedby": &graphql.Field{
+			Type: graphql.String,
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"updated": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+// CommentChangeType change to a comment for subscriptions
+var CommentChangeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "CommentChange",
+	Fields: graphql.Fields{
+		"type": &graphql.Field{
+			Type:        graphql.String,
+			Description: "add, update or delete",
+		},
+		"comment": &graphql.Field{
+			Type: CommentType,
+		},
+	},
+})
+
+// CommentEmailData mention email object
+type CommentEmailData struct {
+	Form     *Form    `json:"form"`
+	Comment  *Comment `json:"comment"`
+	Author   string   `json:"author"`
+	Question string   `json:"question"`
+	Link     string   `json:"link"`
+}
+
+func getComment(commentID primitive.ObjectID) (*Comment, error) {
+	var comment Comment
+	err := commentCollection.FindOne(ctxMongo, bson.M{
+		"_id": commentID,
+	}).Decode(&comment)
+	if err != nil {
+		return nil, err
+	}
+	comment.ID = commentID.Hex()
+	return &comment, nil
+}
+
+// checkFormMember returns an error if the account cannot view the form through its own, its groups' or the project's access
+func checkFormMember(form *Form, accountIDString string) error {
+	accessType, err := getUserAccessType(form.Access.(map[string]bson.M), accountIDString)
+	if err != nil {
+		return err
+	}
+	if findInArray(accessType, viewAccessLevel) {
+		return nil
+	}
+	projectID, err := primitive.ObjectIDFromHex(form.Project)
+	if err != nil {
+		return err
+	}
+	project, err := getProject(projectID, false)
+	if err != nil {
+		return err
+	}
+	accessType, err = getUserAccessType(project.Access.(map[string]bson.M), accountIDString)
+	if err != nil {
+		return err
+	}
+	if findInArray(accessType, viewAccessLevel) {
+		return nil
+	}
+	return errors.New("mentioned user " + accountIDString + " does not have access to the form")
+}
+
+func getCommentMentions(form *Form, params graphql.ResolveParams) ([]string, error) {
+	mentions := []string{}
+	if params.Args["mentions"] == nil {
+		return mentions, nil
+	}
+	mentionsInterface, ok := params.Args["mentions"].([]interface{})
+	if !ok {
+		return nil, errors.New("problem casting mentions to interface array")
+	}
+	for _, mentionInterface := range mentionsInterface {
+		mention, ok := mentionInterface.(string)
+		if !ok {
+			return nil, errors.New("problem casting mention to string")
+		}
+		if findInArray(mention, mentions) {
+			continue
+		}
+		if err := checkFormMember(form, mention); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, nil
+}
+
+func getCommentText(params graphql.ResolveParams) (string, error) {
+	if params.Args["text"] == nil {
+		return "", errors.New("comment text not provided")
+	}
+	text, ok := params.Args["text"].(string)
+	if !ok {
+		return "", errors.New("cannot cast text to string")
+	}
+	if len(text) == 0 {
+		return "", errors.New("comment text is empty")
+	}
+	if len(text) > commentMaxLength {
+		return "", errors.New("comment text is too long")
+	}
+	return text, nil
+}
+
+// getCommentFormAccess checks the user can view the form the comment is on, and returns the user id
+func getCommentFormAccess(params graphql.ResolveParams, formIDString string) (*Form, string, error) {
+	formID, err := primitive.ObjectIDFromHex(formIDString)
+	if err != nil {
+		return nil, "", err
+	}
+	var accessKey = ""
+	if params.Args["accessKey"] != nil {
+		var ok bool
+		accessKey, ok = params.Args["accessKey"].(string)
+		if !ok {
+			return nil, "", errors.New("cannot cast access key to string")
+		}
+	}
+	accessToken := params.Context.Value(tokenKey).(string)
+	form, err := checkFormAccess(formID, accessToken, accessKey, viewAccessLevel, false)
+	if err != nil {
+		return nil, "", err
+	}
+	claims, err := getTokenData(accessToken)
+	if err != nil {
+		return nil, "", errors.New("you need to be logged in to comment")
+	}
+	userIDString, ok := claims["id"].(string)
+	if !ok {
+		return nil, "", errors.New("cannot cast user id to string")
+	}
+	return form, userIDString, nil
+}
+
+func getCommentArg(params graphql.ResolveParams) (*Comment, error) {
+	if params.Args["id"] == nil {
+		return nil, errors.New("comment id not provided")
+	}
+	commentIDString, ok := params.Args["id"].(string)
+	if !ok {
+		return nil, errors.New("cannot cast comment id to string")
+	}
+	commentID, err := primitive.ObjectIDFromHex(commentIDString)
+	if err != nil {
+		return nil, err
+	}
+	return getComment(commentID)
+}
+
+func publishCommentChanged(comment *Comment, changeType string) {
+	publishSubscription(commentChangedPath+comment.Form, map[string]interface{}{
+		"type":    changeType,
+		"comment": comment,
+	})
+}
+
+func queueCommentMentions(comment *Comment, mentions []string) {
+	for _, mention := range mentions {
+		if mention == comment.Author {
+			continue
+		}
+		msg := sendCommentMentionTask.WithArgs(ctxMessageQueue, comment.ID, mention)
+		if err := messageQueue.Add(msg); err != nil {
+			logger.Error("cannot queue comment mention email: " + err.Error())
+		}
+	}
+}
+
+func sendCommentMention(commentIDString string, accountIDString string) error {
+	commentID, err := primitive.ObjectIDFromHex(commentIDString)
+	if err != nil {
+		return err
+	}
+	comment, err := getComment(commentID)
+	if err != nil {
+		return err
+	}
+	formID, err := primitive.ObjectIDFromHex(comment.Form)
+	if err != nil {
+		return err
+	}
+	form, err := getForm(formID, false)
+	if err != nil {
+		return err
+	}
+	accountID, err := primitive.ObjectIDFromHex(accountIDString)
+	if err != nil {
+		return err
+	}
+	account, err := getAccount(accountID, false)
+	if err != nil {
+		return err
+	}
+	authorID, err := primitive.ObjectIDFromHex(comment.Author)
+	if err != nil {
+		return err
+	}
+	author, err := getAccount(authorID, false)
+	if err != nil {
+		return err
+	}
+	emailData := &CommentEmailData{
+		Form:    form,
+		Comment: comment,
+		Author:  author.Email,
+		Link:    websiteURL + "/form/" + comment.Form + "?comment=" + comment.ID,
+	}
+	if index := findFormItem(form.Items, comment.Item); index >= 0 {
+		emailData.Question = form.Items[index].Question
+	}
+	var templateData bytes.Buffer
+	if err = commentEmailTemplate.Execute(&templateData, emailData); err != nil {
+		return err
+	}
+	emailContent, err := minifier.String("text/html", templateData.String())
+	if err != nil {
+		return err
+	}
+	_, err = sendEmail(account.Email, "You were mentioned in "+form.Name, emailContent)
+	return err
+}
+
+func deleteFormComments(formIDString string) error {
+	_, err := commentCollection.DeleteMany(ctxMongo, bson.M{
+		"form": formIDString,
+	})
+	return err
+}
+
+var commentQueryFields = graphql.Fields{
+	"comments": &graphql.Field{
+		Type:        graphql.NewList(CommentType),
+		Description: "Get the comments on a form, oldest first",
+		Args: graphql.FieldConfigArgument{
+			"form": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"item": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "only get comments on the given item id",
+			},
+			"resolved": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "only get threads that are or are not resolved",
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			if params.Args["form"] == nil {
+				return nil, errors.New("form id not provided")
+			}
+			formIDString, ok := params.Args["form"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast form id to string")
+			}
+			formID, err := primitive.ObjectIDFromHex(formIDString)
+			if err != nil {
+				return nil, err
+			}
+			var accessKey = ""
+			if params.Args["accessKey"] != nil {
+				accessKey, ok = params.Args["accessKey"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast access key to string")
+				}
+			}
+			accessToken := params.Context.Value(tokenKey).(string)
+			if _, err = checkFormAccess(formID, accessToken, accessKey, viewAccessLevel, false); err != nil {
+				return nil, err
+			}
+			filter := bson.M{
+				"form": formIDString,
+			}
+			if params.Args["item"] != nil {
+				item, ok := params.Args["item"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast item id to string")
+				}
+				filter["item"] = item
+			}
+			if params.Args["resolved"] != nil {
+				resolved, ok := params.Args["resolved"].(bool)
+				if !ok {
+					return nil, errors.New("cannot cast resolved to bool")
+				}
+				filter["resolved"] = resolved
+			}
+			cursor, err := commentCollection.Find(ctxMongo, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			comments := []*Comment{}
+			for cursor.Next(ctxMongo) {
+				var comment Comment
+				if err = cursor.Decode(&comment); err != nil {
+					return nil, err
+				}
+				commentID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find comment id")
+				}
+				comment.ID = commentID.Hex()
+				comments = append(comments, &comment)
+			}
+			return comments, nil
+		},
+	},
+}
+
+var commentMutationFields = graphql.Fields{
+	"addComment": &graphql.Field{
+		Type:        CommentType,
+		Description: "Comment on a form item, or reply to a thread",
+		Args: graphql.FieldConfigArgument{
+			"form": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"item": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "id of the form item, not needed for replies",
+			},
+			"thread": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "id of the comment starting the thread to reply to",
+			},
+			"text": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"mentions": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(graphql.String),
+				Description: "ids of users with access to the form",
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			if params.Args["form"] == nil {
+				return nil, errors.New("form id not provided")
+			}
+			formIDString, ok := params.Args["form"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast form id to string")
+			}
+			form, userIDString, err := getCommentFormAccess(params, formIDString)
+			if err != nil {
+				return nil, err
+			}
+			text, err := getCommentText(params)
+			if err != nil {
+				return nil, err
+			}
+			mentions, err := getCommentMentions(form, params)
+			if err != nil {
+				return nil, err
+			}
+			comment := &Comment{
+				Form:     formIDString,
+				Author:   userIDString,
+				Text:     text,
+				Mentions: mentions,
+			}
+			if params.Args["thread"] != nil {
+				threadIDString, ok := params.Args["thread"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast thread id to string")
+				}
+				threadID, err := primitive.ObjectIDFromHex(threadIDString)
+				if err != nil {
+					return nil, err
+				}
+				thread, err := getComment(threadID)
+				if err != nil {
+					return nil, err
+				}
+				if thread.Form != formIDString || len(thread.Thread) > 0 {
+					return nil, errors.New("invalid thread given")
+				}
+				comment.Thread = threadIDString
+				comment.Item = thread.Item
+			} else {
+				if params.Args["item"] == nil {
+					return nil, errors.New("item id not provided")
+				}
+				comment.Item, ok = params.Args["item"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast item id to string")
+				}
+				items, err := getFormEditingItems(formIDString)
+				if err != nil {
+					return nil, err
+				}
+				if findFormItem(items, comment.Item) < 0 {
+					return nil, errors.New("cannot find item in form")
+				}
+			}
+			commentID := primitive.NewObjectID()
+			comment.ID = commentID.Hex()
+			comment.Created = objectidTimestamp(commentID).Unix()
+			comment.Updated = comment.Created
+			_, err = commentCollection.InsertOne(ctxMongo, bson.M{
+				"_id":        commentID,
+				"form":       comment.Form,
+				"item":       comment.Item,
+				"thread":     comment.Thread,
+				"author":     comment.Author,
+				"text":       comment.Text,
+				"mentions":   comment.Mentions,
+				"resolved":   false,
+				"resolvedby": "",
+				"created":    comment.Created,
+				"updated":    comment.Updated,
+			})
+			if err != nil {
+				return nil, err
+			}
+			publishCommentChanged(comment, validCommentChangeTypes[0])
+			queueCommentMentions(comment, mentions)
+			return comment, nil
+		},
+	},
+	"updateComment": &graphql.Field{
+		Type:        CommentType,
+		Description: "Change the text of your comment",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"text": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"mentions": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(graphql.String),
+				Description: "ids of users with access to the form",
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			comment, err := getCommentArg(params)
+			if err != nil {
+				return nil, err
+			}
+			form, userIDString, err := getCommentFormAccess(params, comment.Form)
+			if err != nil {
+				return nil, err
+			}
+			if comment.Author != userIDString {
+				return nil, errors.New("you can only change your own comments")
+			}
+			text, err := getCommentText(params)
+			if err != nil {
+				return nil, err
+			}
+			mentions, err := getCommentMentions(form, params)
+			if err != nil {
+				return nil, err
+			}
+			newMentions := []string{}
+			for _, mention := range mentions {
+				if !findInArray(mention, comment.Mentions) {
+					newMentions = append(newMentions, mention)
+				}
+			}
+			comment.Text = text
+			comment.Mentions = mentions
+			comment.Updated = time.Now().Unix()
+			commentID, err := primitive.ObjectIDFromHex(comment.ID)
+			if err != nil {
+				return nil, err
+			}
+			_, err = commentCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": commentID,
+			}, bson.M{
+				"$set": bson.M{
+					"text":     comment.Text,
+					"mentions": comment.Mentions,
+					"updated":  comment.Updated,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			publishCommentChanged(comment, validCommentChangeTypes[1])
+			queueCommentMentions(comment, newMentions)
+			return comment, nil
+		},
+	},
+	"resolveComment": &graphql.Field{
+		Type:        CommentType,
+		Description: "Resolve or reopen a comment thread",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "id of the comment starting the thread",
+			},
+			"resolved": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			comment, err := getCommentArg(params)
+			if err != nil {
+				return nil, err
+			}
+			if len(comment.Thread) > 0 {
+				return nil, errors.New("only the comment starting a thread can be resolved")
+			}
+			_, userIDString, err := getCommentFormAccess(params, comment.Form)
+			if err != nil {
+				return nil, err
+			}
+			if params.Args["resolved"] == nil {
+				return nil, errors.New("resolved not provided")
+			}
+			resolved, ok := params.Args["resolved"].(bool)
+			if !ok {
+				return nil, errors.New("cannot cast resolved to bool")
+			}
+			comment.Resolved = resolved
+			comment.ResolvedBy = ""
+			if resolved {
+				comment.ResolvedBy = userIDString
+			}
+			comment.Updated = time.Now().Unix()
+			commentID, err := primitive.ObjectIDFromHex(comment.ID)
+			if err != nil {
+				return nil, err
+			}
+			// replies follow the thread, so they can be filtered the same way
+			_, err = commentCollection.UpdateMany(ctxMongo, bson.M{
+				"$or": bson.A{
+					bson.M{
+						"_id": commentID,
+					},
+					bson.M{
+						"thread": comment.ID,
+					},
+				},
+			}, bson.M{
+				"$set": bson.M{
+					"resolved":   comment.Resolved,
+					"resolvedby": comment.ResolvedBy,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			_, err = commentCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": commentID,
+			}, bson.M{
+				"$set": bson.M{
+					"updated": comment.Updated,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			publishCommentChanged(comment, validCommentChangeTypes[1])
+			return comment, nil
+		},
+	},
+	"deleteComment": &graphql.Field{
+		Type:        CommentType,
+		Description: "Delete a comment, and its replies if it starts a thread",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			comment, err := getCommentArg(params)
+			if err != nil {
+				return nil, err
+			}
+			_, userIDString, err := getCommentFormAccess(params, comment.Form)
+			if err != nil {
+				return nil, err
+			}
+			if comment.Author != userIDString {
+				// editors can clean up comments from anyone
+				formID, err := primitive.ObjectIDFromHex(comment.Form)
+				if err != nil {
+					return nil, err
+				}
+				var accessKey = ""
+				if params.Args["accessKey"] != nil {
+					accessKey = params.Args["accessKey"].(string)
+				}
+				if _, err = checkFormAccess(formID, params.Context.Value(tokenKey).(string), accessKey, editAccessLevel, false); err != nil {
+					return nil, errors.New("you can only delete your own comments")
+				}
+			}
+			commentID, err := primitive.ObjectIDFromHex(comment.ID)
+			if err != nil {
+				return nil, err
+			}
+			_, err = commentCollection.DeleteMany(ctxMongo, bson.M{
+				"$or": bson.A{
+					bson.M{
+						"_id": commentID,
+					},
+					bson.M{
+						"thread": comment.ID,
+					},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			publishCommentChanged(comment, validCommentChangeTypes[2])
+			return comment, nil
+		},
+	},
+}
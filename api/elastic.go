@@ -9,35 +9,49 @@ var addRemoveAccessScript string
 
 // change it to a map and then everything falls into place
 // eventually save this script so that it can be accessed more easily
+// group entries are keyed by the group id, so membership changes need no update here
 func initAddRemoveAccessScript() {
 	// update all categories and tags at the same time.
 	addRemoveAccessScript = strings.ReplaceAll(fmt.Sprintf(`
-	for (int i = 0; i < access.length; i++) {
-		bool cont = true;
-		if (access[i].type != null) {
-			if (access[i].type == '%s') {
-				if (ctx._source.access[access[i].id] != null) {
-					ctx._source.access[access[i].id].remove(access[i].id);
-				}
-				cont = false;
+	if (ctx._source.access == null) {
+		ctx._source.access = [:];
+	}
+	for (int i = 0; i < params.access.length; i++) {
+		def accessUser = params.access[i];
+		if (accessUser.type != null) {
+			if (accessUser.type == '%s') {
+				ctx._source.access.remove(accessUser.id);
+			} else if (ctx._source.access[accessUser.id] != null) {
+				ctx._source.access[accessUser.id].type = accessUser.type;
 			} else {
-				if (ctx._source.access[access[i].id] != null) {
-					ctx._source.access[access[i].id].type = access[i].type;
-				} else {
-					ctx._source.access[access[i].id] = {
-						'type': access[i].type
-					}
-				}
+				ctx._source.access[accessUser.id] = [
+					'type': accessUser.type,
+					'group': accessUser.group == true,
+					'categories': [],
+					'tags': []
+				];
 			}
 		}
-		if (cont) {
-			if (access[userIDString].categories != null) {
-				ctx._source.access[userIDString].categories = categories
-			}
-			if (access[userIDString].tags != null) {
-				ctx._source.access[userIDString].tags = tags
-			}
+	}
+	if (ctx._source.access[params.userIDString] != null) {
+		if (params.categories != null) {
+			ctx._source.access[params.userIDString].categories = params.categories;
+		}
+		if (params.tags != null) {
+			ctx._source.access[params.userIDString].tags = params.tags;
 		}
 	}
 	`, noAccessLevel), "\n", "")
 }
+
+// saveFormScript sets the given fields unless the document already has a later save,
+// so saves finishing out of order cannot overwrite newer items
+var saveFormScript = `
+if (ctx._source.seq == null || ctx._source.seq <= params.seq) {
+	for (entry in params.doc.entrySet()) {
+		ctx._source[entry.getKey()] = entry.getValue();
+	}
+} else {
+	ctx.op = 'noop';
+}
+`
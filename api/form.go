@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,6 +19,7 @@ import (
 type Form struct {
 	ID                 string      `json:"id"`
 	Owner              string      `json:"owner"`
+	Org                string      `json:"org"`
 	Responses          int64       `json:"responses"`
 	Created            int64       `json:"created"`
 	Updated            int64       `json:"updated"`
@@ -33,6 +35,16 @@ type Form struct {
 	Categories         []string    `json:"categories"`
 	Files              []*File     `json:"files"`
 	UpdatesAccessToken string      `json:"updatesAccessToken"`
+	EditResponses      bool        `json:"editresponses"`
+	EditWindow         int64       `json:"editwindow"`
+	EmailResponses     bool        `json:"emailresponses"`
+	Anonymous          bool        `json:"anonymous"`
+	Recaptcha          bool        `json:"recaptcha"`
+	MinFillTime        int64       `json:"minfilltime"`
+	RejectedResponses  int64       `json:"rejectedresponses"`
+	DuplicateAction    string      `json:"duplicateaction"`
+	Seq                int64       `json:"seq"`
+	ResponseStartToken string      `json:"responseStartToken"`
 }
 
 // FormType form type object for user forms graphql
@@ -45,6 +57,10 @@ var FormType = graphql.NewObject(graphql.ObjectConfig{
 		"owner": &graphql.Field{
 			Type: graphql.String,
 		},
+		"org": &graphql.Field{
+			Type:        graphql.String,
+			Description: "organization owning it, if any",
+		},
 		"responses": &graphql.Field{
 			Type: graphql.Int,
 		},
@@ -90,6 +106,45 @@ var FormType = graphql.NewObject(graphql.ObjectConfig{
 		"updatesAccessToken": &graphql.Field{
 			Type: graphql.String,
 		},
+		"editresponses": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "respondents can edit their responses",
+		},
+		"editwindow": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "minutes after submission respondents can edit (0 for no limit)",
+		},
+		"emailresponses": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "email respondents a copy of their answers and an edit link",
+		},
+		"anonymous": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "responses are stored without any respondent identity",
+		},
+		"recaptcha": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "require recaptcha verification for responses",
+		},
+		"minfilltime": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "minimum seconds between loading the form and submitting a response",
+		},
+		"rejectedresponses": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "number of response submissions rejected as spam",
+		},
+		"seq": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "sequence number of the last item update included in the items",
+		},
+		"duplicateaction": &graphql.Field{
+			Type:        graphql.String,
+			Description: "reject or flag responses matching another response's unique items",
+		},
+		"responseStartToken": &graphql.Field{
+			Type: graphql.String,
+		},
 	},
 })
 
@@ -112,6 +167,22 @@ var FormUpdateType = graphql.NewObject(graphql.ObjectConfig{
 		"files": &graphql.Field{
 			Type: graphql.NewList(UpdateFileType),
 		},
+		"type": &graphql.Field{
+			Type:        graphql.String,
+			Description: "update, join, leave or focus",
+		},
+		"user": &graphql.Field{
+			Type:        graphql.String,
+			Description: "user behind the connection in id",
+		},
+		"focus": &graphql.Field{
+			Type:        graphql.String,
+			Description: "id of the item the connection is focused on",
+		},
+		"presence": &graphql.Field{
+			Type:        graphql.NewList(FormPresenceType),
+			Description: "everyone in the form, sent when someone joins",
+		},
 	},
 })
 
@@ -138,9 +209,20 @@ func getForm(formID primitive.ObjectID, updated bool) (*Form, error) {
 		form.Updated = time.Now().Unix()
 	}
 	form.ID = formID.Hex()
+	setLegacyFormItemIDs(&form)
 	return &form, nil
 }
 
+// setLegacyFormItemIDs gives items saved before item ids existed an id that stays the same
+// until the form is saved
+func setLegacyFormItemIDs(form *Form) {
+	for i, item := range form.Items {
+		if len(item.ID) == 0 {
+			item.ID = form.ID + "-" + strconv.Itoa(i)
+		}
+	}
+}
+
 func checkFormAccess(formID primitive.ObjectID, accessToken string, accessKey string, necessaryAccess []string, updated bool) (*Form, error) {
 	form, err := getForm(formID, updated)
 	if err != nil {
@@ -159,21 +241,27 @@ func checkFormAccess(formID primitive.ObjectID, accessToken string, accessKey st
 	if claims["type"].(string) == adminType || claims["type"].(string) == superAdminType {
 		return form, nil
 	}
+	if err = checkAPIKeyProject(claims, form.Project); err != nil {
+		return nil, err
+	}
 	// check for valid key
 	if len(accessKey) > 0 {
-		if form.LinkAccess.Secret != accessKey {
-			return nil, errors.New("invalid access key")
-		}
-		if !findInArray(form.LinkAccess.Type, necessaryAccess) {
-			return nil, errors.New("you do not have the necessary access")
+		if err = checkLinkAccess(formCollection, formElasticIndex, formElasticType, formID, form.LinkAccess, accessKey, claims, necessaryAccess); err != nil {
+			return nil, err
 		}
 		return form, nil
 	}
 	var userIDString = claims["id"].(string)
-	for currentUserID := range form.Access.(map[string]bson.M) {
-		if currentUserID == userIDString {
-			return form, nil
-		}
+	if _, ok := form.Access.(map[string]bson.M)[userIDString]; ok {
+		return form, nil
+	}
+	// check if user has access through a group
+	accessType, err := getUserAccessType(form.Access.(map[string]bson.M), userIDString)
+	if err != nil {
+		return nil, err
+	}
+	if findInArray(accessType, necessaryAccess) {
+		return form, nil
 	}
 	// check if user has access to project directly
 	projectID, err := primitive.ObjectIDFromHex(form.Project)
@@ -206,6 +294,10 @@ func countForms(c *gin.Context) {
 		handleError("user not logged in", http.StatusBadRequest, response)
 		return
 	}
+	if err = checkAPIKeyScope(getAuthToken(request), apiKeyFormsReadScope); err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
+		return
+	}
 	userIDString, ok := claims["id"].(string)
 	if !ok {
 		handleError("cannot cast user id to string", http.StatusBadRequest, response)
@@ -241,7 +333,12 @@ func countForms(c *gin.Context) {
 		mustQueries[0] = elastic.NewTermQuery("project", project)
 	} else {
 		// get all shared directly forms (not in a project)
-		mustQueries[0] = elastic.NewTermsQuery(fmt.Sprintf("access.%s.type", userIDString), stringListToInterfaceList(viewAccessLevel)...)
+		accessQuery, err := getAccessQuery(userIDString, viewAccessLevel)
+		if err != nil {
+			handleError(err.Error(), http.StatusBadRequest, response)
+			return
+		}
+		mustQueries[0] = accessQuery
 	}
 	for i, tag := range tags {
 		mustQueries[i+1] = elastic.NewTermQuery(fmt.Sprintf("access.%s.tags", userIDString), tag)
@@ -253,6 +350,9 @@ func countForms(c *gin.Context) {
 	if len(mustQueries) > 0 {
 		query = query.Must(mustQueries...)
 	}
+	if apiKeyProjects := getAPIKeyProjects(claims); len(apiKeyProjects) > 0 {
+		query = query.Filter(elastic.NewTermsQuery("project", stringListToInterfaceList(apiKeyProjects)...))
+	}
 	if len(searchterm) > 0 {
 		mainquery := elastic.NewMultiMatchQuery(searchterm, formSearchFields...)
 		query = query.Filter(mainquery)
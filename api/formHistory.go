@@ -0,0 +1,494 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var formUndoPath = "form-undo-"
+
+var formRedoPath = "form-redo-"
+
+// history entries for form settings use this in place of an item update action
+var formHistorySettingsAction = "form"
+
+// FormHistoryEntry edit applied to a form through updateFormPart
+type FormHistoryEntry struct {
+	ID           string                 `json:"id"`
+	Form         string                 `json:"form"`
+	Seq          int64                  `json:"seq"`
+	User         string                 `json:"user"`
+	Connection   string                 `json:"connection"`
+	Created      int64                  `json:"created"`
+	Source       string                 `json:"source"`
+	UpdateAction string                 `json:"updateAction"`
+	ItemID       string                 `json:"itemID"`
+	AfterID      string                 `json:"afterID"`
+	Item         map[string]interface{} `json:"item"`
+	Name         string                 `json:"name"`
+	Multiple     *bool                  `json:"multiple"`
+	Public       string                 `json:"public"`
+}
+
+// FormHistoryEntryType graphql form history object
+var FormHistoryEntryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "FormHistoryEntry",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"form": &graphql.Field{
+			Type: graphql.String,
+		},
+		"seq": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "sequence number of item updates, 0 for form settings",
+		},
+		"user": &graphql.Field{
+			Type: graphql.String,
+		},
+		"connection": &graphql.Field{
+			Type: graphql.String,
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"source": &graphql.Field{
+			Type:        graphql.String,
+			Description: "edit, undo or redo",
+		},
+		"updateAction": &graphql.Field{
+			Type:        graphql.String,
+			Description: "item update action, or form for form settings",
+		},
+		"itemID": &graphql.Field{
+			Type: graphql.String,
+		},
+		"afterID": &graphql.Field{
+			Type: graphql.String,
+		},
+		"item": &graphql.Field{
+			Type: UpdateFormFormItemType,
+		},
+		"name": &graphql.Field{
+			Type: graphql.String,
+		},
+		"multiple": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+		"public": &graphql.Field{
+			Type: graphql.String,
+		},
+	},
+})
+
+// FormUndoEntry one undo step, with the operations of an update and the operations reverting it
+type FormUndoEntry struct {
+	Operations []*FormItemOperation `json:"operations"`
+	Inverse    []*FormItemOperation `json:"inverse"`
+}
+
+// formItemToFields returns the fields of the item the way they are sent in updates
+func formItemToFields(item *FormItem) (map[string]interface{}, error) {
+	itemJSON, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(itemJSON, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "id")
+	return fields, nil
+}
+
+// getInverseFormItemOperations returns the operations that revert the given
+// operations on the items, in the order they need to be applied
+func getInverseFormItemOperations(items []*FormItem, operations []*FormItemOperation) ([]*FormItemOperation, error) {
+	inverse := []*FormItemOperation{}
+	for _, operation := range operations {
+		index := findFormItem(items, operation.ItemID)
+		var previousID string
+		if index > 0 {
+			previousID = items[index-1].ID
+		}
+		switch operation.UpdateAction {
+		case validUpdateArrayActions[0]:
+			if index < 0 {
+				inverse = append(inverse, &FormItemOperation{
+					UpdateAction: validUpdateArrayActions[1],
+					ItemID:       operation.ItemID,
+				})
+			}
+		case validUpdateArrayActions[1]:
+			if index >= 0 {
+				fields, err := formItemToFields(items[index])
+				if err != nil {
+					return nil, err
+				}
+				inverse = append(inverse, &FormItemOperation{
+					UpdateAction: validUpdateArrayActions[0],
+					ItemID:       operation.ItemID,
+					AfterID:      previousID,
+					Item:         fields,
+				})
+			}
+		case validUpdateArrayActions[2]:
+			if index >= 0 {
+				inverse = append(inverse, &FormItemOperation{
+					UpdateAction: validUpdateArrayActions[2],
+					ItemID:       operation.ItemID,
+					AfterID:      previousID,
+				})
+			}
+		case validUpdateArrayActions[3]:
+			if index >= 0 {
+				currentFields, err := formItemToFields(items[index])
+				if err != nil {
+					return nil, err
+				}
+				fields := map[string]interface{}{}
+				for key := range operation.Item {
+					if value, ok := currentFields[key]; ok {
+						fields[key] = value
+					}
+				}
+				inverse = append(inverse, &FormItemOperation{
+					UpdateAction: validUpdateArrayActions[3],
+					ItemID:       operation.ItemID,
+					Item:         fields,
+				})
+			}
+		}
+		var err error
+		items, err = applyFormItemOperations(items, []*FormItemOperation{operation})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(inverse)-1; i < j; i, j = i+1, j-1 {
+		inverse[i], inverse[j] = inverse[j], inverse[i]
+	}
+	return inverse, nil
+}
+
+// copyFormItemOperations returns new operations without sequence numbers, so they can be pushed again
+func copyFormItemOperations(operations []*FormItemOperation) []*FormItemOperation {
+	operationsCopy := make([]*FormItemOperation, len(operations))
+	for i, operation := range operations {
+		operationCopy := *operation
+		operationCopy.Seq = 0
+		operationsCopy[i] = &operationCopy
+	}
+	return operationsCopy
+}
+
+func recordFormHistory(entries []interface{}) {
+	if len(entries) == 0 {
+		return
+	}
+	if _, err := formHistoryCollection.InsertMany(ctxMongo, entries); err != nil {
+		logger.Error("cannot save form history: " + err.Error())
+	}
+}
+
+func getFormItemHistory(formIDString string, userIDString string, connectionIDString string, source string, operations []*FormItemOperation) []interface{} {
+	now := time.Now().Unix()
+	entries := make([]interface{}, len(operations))
+	for i, operation := range operations {
+		entries[i] = bson.M{
+			"form":         formIDString,
+			"seq":          operation.Seq,
+			"user":         userIDString,
+			"connection":   connectionIDString,
+			"created":      now,
+			"source":       source,
+			"updateAction": operation.UpdateAction,
+			"itemID":       operation.ItemID,
+			"afterID":      operation.AfterID,
+			"item":         operation.Item,
+		}
+	}
+	return entries
+}
+
+// getFormSettingsHistory returns the history entry for changes to the name, multiple or public settings
+func getFormSettingsHistory(formIDString string, userIDString string, connectionIDString string, updateData map[string]interface{}) bson.M {
+	entry := bson.M{
+		"form":         formIDString,
+		"user":         userIDString,
+		"connection":   connectionIDString,
+		"created":      time.Now().Unix(),
+		"source":       validFormHistorySources[0],
+		"updateAction": formHistorySettingsAction,
+	}
+	for _, key := range []string{"name", "multiple", "public"} {
+		if updateData[key] != nil {
+			entry[key] = updateData[key]
+		}
+	}
+	return entry
+}
+
+func pushFormUndoStack(path string, formIDString string, userIDString string, entry *FormUndoEntry) error {
+	entryJSON, err := json.MarshalToString(entry)
+	if err != nil {
+		return err
+	}
+	key := path + formIDString + "-" + userIDString
+	if err = redisClient.LPush(key, entryJSON).Err(); err != nil {
+		return err
+	}
+	return redisClient.LTrim(key, 0, undoLimit-1).Err()
+}
+
+func popFormUndoStack(path string, formIDString string, userIDString string) (*FormUndoEntry, error) {
+	entryJSON, err := redisClient.LPop(path + formIDString + "-" + userIDString).Result()
+	if err != nil {
+		return nil, errors.New("nothing to " + path[len("form-"):len(path)-1])
+	}
+	var entry FormUndoEntry
+	if err = json.UnmarshalFromString(entryJSON, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func clearFormRedoStack(formIDString string, userIDString string) error {
+	return redisClient.Del(formRedoPath + formIDString + "-" + userIDString).Err()
+}
+
+// getFormEditingItems returns the items including updates not saved yet
+func getFormEditingItems(formIDString string) ([]*FormItem, error) {
+	formID, err := primitive.ObjectIDFromHex(formIDString)
+	if err != nil {
+		return nil, err
+	}
+	formData, err := getForm(formID, false)
+	if err != nil {
+		return nil, err
+	}
+	operations, err := getFormItemOperations(formIDString, formData.Seq)
+	if err != nil {
+		return nil, err
+	}
+	return applyFormItemOperations(formData.Items, operations)
+}
+
+// pushFormUpdate logs the update and sends its item operations to the form, and returns
+// the item updates for subscribers
+func pushFormUpdate(formIDString string, userIDString string, connectionIDString string, source string, update *FormUpdateData) ([]map[string]interface{}, error) {
+	if err := logFormUpdate(formIDString, update); err != nil {
+		return nil, err
+	}
+	operations := update.Operations
+	if err := pushFormItemOperations(formIDString, operations); err != nil {
+		return nil, err
+	}
+	recordFormHistory(getFormItemHistory(formIDString, userIDString, connectionIDString, source, operations))
+	itemUpdates := make([]map[string]interface{}, len(operations))
+	for i, operation := range operations {
+		itemUpdate := map[string]interface{}{}
+		for key, value := range operation.Item {
+			itemUpdate[key] = value
+		}
+		itemUpdate["updateAction"] = operation.UpdateAction
+		itemUpdate["itemID"] = operation.ItemID
+		itemUpdate["afterID"] = operation.AfterID
+		itemUpdate["seq"] = operation.Seq
+		itemUpdates[i] = itemUpdate
+	}
+	return itemUpdates, nil
+}
+
+// undoRedoFormPart moves the latest step of the user from one stack to the other, applying
+// the operations that revert it for undo, or the original operations for redo
+func undoRedoFormPart(params graphql.ResolveParams, undo bool) (interface{}, error) {
+	if params.Args["id"] == nil {
+		return nil, errors.New("form id not provided")
+	}
+	formIDString, ok := params.Args["id"].(string)
+	if !ok {
+		return nil, errors.New("cannot cast form id to string")
+	}
+	if params.Args["updatesAccessToken"] == nil {
+		return nil, errors.New("update token not found")
+	}
+	accessToken, ok := params.Args["updatesAccessToken"].(string)
+	if !ok {
+		return nil, errors.New("cannot cast update token to string")
+	}
+	tokenFormIDString, userIDString, connectionIDString, err := getFormUpdateClaimsData(accessToken, editAccessLevel)
+	if err != nil {
+		return nil, err
+	}
+	if tokenFormIDString != formIDString {
+		return nil, errors.New("form id in token does not match given form id")
+	}
+	fromPath, toPath, source := formRedoPath, formUndoPath, validFormHistorySources[2]
+	if undo {
+		fromPath, toPath, source = formUndoPath, formRedoPath, validFormHistorySources[1]
+	}
+	entry, err := popFormUndoStack(fromPath, formIDString, userIDString)
+	if err != nil {
+		return nil, err
+	}
+	operations := entry.Operations
+	if undo {
+		operations = entry.Inverse
+	}
+	itemUpdates, err := pushFormUpdate(formIDString, userIDString, connectionIDString, source, &FormUpdateData{
+		Operations: copyFormItemOperations(operations),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = pushFormUndoStack(toPath, formIDString, userIDString, entry); err != nil {
+		return nil, err
+	}
+	queueFormSave(formIDString)
+	updateData := map[string]interface{}{
+		"id":    connectionIDString,
+		"type":  validFormUpdateTypes[0],
+		"user":  userIDString,
+		"items": itemUpdates,
+	}
+	updateDataJSON, err := json.Marshal(updateData)
+	if err != nil {
+		return nil, err
+	}
+	if err = redisClient.Publish(updateFormPath+formIDString, updateDataJSON).Err(); err != nil {
+		return nil, err
+	}
+	return updateData, nil
+}
+
+var formHistoryMutationFields = graphql.Fields{
+	"undoFormPart": &graphql.Field{
+		Type:        FormUpdateType,
+		Description: "Undo your last item update to a form",
+		Args: graphql.FieldConfigArgument{
+			"updatesAccessToken": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			return undoRedoFormPart(params, true)
+		},
+	},
+	"redoFormPart": &graphql.Field{
+		Type:        FormUpdateType,
+		Description: "Redo your last undone item update to a form",
+		Args: graphql.FieldConfigArgument{
+			"updatesAccessToken": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			return undoRedoFormPart(params, false)
+		},
+	},
+}
+
+var formHistoryQueryFields = graphql.Fields{
+	"formHistory": &graphql.Field{
+		Type:        graphql.NewList(FormHistoryEntryType),
+		Description: "Get the edit history of a form, newest first",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+			"perpage": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"page": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			accessToken := params.Context.Value(tokenKey).(string)
+			if params.Args["id"] == nil {
+				return nil, errors.New("form id not provided")
+			}
+			formIDString, ok := params.Args["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast form id to string")
+			}
+			formID, err := primitive.ObjectIDFromHex(formIDString)
+			if err != nil {
+				return nil, err
+			}
+			var accessKey = ""
+			if params.Args["accessKey"] != nil {
+				accessKey, ok = params.Args["accessKey"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast access key to string")
+				}
+			}
+			if _, err = checkFormAccess(formID, accessToken, accessKey, editAccessLevel, false); err != nil {
+				return nil, err
+			}
+			if params.Args["perpage"] == nil {
+				return nil, errors.New("no perpage argument found")
+			}
+			perpage, ok := params.Args["perpage"].(int)
+			if !ok {
+				return nil, errors.New("perpage could not be cast to int")
+			}
+			if params.Args["page"] == nil {
+				return nil, errors.New("no page argument found")
+			}
+			page, ok := params.Args["page"].(int)
+			if !ok {
+				return nil, errors.New("page could not be cast to int")
+			}
+			findOptions := options.Find().
+				SetSort(bson.D{{Key: "_id", Value: -1}}).
+				SetSkip(int64(page * perpage)).
+				SetLimit(int64(perpage))
+			cursor, err := formHistoryCollection.Find(ctxMongo, bson.M{
+				"form": formIDString,
+			}, findOptions)
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			history := []*FormHistoryEntry{}
+			for cursor.Next(ctxMongo) {
+				entryData := bson.M{}
+				if err = cursor.Decode(&entryData); err != nil {
+					return nil, err
+				}
+				entryID := entryData["_id"].(primitive.ObjectID)
+				delete(entryData, "_id")
+				entryJSON, err := json.Marshal(entryData)
+				if err != nil {
+					return nil, err
+				}
+				var entry FormHistoryEntry
+				if err = json.Unmarshal(entryJSON, &entry); err != nil {
+					return nil, err
+				}
+				entry.ID = entryID.Hex()
+				history = append(history, &entry)
+			}
+			return history, nil
+		},
+	},
+}
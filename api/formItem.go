@@ -25,17 +25,37 @@ var UpdateFormFormItemType = graphql.NewObject(graphql.ObjectConfig{
 		"required": &graphql.Field{
 			Type: graphql.Boolean,
 		},
+		"unique": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "answers to this item identify a respondent",
+		},
+		"price": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "base amount charged by a payment item, in the smallest currency unit",
+		},
+		"prices": &graphql.Field{
+			Type:        graphql.NewList(graphql.Int),
+			Description: "amount added by each selected option of a payment item",
+		},
+		"currency": &graphql.Field{
+			Type: graphql.String,
+		},
 		"files": &graphql.Field{
 			Type: graphql.NewList(graphql.Int),
 		},
 		"updateAction": &graphql.Field{
 			Type: graphql.String,
 		},
-		"index": &graphql.Field{
-			Type: graphql.Int,
+		"seq": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "order the update must be applied in",
 		},
-		"newIndex": &graphql.Field{
-			Type: graphql.Int,
+		"itemID": &graphql.Field{
+			Type: graphql.String,
+		},
+		"afterID": &graphql.Field{
+			Type:        graphql.String,
+			Description: "id of the item an added or moved item is placed after, empty for the start",
 		},
 	},
 })
@@ -47,11 +67,11 @@ var UpdateFormItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 		"updateAction": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
 		},
-		"index": &graphql.InputObjectFieldConfig{
-			Type: graphql.Int,
+		"itemID": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
 		},
-		"newIndex": &graphql.InputObjectFieldConfig{
-			Type: graphql.Int,
+		"afterID": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
 		},
 		"question": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
@@ -68,6 +88,18 @@ var UpdateFormItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 		"required": &graphql.InputObjectFieldConfig{
 			Type: graphql.Boolean,
 		},
+		"unique": &graphql.InputObjectFieldConfig{
+			Type: graphql.Boolean,
+		},
+		"price": &graphql.InputObjectFieldConfig{
+			Type: graphql.Int,
+		},
+		"prices": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewList(graphql.Int),
+		},
+		"currency": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
 		"files": &graphql.InputObjectFieldConfig{
 			Type: graphql.NewList(graphql.Int), // maybe change to IntArrayInputType
 		},
@@ -76,18 +108,26 @@ var UpdateFormItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 
 // FormItem form struct
 type FormItem struct {
+	ID       string   `json:"id"`
 	Question string   `json:"question"`
 	Type     string   `json:"type"`
 	Options  []string `json:"options"`
 	Text     string   `json:"text"`
 	Required bool     `json:"required"`
+	Unique   bool     `json:"unique"`
 	Files    []int64  `json:"files"`
+	Price    int64    `json:"price"`
+	Prices   []int64  `json:"prices"`
+	Currency string   `json:"currency"`
 }
 
 // FormItemType graphql question object
 var FormItemType = graphql.NewObject(graphql.ObjectConfig{
 	Name: "FormItem",
 	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
 		"question": &graphql.Field{
 			Type: graphql.String,
 		},
@@ -103,6 +143,21 @@ var FormItemType = graphql.NewObject(graphql.ObjectConfig{
 		"required": &graphql.Field{
 			Type: graphql.Boolean,
 		},
+		"unique": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "answers to this item identify a respondent",
+		},
+		"price": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "base amount charged by a payment item, in the smallest currency unit",
+		},
+		"prices": &graphql.Field{
+			Type:        graphql.NewList(graphql.Int),
+			Description: "amount added by each selected option of a payment item",
+		},
+		"currency": &graphql.Field{
+			Type: graphql.String,
+		},
 		// file is the index of the file in the array
 		"files": &graphql.Field{
 			Type: graphql.NewList(graphql.Int),
@@ -114,6 +169,9 @@ var FormItemType = graphql.NewObject(graphql.ObjectConfig{
 var FormItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 	Name: "FormItemInput",
 	Fields: graphql.InputObjectConfigFieldMap{
+		"id": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
 		"question": &graphql.InputObjectFieldConfig{
 			Type: graphql.String,
 		},
@@ -129,6 +187,18 @@ var FormItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 		"required": &graphql.InputObjectFieldConfig{
 			Type: graphql.Boolean,
 		},
+		"unique": &graphql.InputObjectFieldConfig{
+			Type: graphql.Boolean,
+		},
+		"price": &graphql.InputObjectFieldConfig{
+			Type: graphql.Int,
+		},
+		"prices": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewList(graphql.Int),
+		},
+		"currency": &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		},
 		"files": &graphql.InputObjectFieldConfig{
 			Type: graphql.NewList(graphql.Int), // maybe change to IntArrayInputType
 		},
@@ -136,6 +206,11 @@ var FormItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
 })
 
 func checkFormItemObjCreate(itemObj map[string]interface{}) error {
+	if itemObj["id"] != nil {
+		if _, ok := itemObj["id"].(string); !ok {
+			return errors.New("problem casting id to string")
+		}
+	}
 	if itemObj["question"] == nil {
 		return errors.New("no question field given")
 	}
@@ -174,6 +249,14 @@ func checkFormItemObjCreate(itemObj map[string]interface{}) error {
 	if _, ok := itemObj["required"].(bool); !ok {
 		return errors.New("problem casting required to boolean")
 	}
+	if itemObj["unique"] != nil {
+		if _, ok := itemObj["unique"].(bool); !ok {
+			return errors.New("problem casting unique to boolean")
+		}
+	}
+	if err := checkFormItemPayment(itemObj); err != nil {
+		return err
+	}
 	if itemObj["files"] == nil {
 		return errors.New("no files field given")
 	}
@@ -188,6 +271,11 @@ func checkFormItemObjCreate(itemObj map[string]interface{}) error {
 }
 
 func checkFormItemObjUpdate(itemObj map[string]interface{}) error {
+	if itemObj["id"] != nil {
+		if _, ok := itemObj["id"].(string); !ok {
+			return errors.New("problem casting id to string")
+		}
+	}
 	if itemObj["question"] != nil {
 		if _, ok := itemObj["question"].(string); !ok {
 			return errors.New("problem casting question to string")
@@ -222,6 +310,14 @@ func checkFormItemObjUpdate(itemObj map[string]interface{}) error {
 			return errors.New("problem casting required to boolean")
 		}
 	}
+	if itemObj["unique"] != nil {
+		if _, ok := itemObj["unique"].(bool); !ok {
+			return errors.New("problem casting unique to boolean")
+		}
+	}
+	if err := checkFormItemPayment(itemObj); err != nil {
+		return err
+	}
 	if itemObj["files"] != nil {
 		filesArray, ok := itemObj["files"].([]interface{})
 		if !ok {
@@ -245,20 +341,17 @@ func checkFormItemObjUpdatePart(itemObj map[string]interface{}) error {
 	if !findInArray(action, validUpdateArrayActions) {
 		return errors.New("invalid action given")
 	}
-	if action != validUpdateArrayActions[0] {
-		if itemObj["index"] == nil {
-			return errors.New("no index given")
-		}
-		if _, ok := itemObj["index"].(int); !ok {
-			return errors.New("cannot cast index to int")
-		}
+	if action != validUpdateArrayActions[0] && itemObj["itemID"] == nil {
+		return errors.New("no item id given")
 	}
-	if action == validUpdateArrayActions[2] {
-		if itemObj["newIndex"] == nil {
-			return errors.New("no new index given")
+	if itemObj["itemID"] != nil {
+		if _, ok := itemObj["itemID"].(string); !ok {
+			return errors.New("cannot cast item id to string")
 		}
-		if _, ok := itemObj["newIndex"].(int); !ok {
-			return errors.New("cannot cast to index to int")
+	}
+	if itemObj["afterID"] != nil {
+		if _, ok := itemObj["afterID"].(string); !ok {
+			return errors.New("cannot cast after id to string")
 		}
 	}
 	if itemObj["question"] != nil {
@@ -295,6 +388,14 @@ func checkFormItemObjUpdatePart(itemObj map[string]interface{}) error {
 			return errors.New("problem casting required to boolean")
 		}
 	}
+	if itemObj["unique"] != nil {
+		if _, ok := itemObj["unique"].(bool); !ok {
+			return errors.New("problem casting unique to boolean")
+		}
+	}
+	if err := checkFormItemPayment(itemObj); err != nil {
+		return err
+	}
 	if itemObj["files"] != nil {
 		filesArray, ok := itemObj["files"].([]interface{})
 		if !ok {
@@ -306,3 +407,43 @@ func checkFormItemObjUpdatePart(itemObj map[string]interface{}) error {
 	}
 	return nil
 }
+
+func checkFormItemPayment(itemObj map[string]interface{}) error {
+	if itemObj["price"] != nil {
+		price, ok := itemObj["price"].(int)
+		if !ok {
+			return errors.New("problem casting price to int")
+		}
+		if price < 0 {
+			return errors.New("price cannot be negative")
+		}
+	}
+	if itemObj["prices"] != nil {
+		pricesArray, ok := itemObj["prices"].([]interface{})
+		if !ok {
+			return errors.New("problem casting prices to interface array")
+		}
+		prices, err := interfaceListToIntList(pricesArray)
+		if err != nil {
+			return errors.New("problem casting prices to int array")
+		}
+		for _, price := range prices {
+			if price < 0 {
+				return errors.New("price cannot be negative")
+			}
+		}
+		if optionsArray, ok := itemObj["options"].([]interface{}); ok && len(optionsArray) != len(prices) {
+			return errors.New("prices must have the same length as options")
+		}
+	}
+	if itemObj["currency"] != nil {
+		currency, ok := itemObj["currency"].(string)
+		if !ok {
+			return errors.New("problem casting currency to string")
+		}
+		if len(currency) != 3 {
+			return errors.New("invalid currency code given")
+		}
+	}
+	return nil
+}
@@ -15,6 +15,28 @@ import (
 )
 
 func changeFormProject(formIDString string, oldProjectIDString string, newProjectIDString string, accessToken string, accessKey string) error {
+	if len(oldProjectIDString) > 0 && len(newProjectIDString) > 0 {
+		// usage is counted per owner, so forms stay with the organization they were made in
+		oldProjectID, err := primitive.ObjectIDFromHex(oldProjectIDString)
+		if err != nil {
+			return err
+		}
+		oldProject, err := getProject(oldProjectID, false)
+		if err != nil {
+			return err
+		}
+		newProjectID, err := primitive.ObjectIDFromHex(newProjectIDString)
+		if err != nil {
+			return err
+		}
+		newProject, err := getProject(newProjectID, false)
+		if err != nil {
+			return err
+		}
+		if oldProject.Org != newProject.Org {
+			return errors.New("forms can only move between projects of the same organization")
+		}
+	}
 	if len(oldProjectIDString) > 0 {
 		projectID, err := primitive.ObjectIDFromHex(oldProjectIDString)
 		if err != nil {
@@ -40,6 +62,7 @@ func changeFormProject(formIDString string, oldProjectIDString string, newProjec
 		if err != nil {
 			return err
 		}
+		publishProjectChangedByID(projectID)
 	}
 	if len(newProjectIDString) > 0 {
 		projectID, err := primitive.ObjectIDFromHex(newProjectIDString)
@@ -69,6 +92,7 @@ func changeFormProject(formIDString string, oldProjectIDString string, newProjec
 		if err != nil {
 			return err
 		}
+		publishProjectChangedByID(projectID)
 	}
 	if len(oldProjectIDString) > 0 && len(newProjectIDString) > 0 {
 		sourceContext := elastic.NewFetchSourceContext(true).Include("id")
@@ -136,6 +160,27 @@ var formMutationFields = graphql.Fields{
 			"multiple": &graphql.ArgumentConfig{
 				Type: graphql.Boolean,
 			},
+			"editresponses": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"editwindow": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"emailresponses": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"anonymous": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"recaptcha": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"minfilltime": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"duplicateaction": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
 			"categories": &graphql.ArgumentConfig{
 				Type: graphql.NewList(graphql.String),
 			},
@@ -164,38 +209,6 @@ var formMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
-			planIDString, ok := claims["plan"].(string)
-			if !ok {
-				return nil, errors.New("cannot convert plan to string")
-			}
-			var planID primitive.ObjectID
-			if len(planIDString) > 0 {
-				planID, err = primitive.ObjectIDFromHex(planIDString)
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				planID = primitive.NilObjectID
-			}
-			productData, err := getProduct(planID, !isDebug())
-			if err != nil {
-				return nil, err
-			}
-			mustQueries := []elastic.Query{
-				elastic.NewTermsQuery("owner", userIDString),
-			}
-			query := elastic.NewBoolQuery().Must(mustQueries...)
-			numFormsAlready, err := elasticClient.Count().
-				Type(formElasticType).
-				Query(query).
-				Pretty(false).
-				Do(ctxElastic)
-			if err != nil {
-				return nil, err
-			}
-			if numFormsAlready >= int64(productData.MaxForms) {
-				return nil, errors.New("you reached the maximum amount of forms")
-			}
 			if params.Args["project"] == nil {
 				return nil, errors.New("project not provided")
 			}
@@ -218,6 +231,18 @@ var formMutationFields = graphql.Fields{
 			if !ok {
 				return nil, errors.New("problem casting project id to string")
 			}
+			projectID, err := primitive.ObjectIDFromHex(project)
+			if err != nil {
+				return nil, err
+			}
+			projectData, err := getProject(projectID, false)
+			if err != nil {
+				return nil, err
+			}
+			// forms belong to the organization of their project
+			if err = checkUsageLimit(claims, projectData.Org, formElasticType); err != nil {
+				return nil, err
+			}
 			name, ok := params.Args["name"].(string)
 			if !ok {
 				return nil, errors.New("problem casting name to string")
@@ -235,10 +260,73 @@ var formMutationFields = graphql.Fields{
 					return nil, err
 				}
 			}
+			if err = setFormItemIDs(items); err != nil {
+				return nil, err
+			}
 			multiple, ok := params.Args["multiple"].(bool)
 			if !ok {
 				return nil, errors.New("problem casting multiple to boolean")
 			}
+			var editResponses = true
+			if params.Args["editresponses"] != nil {
+				editResponses, ok = params.Args["editresponses"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting edit responses to boolean")
+				}
+			}
+			var editWindow int64
+			if params.Args["editwindow"] != nil {
+				editWindowInt, ok := params.Args["editwindow"].(int)
+				if !ok {
+					return nil, errors.New("problem casting edit window to int")
+				}
+				if editWindowInt < 0 {
+					return nil, errors.New("edit window cannot be negative")
+				}
+				editWindow = int64(editWindowInt)
+			}
+			var emailResponses = false
+			if params.Args["emailresponses"] != nil {
+				emailResponses, ok = params.Args["emailresponses"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting email responses to boolean")
+				}
+			}
+			var anonymous = false
+			if params.Args["anonymous"] != nil {
+				anonymous, ok = params.Args["anonymous"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting anonymous to boolean")
+				}
+			}
+			var recaptcha = false
+			if params.Args["recaptcha"] != nil {
+				recaptcha, ok = params.Args["recaptcha"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting recaptcha to boolean")
+				}
+			}
+			var minFillTime int64
+			if params.Args["minfilltime"] != nil {
+				minFillTimeInt, ok := params.Args["minfilltime"].(int)
+				if !ok {
+					return nil, errors.New("problem casting min fill time to int")
+				}
+				if minFillTimeInt < 0 {
+					return nil, errors.New("min fill time cannot be negative")
+				}
+				minFillTime = int64(minFillTimeInt)
+			}
+			var duplicateAction = duplicateActionReject
+			if params.Args["duplicateaction"] != nil {
+				duplicateAction, ok = params.Args["duplicateaction"].(string)
+				if !ok {
+					return nil, errors.New("problem casting duplicate action to string")
+				}
+				if !findInArray(duplicateAction, validDuplicateActions) {
+					return nil, errors.New("invalid duplicate action given")
+				}
+			}
 			categoriesInterface, ok := params.Args["categories"].([]interface{})
 			if !ok {
 				return nil, errors.New("problem casting categories to interface array")
@@ -285,15 +373,23 @@ var formMutationFields = graphql.Fields{
 				"type": editAccessLevel[0],
 			}}
 			formData := bson.M{
-				"_id":       formID,
-				"updated":   now.Unix(),
-				"project":   project,
-				"name":      name,
-				"items":     items,
-				"multiple":  multiple,
-				"views":     0,
-				"responses": 0,
-				"owner":     userIDString,
+				"_id":               formID,
+				"updated":           now.Unix(),
+				"project":           project,
+				"name":              name,
+				"items":             items,
+				"multiple":          multiple,
+				"editresponses":     editResponses,
+				"editwindow":        editWindow,
+				"emailresponses":    emailResponses,
+				"anonymous":         anonymous,
+				"recaptcha":         recaptcha,
+				"minfilltime":       minFillTime,
+				"duplicateaction":   duplicateAction,
+				"views":             0,
+				"responses":         0,
+				"rejectedresponses": 0,
+				"owner":             userIDString,
 				"linkaccess": bson.M{
 					"shortlink": shortlink,
 					"secret":    key,
@@ -309,6 +405,9 @@ var formMutationFields = graphql.Fields{
 				"files":  files,
 				"public": noAccessLevel,
 			}
+			if len(projectData.Org) > 0 {
+				formData["org"] = projectData.Org
+			}
 			_, err = formCollection.InsertOne(ctxMongo, formData)
 			if err != nil {
 				return nil, err
@@ -361,12 +460,49 @@ var formMutationFields = graphql.Fields{
 			"multiple": &graphql.ArgumentConfig{
 				Type: graphql.Boolean,
 			},
+			"editresponses": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"editwindow": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"emailresponses": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"anonymous": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"recaptcha": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"minfilltime": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"duplicateaction": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
 			"access": &graphql.ArgumentConfig{
 				Type: graphql.NewList(AccessInputType),
 			},
 			"linkaccess": &graphql.ArgumentConfig{
 				Type: graphql.String,
 			},
+			"linkexpires": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "unix time the link stops working, 0 to never expire",
+			},
+			"linkmaxuses": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "number of users that can open the link, 0 for no limit",
+			},
+			"linkpassword": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "password needed to open the link, empty to remove it",
+			},
+			"linkdomain": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "email domain users need to open the link, empty for any",
+			},
 			"tags": &graphql.ArgumentConfig{
 				Type: graphql.NewList(graphql.String),
 			},
@@ -487,9 +623,10 @@ var formMutationFields = graphql.Fields{
 								return nil, err
 							}
 							script := elastic.NewScript(addRemoveAccessScript).Params(map[string]interface{}{
-								"access":     projectAccess,
-								"tags":       nil,
-								"categories": nil,
+								"access":       projectAccess,
+								"tags":         nil,
+								"categories":   nil,
+								"userIDString": userIDString,
 							})
 							_, err = elasticClient.Update().
 								Index(projectElasticIndex).
@@ -506,6 +643,7 @@ var formMutationFields = graphql.Fields{
 							if err != nil {
 								return nil, err
 							}
+							recordAudit(auditAccessChangeAction, userIDString, projectType, projectID.Hex(), getAuditProjectOwner(projectID), getParamsIP(params), projectAccess)
 						}
 					}
 				}
@@ -564,6 +702,82 @@ var formMutationFields = graphql.Fields{
 				form.Multiple = multiple
 				updateDataElastic["multiple"] = multiple
 			}
+			if params.Args["editresponses"] != nil {
+				editResponses, ok := params.Args["editresponses"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting edit responses to bool")
+				}
+				updateDataDB["$set"].(bson.M)["editresponses"] = editResponses
+				form.EditResponses = editResponses
+				updateDataElastic["editresponses"] = editResponses
+			}
+			if params.Args["editwindow"] != nil {
+				editWindow, ok := params.Args["editwindow"].(int)
+				if !ok {
+					return nil, errors.New("problem casting edit window to int")
+				}
+				if editWindow < 0 {
+					return nil, errors.New("edit window cannot be negative")
+				}
+				updateDataDB["$set"].(bson.M)["editwindow"] = int64(editWindow)
+				form.EditWindow = int64(editWindow)
+				updateDataElastic["editwindow"] = int64(editWindow)
+			}
+			if params.Args["emailresponses"] != nil {
+				emailResponses, ok := params.Args["emailresponses"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting email responses to bool")
+				}
+				updateDataDB["$set"].(bson.M)["emailresponses"] = emailResponses
+				form.EmailResponses = emailResponses
+				updateDataElastic["emailresponses"] = emailResponses
+			}
+			if params.Args["anonymous"] != nil {
+				anonymous, ok := params.Args["anonymous"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting anonymous to bool")
+				}
+				// mixing identified and anonymous responses would defeat the duplicate check
+				if anonymous != form.Anonymous && form.Responses > 0 {
+					return nil, errors.New("cannot change anonymous mode for a form with responses")
+				}
+				updateDataDB["$set"].(bson.M)["anonymous"] = anonymous
+				form.Anonymous = anonymous
+				updateDataElastic["anonymous"] = anonymous
+			}
+			if params.Args["recaptcha"] != nil {
+				recaptcha, ok := params.Args["recaptcha"].(bool)
+				if !ok {
+					return nil, errors.New("problem casting recaptcha to bool")
+				}
+				updateDataDB["$set"].(bson.M)["recaptcha"] = recaptcha
+				form.Recaptcha = recaptcha
+				updateDataElastic["recaptcha"] = recaptcha
+			}
+			if params.Args["minfilltime"] != nil {
+				minFillTime, ok := params.Args["minfilltime"].(int)
+				if !ok {
+					return nil, errors.New("problem casting min fill time to int")
+				}
+				if minFillTime < 0 {
+					return nil, errors.New("min fill time cannot be negative")
+				}
+				updateDataDB["$set"].(bson.M)["minfilltime"] = int64(minFillTime)
+				form.MinFillTime = int64(minFillTime)
+				updateDataElastic["minfilltime"] = int64(minFillTime)
+			}
+			if params.Args["duplicateaction"] != nil {
+				duplicateAction, ok := params.Args["duplicateaction"].(string)
+				if !ok {
+					return nil, errors.New("problem casting duplicate action to string")
+				}
+				if !findInArray(duplicateAction, validDuplicateActions) {
+					return nil, errors.New("invalid duplicate action given")
+				}
+				updateDataDB["$set"].(bson.M)["duplicateaction"] = duplicateAction
+				form.DuplicateAction = duplicateAction
+				updateDataElastic["duplicateaction"] = duplicateAction
+			}
 			if params.Args["items"] != nil {
 				itemsInterface, ok := params.Args["items"].([]interface{})
 				if !ok {
@@ -578,6 +792,9 @@ var formMutationFields = graphql.Fields{
 						return nil, err
 					}
 				}
+				if err = setFormItemIDs(itemsMap); err != nil {
+					return nil, err
+				}
 				items := make([]*FormItem, len(itemsMap))
 				for i, item := range itemsMap {
 					if err = mapstructure.Decode(item, &items[i]); err != nil {
@@ -600,21 +817,8 @@ var formMutationFields = graphql.Fields{
 				form.Public = public
 				updateDataElastic["public"] = public
 			}
-			if params.Args["linkaccess"] != nil {
-				linkaccess, ok := params.Args["linkaccess"].(string)
-				if !ok {
-					return nil, errors.New("problem casting public to string")
-				}
-				if !findInArray(linkaccess, validAccessTypes) {
-					return nil, errors.New("invalid link access level")
-				}
-				updateDataDB["$set"].(bson.M)["linkaccess"] = bson.M{
-					"type": linkaccess,
-				}
-				form.LinkAccess.Type = linkaccess
-				updateDataElastic["linkaccess"] = bson.M{
-					"type": linkaccess,
-				}
+			if err = setLinkAccessArgs(params, form.LinkAccess, updateDataDB, updateDataElastic); err != nil {
+				return nil, err
 			}
 			if params.Args["files"] != nil {
 				filesinterface, ok := params.Args["files"].([]interface{})
@@ -642,9 +846,10 @@ var formMutationFields = graphql.Fields{
 			}
 			if len(access) > 0 {
 				script := elastic.NewScriptInline(addRemoveAccessScript).Params(map[string]interface{}{
-					"access":     access,
-					"tags":       tags,
-					"categories": categories,
+					"access":       access,
+					"tags":         tags,
+					"categories":   categories,
+					"userIDString": userIDString,
 				})
 				_, err = elasticClient.Update().
 					Index(formElasticIndex).
@@ -672,6 +877,12 @@ var formMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			if len(access) > 0 {
+				recordAudit(auditAccessChangeAction, userIDString, formType, formIDString, form.Owner, getParamsIP(params), access)
+			}
+			if linkAccessChanges := getLinkAccessChanges(params); len(linkAccessChanges) > 0 {
+				recordAudit(auditLinkAccessChangeAction, userIDString, formType, formIDString, form.Owner, getParamsIP(params), linkAccessChanges)
+			}
 			if updateProject {
 				err = changeFormProject(formIDString, oldProject, newProject, accessToken, accessKey)
 				if err != nil {
@@ -706,6 +917,10 @@ var formMutationFields = graphql.Fields{
 			"files": &graphql.ArgumentConfig{
 				Type: graphql.NewList(UpdateFileInputType),
 			},
+			"focus": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "id of the item the editor is focused on",
+			},
 		},
 		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 			// need to have input of either updatesAccessToken or just params
@@ -730,17 +945,47 @@ var formMutationFields = graphql.Fields{
 			if !ok {
 				return nil, errors.New("cannot cast update token to string")
 			}
-			tokenFormIDString, _, connectionIDString, err := getFormUpdateClaimsData(accessToken, editAccessLevel)
+			tokenFormIDString, userIDString, connectionIDString, err := getFormUpdateClaimsData(accessToken, editAccessLevel)
 			if err != nil {
 				return nil, err
 			}
 			if tokenFormIDString != formIDString {
 				return nil, errors.New("form id in token does not match given form id")
 			}
+			if params.Args["focus"] != nil {
+				focus, ok := params.Args["focus"].(string)
+				if !ok {
+					return nil, errors.New("problem casting focus to string")
+				}
+				presence := &FormPresence{
+					Connection: connectionIDString,
+					User:       userIDString,
+					Focus:      focus,
+				}
+				if err = focusFormPresence(formIDString, presence); err != nil {
+					return nil, err
+				}
+				if params.Args["name"] == nil && params.Args["multiple"] == nil && params.Args["items"] == nil &&
+					params.Args["public"] == nil && params.Args["files"] == nil {
+					// nothing else to save
+					return map[string]interface{}{
+						"id":    connectionIDString,
+						"type":  validFormUpdateTypes[3],
+						"user":  userIDString,
+						"focus": focus,
+					}, nil
+				}
+			}
 			var updateData map[string]interface{}
 			newUpdateData := map[string]interface{}{
-				"id": connectionIDString,
+				"id":   connectionIDString,
+				"type": validFormUpdateTypes[0],
+				"user": userIDString,
+			}
+			formUpdate := &FormUpdateData{
+				Settings: map[string]interface{}{},
 			}
+			var inverse []*FormItemOperation
 			savedUpdateData, err := redisClient.Get(updateFormPath + formIDString).Result()
 			if err != nil {
 				updateData = map[string]interface{}{}
@@ -757,6 +1002,7 @@ var formMutationFields = graphql.Fields{
 				}
 				updateData["name"] = name
 				newUpdateData["name"] = name
+				formUpdate.Settings["name"] = name
 			}
 			if params.Args["multiple"] != nil {
 				multiple, ok := params.Args["multiple"].(bool)
@@ -765,6 +1011,7 @@ var formMutationFields = graphql.Fields{
 				}
 				updateData["multiple"] = multiple
 				newUpdateData["multiple"] = multiple
+				formUpdate.Settings["multiple"] = multiple
 			}
 			if params.Args["items"] != nil {
 				itemsInterface, ok := params.Args["items"].([]interface{})
@@ -775,20 +1022,43 @@ var formMutationFields = graphql.Fields{
 				if err != nil {
 					return nil, err
 				}
-				for _, item := range items {
+				operations := make([]*FormItemOperation, len(items))
+				for i, item := range items {
 					if err := checkFormItemObjUpdatePart(item); err != nil {
 						return nil, err
 					}
-				}
-				newUpdateData["items"] = items
-				if updateData["items"] != nil {
-					currentItemUpdates, err := interfaceListToMapList(updateData["items"].([]interface{}))
-					if err != nil {
-						return nil, err
+					operation := &FormItemOperation{
+						UpdateAction: item["updateAction"].(string),
+					}
+					if item["itemID"] != nil {
+						operation.ItemID = item["itemID"].(string)
+					} else {
+						// new items get their id from the server
+						operation.ItemID, err = getFormItemID()
+						if err != nil {
+							return nil, err
+						}
+					}
+					if item["afterID"] != nil {
+						operation.AfterID = item["afterID"].(string)
+					}
+					delete(item, "updateAction")
+					delete(item, "itemID")
+					delete(item, "afterID")
+					if operation.UpdateAction == validUpdateArrayActions[0] || operation.UpdateAction == validUpdateArrayActions[3] {
+						operation.Item = item
 					}
-					items = append(currentItemUpdates, items...)
+					operations[i] = operation
+				}
+				currentItems, err := getFormEditingItems(formIDString)
+				if err != nil {
+					return nil, err
+				}
+				inverse, err = getInverseFormItemOperations(currentItems, operations)
+				if err != nil {
+					return nil, err
 				}
-				updateData["items"] = items
+				formUpdate.Operations = operations
 			}
 			if params.Args["public"] != nil {
 				public, ok := params.Args["public"].(string)
@@ -800,6 +1070,7 @@ var formMutationFields = graphql.Fields{
 				}
 				updateData["public"] = public
 				newUpdateData["public"] = public
+				formUpdate.Settings["public"] = public
 			}
 			if params.Args["files"] != nil {
 				filesinterface, ok := params.Args["files"].([]interface{})
@@ -816,6 +1087,7 @@ var formMutationFields = graphql.Fields{
 					}
 				}
 				newUpdateData["files"] = files
+				formUpdate.Files = files
 				if updateData["files"] != nil {
 					currentFileUpdates, err := interfaceListToMapList(updateData["files"].([]interface{}))
 					if err != nil {
@@ -825,6 +1097,23 @@ var formMutationFields = graphql.Fields{
 				}
 				updateData["files"] = files
 			}
+			// log the update before anything else sees it
+			itemUpdates, err := pushFormUpdate(formIDString, userIDString, connectionIDString, validFormHistorySources[0], formUpdate)
+			if err != nil {
+				return nil, err
+			}
+			if len(formUpdate.Operations) > 0 {
+				if err = pushFormUndoStack(formUndoPath, formIDString, userIDString, &FormUndoEntry{
+					Operations: copyFormItemOperations(formUpdate.Operations),
+					Inverse:    inverse,
+				}); err != nil {
+					return nil, err
+				}
+				if err = clearFormRedoStack(formIDString, userIDString); err != nil {
+					return nil, err
+				}
+				newUpdateData["items"] = itemUpdates
+			}
 			updateDataJSON, err := json.Marshal(updateData)
 			if err != nil {
 				return nil, err
@@ -833,11 +1122,11 @@ var formMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
-			// save update task
-			msg := saveFormTask.WithArgs(ctxMessageQueue, formIDString).OnceInPeriod(time.Duration(autosaveTime) * time.Second)
-			msg.Delay = time.Duration(autosaveTime) * time.Second
-			if err = messageQueue.Add(msg); err != nil {
-				logger.Info("update already saved: " + err.Error())
+			queueFormSave(formIDString)
+			if newUpdateData["name"] != nil || newUpdateData["multiple"] != nil || newUpdateData["public"] != nil {
+				recordFormHistory([]interface{}{
+					getFormSettingsHistory(formIDString, userIDString, connectionIDString, newUpdateData),
+				})
 			}
 			newUpdateDataJSON, err := json.Marshal(newUpdateData)
 			if err != nil {
@@ -848,6 +1137,9 @@ var formMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			if newUpdateData["items"] != nil {
+				updateData["items"] = newUpdateData["items"]
+			}
 			return updateData, nil
 		},
 	},
@@ -905,6 +1197,10 @@ var formMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			recordAudit(auditFormDeleteAction, userIDString, formType, formIDString, form.Owner, getParamsIP(params), bson.M{
+				"name":    form.Name,
+				"project": form.Project,
+			})
 			return form, nil
 		},
 	},
@@ -958,6 +1254,21 @@ func deleteForm(formID primitive.ObjectID, form *Form, userIDString string) (*Fo
 		if err != nil {
 			return nil, err
 		}
+		_, err = formHistoryCollection.DeleteMany(ctxMongo, bson.M{
+			"form": formIDString,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = deleteFormComments(formIDString); err != nil {
+			return nil, err
+		}
+		if err = deleteFormUpdateLog(formIDString, -1); err != nil {
+			return nil, err
+		}
+		if err = deleteFormSaveFailures(formIDString); err != nil {
+			return nil, err
+		}
 		for _, file := range form.Files {
 			newBytesRemoved, err := deleteFile(formType, form.ID, file.ID)
 			if err != nil {
@@ -969,7 +1280,7 @@ func deleteForm(formID primitive.ObjectID, form *Form, userIDString string) (*Fo
 		if err != nil {
 			return nil, err
 		}
-		if err = changeUserStorage(ownerID, -1*bytesRemoved); err != nil {
+		if err = changeOwnerStorage(ownerID, form.Org, -1*bytesRemoved); err != nil {
 			return nil, err
 		}
 	}
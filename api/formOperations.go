@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+	json "github.com/json-iterator/go"
+	"github.com/mitchellh/mapstructure"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// item updates from collaborators are stored as an ordered log of operations keyed by item id.
+// the server assigns every operation a sequence number, and everyone applies operations in
+// sequence order to the same base items, so all editors converge to the same form.
+// operations are written to the form update log before they get here, see formSave.go.
+
+var formSeqPath = "form-seq-"
+
+var formOperationsPath = "form-operations-"
+
+// FormItemOperation item update keyed by item id
+type FormItemOperation struct {
+	Seq          int64                  `json:"seq,omitempty"`
+	UpdateAction string                 `json:"updateAction"`
+	ItemID       string                 `json:"itemID"`
+	AfterID      string                 `json:"afterID"`
+	Item         map[string]interface{} `json:"item,omitempty"`
+}
+
+func getFormItemID() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// setFormItemIDs gives every item without an id a new one
+func setFormItemIDs(items []map[string]interface{}) error {
+	for _, item := range items {
+		if itemID, ok := item["id"].(string); ok && len(itemID) > 0 {
+			continue
+		}
+		itemID, err := getFormItemID()
+		if err != nil {
+			return err
+		}
+		item["id"] = itemID
+	}
+	return nil
+}
+
+// reserveFormSeq reserves count consecutive sequence numbers for the form and returns the first
+func reserveFormSeq(formIDString string, count int64) (int64, error) {
+	seqKey := formSeqPath + formIDString
+	exists, err := redisClient.Exists(seqKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		// continue from the last sequence number saved with the form or logged for it
+		formID, err := primitive.ObjectIDFromHex(formIDString)
+		if err != nil {
+			return 0, err
+		}
+		formData, err := getForm(formID, false)
+		if err != nil {
+			return 0, err
+		}
+		lastSeq, err := getLastFormUpdateLogSeq(formIDString)
+		if err != nil {
+			return 0, err
+		}
+		if formData.Seq > lastSeq {
+			lastSeq = formData.Seq
+		}
+		if err = redisClient.SetNX(seqKey, lastSeq, 0).Err(); err != nil {
+			return 0, err
+		}
+	}
+	last, err := redisClient.IncrBy(seqKey, count).Result()
+	if err != nil {
+		return 0, err
+	}
+	return last - count + 1, nil
+}
+
+// pushFormItemOperations adds operations that already have sequence numbers to the log
+func pushFormItemOperations(formIDString string, operations []*FormItemOperation) error {
+	if len(operations) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(operations))
+	for i, operation := range operations {
+		operationJSON, err := json.MarshalToString(operation)
+		if err != nil {
+			return err
+		}
+		args[i] = operationJSON
+	}
+	return redisClient.RPush(formOperationsPath+formIDString, args...).Err()
+}
+
+// getFormItemOperations returns the logged operations after the given sequence number, in sequence order
+func getFormItemOperations(formIDString string, since int64) ([]*FormItemOperation, error) {
+	operationsData, err := redisClient.LRange(formOperationsPath+formIDString, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	operations := []*FormItemOperation{}
+	for _, operationData := range operationsData {
+		var operation FormItemOperation
+		if err = json.UnmarshalFromString(operationData, &operation); err != nil {
+			return nil, err
+		}
+		if operation.Seq > since {
+			operations = append(operations, &operation)
+		}
+	}
+	sortFormItemOperations(operations)
+	return operations, nil
+}
+
+// sortFormItemOperations puts operations in sequence order, concurrent updates can reach the
+// log slightly out of order
+func sortFormItemOperations(operations []*FormItemOperation) {
+	sort.SliceStable(operations, func(i, j int) bool {
+		return operations[i].Seq < operations[j].Seq
+	})
+}
+
+// trimFormItemOperations removes operations saved with the form from the start of the log
+func trimFormItemOperations(formIDString string, savedSeq int64) error {
+	operationsData, err := redisClient.LRange(formOperationsPath+formIDString, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	var count int64
+	for _, operationData := range operationsData {
+		var operation FormItemOperation
+		if err = json.UnmarshalFromString(operationData, &operation); err != nil {
+			return err
+		}
+		if operation.Seq > savedSeq {
+			break
+		}
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return redisClient.LTrim(formOperationsPath+formIDString, count, -1).Err()
+}
+
+func findFormItem(items []*FormItem, itemID string) int {
+	for i, item := range items {
+		if item.ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// insertFormItem puts the item after the given item id, at the start when
+// no id is given, or at the end when the item cannot be found
+func insertFormItem(items []*FormItem, item *FormItem, afterID string) []*FormItem {
+	index := len(items)
+	if len(afterID) == 0 {
+		index = 0
+	} else if afterIndex := findFormItem(items, afterID); afterIndex >= 0 {
+		index = afterIndex + 1
+	}
+	newItems := make([]*FormItem, 0, len(items)+1)
+	newItems = append(newItems, items[:index]...)
+	newItems = append(newItems, item)
+	return append(newItems, items[index:]...)
+}
+
+func removeFormItem(items []*FormItem, index int) []*FormItem {
+	newItems := make([]*FormItem, 0, len(items)-1)
+	newItems = append(newItems, items[:index]...)
+	return append(newItems, items[index+1:]...)
+}
+
+// decodeFormItemFields overwrites only the fields given
+func decodeFormItemFields(fields map[string]interface{}, item *FormItem) error {
+	itemID := item.ID
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ZeroFields: true,
+		Result:     item,
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(fields); err != nil {
+		return err
+	}
+	item.ID = itemID
+	return nil
+}
+
+// applyFormItemOperations applies operations in order. operations on items that no
+// longer exist are skipped, so the result only depends on the order of the operations
+func applyFormItemOperations(items []*FormItem, operations []*FormItemOperation) ([]*FormItem, error) {
+	for _, operation := range operations {
+		index := findFormItem(items, operation.ItemID)
+		switch operation.UpdateAction {
+		case validUpdateArrayActions[0]:
+			// add
+			if index >= 0 {
+				continue
+			}
+			item := &FormItem{
+				ID: operation.ItemID,
+			}
+			if err := decodeFormItemFields(operation.Item, item); err != nil {
+				return nil, err
+			}
+			items = insertFormItem(items, item, operation.AfterID)
+		case validUpdateArrayActions[1]:
+			// remove
+			if index < 0 {
+				continue
+			}
+			items = removeFormItem(items, index)
+		case validUpdateArrayActions[2]:
+			// move after another item
+			if index < 0 || operation.AfterID == operation.ItemID {
+				continue
+			}
+			item := items[index]
+			items = insertFormItem(removeFormItem(items, index), item, operation.AfterID)
+		case validUpdateArrayActions[3]:
+			// set given fields
+			if index < 0 {
+				continue
+			}
+			item := *items[index]
+			if err := decodeFormItemFields(operation.Item, &item); err != nil {
+				return nil, err
+			}
+			items[index] = &item
+		default:
+			return nil, errors.New("invalid action given")
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var addAction = validUpdateArrayActions[0]
+
+var removeAction = validUpdateArrayActions[1]
+
+var moveAction = validUpdateArrayActions[2]
+
+var setAction = validUpdateArrayActions[3]
+
+func newTestFormItems(ids ...string) []*FormItem {
+	items := make([]*FormItem, len(ids))
+	for i, id := range ids {
+		items[i] = &FormItem{
+			ID:       id,
+			Question: "question " + id,
+			Type:     "text",
+		}
+	}
+	return items
+}
+
+func getTestFormItemIDs(items []*FormItem) []string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func copyTestOperations(operations []*FormItemOperation) []*FormItemOperation {
+	operationsCopy := make([]*FormItemOperation, len(operations))
+	for i, operation := range operations {
+		operationCopy := *operation
+		operationsCopy[i] = &operationCopy
+	}
+	return operationsCopy
+}
+
+func applyTestOperations(t *testing.T, items []*FormItem, operations []*FormItemOperation) []*FormItem {
+	t.Helper()
+	result, err := applyFormItemOperations(items, operations)
+	if err != nil {
+		t.Fatalf("cannot apply operations: %s", err)
+	}
+	return result
+}
+
+// getInterleavedOperations returns the log of two editors that both started from items a, b, c, d.
+// editor a removes b and moves d after a, editor b does not know about it and edits b, moves
+// c after b and adds e after d. the server gave their operations interleaved sequence numbers.
+func getInterleavedOperations() []*FormItemOperation {
+	return []*FormItemOperation{
+		{Seq: 1, UpdateAction: removeAction, ItemID: "b"},
+		{Seq: 2, UpdateAction: setAction, ItemID: "b", Item: map[string]interface{}{
+			"question": "stale edit",
+		}},
+		{Seq: 3, UpdateAction: moveAction, ItemID: "c", AfterID: "b"},
+		{Seq: 4, UpdateAction: moveAction, ItemID: "d", AfterID: "a"},
+		{Seq: 5, UpdateAction: addAction, ItemID: "e", AfterID: "d", Item: map[string]interface{}{
+			"question": "question e",
+			"type":     "text",
+		}},
+	}
+}
+
+func TestApplyInterleavedFormItemOperations(t *testing.T) {
+	items := applyTestOperations(t, newTestFormItems("a", "b", "c", "d"), getInterleavedOperations())
+	expected := []string{"a", "d", "e", "c"}
+	if ids := getTestFormItemIDs(items); !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected items %v, got %v", expected, ids)
+	}
+	if items[2].Question != "question e" {
+		t.Fatalf("added item has question %q", items[2].Question)
+	}
+}
+
+func TestFormItemOperationsConvergeInAnyArrivalOrder(t *testing.T) {
+	expected := applyTestOperations(t, newTestFormItems("a", "b", "c", "d"), getInterleavedOperations())
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		// every subscriber gets the operations in a different order, and sorts them by sequence number
+		operations := copyTestOperations(getInterleavedOperations())
+		random.Shuffle(len(operations), func(i, j int) {
+			operations[i], operations[j] = operations[j], operations[i]
+		})
+		sortFormItemOperations(operations)
+		items := applyTestOperations(t, newTestFormItems("a", "b", "c", "d"), operations)
+		if !reflect.DeepEqual(items, expected) {
+			t.Fatalf("arrival order %d: expected %v, got %v", i, getTestFormItemIDs(expected), getTestFormItemIDs(items))
+		}
+	}
+}
+
+func TestFormItemOperationsConvergeWhenAppliedInParts(t *testing.T) {
+	operations := getInterleavedOperations()
+	expected := applyTestOperations(t, newTestFormItems("a", "b", "c", "d"), operations)
+	for split := 0; split <= len(operations); split++ {
+		// an editor loading the form after split operations applies only the ones after it
+		items := applyTestOperations(t, newTestFormItems("a", "b", "c", "d"), operations[:split])
+		items = applyTestOperations(t, items, operations[split:])
+		if !reflect.DeepEqual(items, expected) {
+			t.Fatalf("split %d: expected %v, got %v", split, getTestFormItemIDs(expected), getTestFormItemIDs(items))
+		}
+	}
+}
+
+func TestStaleFormItemOperations(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations []*FormItemOperation
+		expected   []string
+	}{
+		{
+			name: "set on removed item is skipped",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: removeAction, ItemID: "a"},
+				{Seq: 2, UpdateAction: setAction, ItemID: "a", Item: map[string]interface{}{
+					"question": "stale edit",
+				}},
+			},
+			expected: []string{"b", "c"},
+		},
+		{
+			name: "remove of removed item is skipped",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: removeAction, ItemID: "b"},
+				{Seq: 2, UpdateAction: removeAction, ItemID: "b"},
+			},
+			expected: []string{"a", "c"},
+		},
+		{
+			name: "move of removed item is skipped",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: removeAction, ItemID: "a"},
+				{Seq: 2, UpdateAction: moveAction, ItemID: "a", AfterID: "c"},
+			},
+			expected: []string{"b", "c"},
+		},
+		{
+			name: "move after removed item goes to the end",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: removeAction, ItemID: "c"},
+				{Seq: 2, UpdateAction: moveAction, ItemID: "a", AfterID: "c"},
+			},
+			expected: []string{"b", "a"},
+		},
+		{
+			name: "add after removed item goes to the end",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: removeAction, ItemID: "a"},
+				{Seq: 2, UpdateAction: addAction, ItemID: "d", AfterID: "a", Item: map[string]interface{}{}},
+			},
+			expected: []string{"b", "c", "d"},
+		},
+		{
+			name: "add of existing item is skipped",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: addAction, ItemID: "d", Item: map[string]interface{}{}},
+				{Seq: 2, UpdateAction: addAction, ItemID: "d", AfterID: "c", Item: map[string]interface{}{}},
+			},
+			expected: []string{"d", "a", "b", "c"},
+		},
+		{
+			name: "move after moved item follows it",
+			operations: []*FormItemOperation{
+				{Seq: 1, UpdateAction: moveAction, ItemID: "a", AfterID: "c"},
+				{Seq: 2, UpdateAction: moveAction, ItemID: "b", AfterID: "a"},
+			},
+			expected: []string{"c", "a", "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			items := applyTestOperations(t, newTestFormItems("a", "b", "c"), test.operations)
+			if ids := getTestFormItemIDs(items); !reflect.DeepEqual(ids, test.expected) {
+				t.Fatalf("expected items %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func TestConcurrentSetsOnSameFormItem(t *testing.T) {
+	items := applyTestOperations(t, newTestFormItems("a"), []*FormItemOperation{
+		{Seq: 1, UpdateAction: setAction, ItemID: "a", Item: map[string]interface{}{
+			"question": "first",
+		}},
+		{Seq: 2, UpdateAction: setAction, ItemID: "a", Item: map[string]interface{}{
+			"required": true,
+		}},
+		{Seq: 3, UpdateAction: setAction, ItemID: "a", Item: map[string]interface{}{
+			"question": "second",
+		}},
+	})
+	// fields set by different editors are all kept, the later sequence number wins on the same field
+	if items[0].Question != "second" || !items[0].Required || items[0].Type != "text" {
+		t.Fatalf("unexpected item after sets: %+v", *items[0])
+	}
+	if items[0].ID != "a" {
+		t.Fatalf("set changed the item id to %q", items[0].ID)
+	}
+}
+
+func TestInvalidFormItemOperation(t *testing.T) {
+	_, err := applyFormItemOperations(newTestFormItems("a"), []*FormItemOperation{
+		{Seq: 1, UpdateAction: "swap", ItemID: "a"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for an invalid action")
+	}
+}
+
+func TestLegacyFormItemIDs(t *testing.T) {
+	form := &Form{
+		ID: primitive.NewObjectID().Hex(),
+		Items: []*FormItem{
+			{Question: "first"},
+			{ID: "kept", Question: "second"},
+			{Question: "third"},
+		},
+	}
+	setLegacyFormItemIDs(form)
+	expected := []string{form.ID + "-0", "kept", form.ID + "-2"}
+	if ids := getTestFormItemIDs(form.Items); !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected item ids %v, got %v", expected, ids)
+	}
+	items := applyTestOperations(t, form.Items, []*FormItemOperation{
+		{Seq: 1, UpdateAction: moveAction, ItemID: form.ID + "-2", AfterID: ""},
+		{Seq: 2, UpdateAction: removeAction, ItemID: form.ID + "-0"},
+	})
+	expected = []string{form.ID + "-2", "kept"}
+	if ids := getTestFormItemIDs(items); !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected items %v, got %v", expected, ids)
+	}
+}
+
+// TestGetFormLegacyItemIDs loads a form saved without item ids through getForm. it needs a
+// mongodb to write to, given by MONGOTESTURI
+func TestGetFormLegacyItemIDs(t *testing.T) {
+	mongouri := os.Getenv("MONGOTESTURI")
+	if len(mongouri) == 0 {
+		t.Skip("MONGOTESTURI not set")
+	}
+	ctxMongo = context.Background()
+	connectCtx, cancel := context.WithTimeout(ctxMongo, 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(mongouri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongodb: %s", err)
+	}
+	defer client.Disconnect(ctxMongo)
+	database := client.Database("test-" + primitive.NewObjectID().Hex())
+	defer database.Drop(ctxMongo)
+	formCollection = database.Collection(formMongoName)
+	formID := primitive.NewObjectID()
+	_, err = formCollection.InsertOne(ctxMongo, bson.M{
+		"_id":     formID,
+		"name":    "legacy",
+		"updated": time.Now().Unix(),
+		"access":  bson.M{},
+		"items": bson.A{
+			bson.M{
+				"question": "first",
+				"type":     "text",
+			},
+			bson.M{
+				"question": "second",
+				"type":     "text",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot insert form: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		// the ids have to be the same every time the form is loaded
+		form, err := getForm(formID, false)
+		if err != nil {
+			t.Fatalf("cannot get form: %s", err)
+		}
+		expected := []string{formID.Hex() + "-0", formID.Hex() + "-1"}
+		if ids := getTestFormItemIDs(form.Items); !reflect.DeepEqual(ids, expected) {
+			t.Fatalf("expected item ids %v, got %v", expected, ids)
+		}
+		if form.Items[1].Question != "second" {
+			t.Fatalf("unexpected item %+v", *form.Items[1])
+		}
+	}
+}
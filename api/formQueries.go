@@ -239,7 +239,11 @@ var formQueryFields = graphql.Fields{
 					mustQueries[0] = elastic.NewTermQuery("project", project)
 				} else if !showEverything {
 					// get all forms user has shared access to
-					mustQueries[0] = elastic.NewTermsQuery(fmt.Sprintf("access.%s.type", userIDString), stringListToInterfaceList(viewAccessLevel)...)
+					accessQuery, err := getAccessQuery(userIDString, viewAccessLevel)
+					if err != nil {
+						return nil, err
+					}
+					mustQueries[0] = accessQuery
 				}
 				for i, tag := range tags {
 					mustQueries[i+startIndex] = elastic.NewTermQuery(fmt.Sprintf("access.%s.tags", userIDString), tag)
@@ -248,6 +252,9 @@ var formQueryFields = graphql.Fields{
 					mustQueries[i+numtags+startIndex] = elastic.NewTermQuery(fmt.Sprintf("access.%s.categories", userIDString), category)
 				}
 				query := elastic.NewBoolQuery().Must(mustQueries...)
+				if apiKeyProjects := getAPIKeyProjects(claims); len(apiKeyProjects) > 0 {
+					query = query.Filter(elastic.NewTermsQuery("project", stringListToInterfaceList(apiKeyProjects)...))
+				}
 				if len(searchterm) > 0 {
 					mainquery := elastic.NewMultiMatchQuery(searchterm, formSearchFields...)
 					query = query.Filter(mainquery)
@@ -349,6 +356,7 @@ var formQueryFields = graphql.Fields{
 				}
 			}
 			var getFormUpdateToken = false
+			var getStartToken = false
 			var getFileOriginal = false
 			var getFileBlur = false
 			var getFilePlaceholder = false
@@ -363,6 +371,10 @@ var formQueryFields = graphql.Fields{
 					getFormUpdateToken = true
 					continue
 				}
+				if fieldast.Name.Value == "responseStartToken" {
+					getStartToken = true
+					continue
+				}
 				if fieldast.Name.Value == "files" {
 					fileSelections := fieldast.GetSelectionSet().Selections
 					for _, fileField := range fileSelections {
@@ -465,6 +477,18 @@ var formQueryFields = graphql.Fields{
 				return nil, err
 			}
 			if getFormUpdateToken {
+				// editors start from the items including updates not saved yet
+				operations, err := getFormItemOperations(formIDString, form.Seq)
+				if err != nil {
+					return nil, err
+				}
+				if len(operations) > 0 {
+					form.Items, err = applyFormItemOperations(form.Items, operations)
+					if err != nil {
+						return nil, err
+					}
+					form.Seq = operations[len(operations)-1].Seq
+				}
 				// return access token with current claims + project
 				expirationTime := time.Now().Add(time.Duration(tokenExpiration) * time.Hour)
 				uuid, err := uuid.NewRandom()
@@ -472,7 +496,7 @@ var formQueryFields = graphql.Fields{
 					return nil, err
 				}
 				connectionIDString := uuid.String()
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, formAccessClaims{
+				tokenString, err := signToken(formAccessClaims{
 					formIDString,
 					userIDString,
 					connectionIDString,
@@ -482,12 +506,17 @@ var formQueryFields = graphql.Fields{
 						Issuer:    jwtIssuer,
 					},
 				})
-				tokenString, err := token.SignedString(jwtSecret)
 				if err != nil {
 					return nil, err
 				}
 				form.UpdatesAccessToken = tokenString
 			}
+			if getStartToken {
+				form.ResponseStartToken, err = getResponseStartToken(formIDString)
+				if err != nil {
+					return nil, err
+				}
+			}
 			updateDBData := bson.M{
 				"$inc": bson.M{
 					"views": 1,
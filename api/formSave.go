@@ -0,0 +1,425 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// every form update is written to the update log in mongo before it is sent to redis or to
+// other editors. the saveForm task applies logged updates after the form's saved sequence
+// number, and sets the sequence number in the same write, so running it again is harmless.
+// updates are only removed from the log once they are saved.
+
+var formSaveErrorPath = "form-save-error-"
+
+// FormUpdateData changes from one form update
+type FormUpdateData struct {
+	Operations []*FormItemOperation     `json:"operations"`
+	Settings   map[string]interface{}   `json:"settings"`
+	Files      []map[string]interface{} `json:"files"`
+}
+
+// FormUpdateLogEntry logged form update, using sequence numbers first to seq
+type FormUpdateLogEntry struct {
+	Form    string `json:"form"`
+	First   int64  `json:"first"`
+	Seq     int64  `json:"seq"`
+	Update  string `json:"update"`
+	Created int64  `json:"created"`
+}
+
+// FormSaveFailure form updates that could not be saved after all retries
+type FormSaveFailure struct {
+	ID      string `json:"id"`
+	Form    string `json:"form"`
+	Owner   string `json:"owner"`
+	Error   string `json:"error"`
+	Pending int64  `json:"pending"`
+	Created int64  `json:"created"`
+}
+
+// FormSaveFailureType graphql form save failure object
+var FormSaveFailureType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "FormSaveFailure",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"form": &graphql.Field{
+			Type: graphql.String,
+		},
+		"owner": &graphql.Field{
+			Type: graphql.String,
+		},
+		"error": &graphql.Field{
+			Type:        graphql.String,
+			Description: "error from the last attempt",
+		},
+		"pending": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "number of updates not saved",
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+func getLastFormUpdateLogSeq(formIDString string) (int64, error) {
+	var entry FormUpdateLogEntry
+	err := formUpdateLogCollection.FindOne(ctxMongo, bson.M{
+		"form": formIDString,
+	}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return entry.Seq, nil
+}
+
+// logFormUpdate gives the update sequence numbers and writes it to the update log
+func logFormUpdate(formIDString string, update *FormUpdateData) error {
+	count := int64(len(update.Operations))
+	if count == 0 {
+		if len(update.Settings) == 0 && len(update.Files) == 0 {
+			return nil
+		}
+		// settings and files still need a place in the order of updates
+		count = 1
+	}
+	first, err := reserveFormSeq(formIDString, count)
+	if err != nil {
+		return err
+	}
+	for i, operation := range update.Operations {
+		operation.Seq = first + int64(i)
+	}
+	return insertFormUpdateLog(formIDString, first, first+count-1, update)
+}
+
+func insertFormUpdateLog(formIDString string, first int64, last int64, update *FormUpdateData) error {
+	updateJSON, err := json.MarshalToString(update)
+	if err != nil {
+		return err
+	}
+	_, err = formUpdateLogCollection.InsertOne(ctxMongo, bson.M{
+		"form":    formIDString,
+		"first":   first,
+		"seq":     last,
+		"update":  updateJSON,
+		"created": time.Now().Unix(),
+	})
+	return err
+}
+
+func getFormUpdateLog(formIDString string, since int64) ([]*FormUpdateLogEntry, error) {
+	cursor, err := formUpdateLogCollection.Find(ctxMongo, bson.M{
+		"form": formIDString,
+		"seq": bson.M{
+			"$gt": since,
+		},
+	}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctxMongo)
+	entries := []*FormUpdateLogEntry{}
+	for cursor.Next(ctxMongo) {
+		var entry FormUpdateLogEntry
+		if err = cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func deleteFormUpdateLog(formIDString string, savedSeq int64) error {
+	filter := bson.M{
+		"form": formIDString,
+	}
+	if savedSeq >= 0 {
+		filter["seq"] = bson.M{
+			"$lte": savedSeq,
+		}
+	}
+	_, err := formUpdateLogCollection.DeleteMany(ctxMongo, filter)
+	return err
+}
+
+// saveFormUpdates runs a save attempt, keeping the error for the dead letter list
+func saveFormUpdates(formIDString string) error {
+	err := updateForm(formIDString)
+	if err != nil {
+		logger.Error("cannot save form " + formIDString + ": " + err.Error())
+		if setErr := redisClient.Set(formSaveErrorPath+formIDString, err.Error(), 0).Err(); setErr != nil {
+			logger.Error(setErr.Error())
+		}
+		return err
+	}
+	if err = redisClient.Del(formSaveErrorPath + formIDString).Err(); err != nil {
+		logger.Error(err.Error())
+	}
+	return nil
+}
+
+// recordFormSaveFailure adds the form to the dead letter list once all retries failed
+func recordFormSaveFailure(formIDString string) error {
+	formID, err := primitive.ObjectIDFromHex(formIDString)
+	if err != nil {
+		return err
+	}
+	form, err := getForm(formID, false)
+	if err != nil {
+		return err
+	}
+	pending, err := formUpdateLogCollection.CountDocuments(ctxMongo, bson.M{
+		"form": formIDString,
+		"seq": bson.M{
+			"$gt": form.Seq,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	saveError, err := redisClient.Get(formSaveErrorPath + formIDString).Result()
+	if err != nil {
+		saveError = "unknown error"
+	}
+	_, err = formSaveFailureCollection.UpdateOne(ctxMongo, bson.M{
+		"form": formIDString,
+	}, bson.M{
+		"$set": bson.M{
+			"form":    formIDString,
+			"owner":   form.Owner,
+			"error":   saveError,
+			"pending": pending,
+			"created": time.Now().Unix(),
+		},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	ownerID, err := primitive.ObjectIDFromHex(form.Owner)
+	if err != nil {
+		return err
+	}
+	publishAccountChanged(ownerID)
+	return nil
+}
+
+func deleteFormSaveFailures(formIDString string) error {
+	_, err := formSaveFailureCollection.DeleteMany(ctxMongo, bson.M{
+		"form": formIDString,
+	})
+	return err
+}
+
+// sweepFormUpdates saves everything still pending when the api starts, including form
+// state only found in redis
+func sweepFormUpdates() {
+	forms, err := formUpdateLogCollection.Distinct(ctxMongo, "form", bson.M{})
+	if err != nil {
+		logger.Error("cannot get pending form updates: " + err.Error())
+		return
+	}
+	formIDStrings := []string{}
+	for _, form := range forms {
+		if formIDString, ok := form.(string); ok {
+			formIDStrings = append(formIDStrings, formIDString)
+		}
+	}
+	for _, path := range []string{updateFormPath, formOperationsPath} {
+		keys, err := scanRedisKeys(path + "*")
+		if err != nil {
+			logger.Error("cannot get pending redis form updates: " + err.Error())
+			continue
+		}
+		for _, key := range keys {
+			formIDString := strings.TrimPrefix(key, path)
+			if findInArray(formIDString, formIDStrings) {
+				continue
+			}
+			if err = logRedisFormUpdates(formIDString); err != nil {
+				logger.Error("cannot log redis updates for form " + formIDString + ": " + err.Error())
+				continue
+			}
+			formIDStrings = append(formIDStrings, formIDString)
+		}
+	}
+	for _, formIDString := range formIDStrings {
+		if err = saveFormUpdates(formIDString); err != nil {
+			// let the queue retry it
+			queueFormSave(formIDString)
+		}
+	}
+	logger.Info("form update sweep done")
+}
+
+func scanRedisKeys(pattern string) ([]string, error) {
+	keys := []string{}
+	var cursor uint64
+	for {
+		currentKeys, nextCursor, err := redisClient.Scan(cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, currentKeys...)
+		if nextCursor == 0 {
+			return keys, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+// logRedisFormUpdates writes form state kept only in redis to the update log
+func logRedisFormUpdates(formIDString string) error {
+	formID, err := primitive.ObjectIDFromHex(formIDString)
+	if err != nil {
+		return err
+	}
+	form, err := getForm(formID, false)
+	if err == mongo.ErrNoDocuments {
+		// form was deleted
+		return nil
+	} else if err != nil {
+		return err
+	}
+	operations, err := getFormItemOperations(formIDString, form.Seq)
+	if err != nil {
+		return err
+	}
+	update := &FormUpdateData{
+		Settings: map[string]interface{}{},
+	}
+	var savedUpdateDataObj map[string]interface{}
+	savedUpdateData, err := redisClient.Get(updateFormPath + formIDString).Result()
+	if err == nil {
+		if err = json.UnmarshalFromString(savedUpdateData, &savedUpdateDataObj); err != nil {
+			return err
+		}
+		for _, key := range []string{"name", "multiple", "public"} {
+			if savedUpdateDataObj[key] != nil {
+				update.Settings[key] = savedUpdateDataObj[key]
+			}
+		}
+		if savedUpdateDataObj["files"] != nil {
+			filesInterface, ok := savedUpdateDataObj["files"].([]interface{})
+			if !ok {
+				return errors.New("problem casting files to interface array")
+			}
+			update.Files, err = interfaceListToMapList(filesInterface)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(operations) == 0 {
+		return logFormUpdate(formIDString, update)
+	}
+	// the operations already have sequence numbers, and are everything after the saved form
+	update.Operations = operations
+	last := operations[len(operations)-1].Seq
+	if len(update.Settings) > 0 || len(update.Files) > 0 {
+		last, err = reserveFormSeq(formIDString, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return insertFormUpdateLog(formIDString, form.Seq+1, last, update)
+}
+
+var formSaveQueryFields = graphql.Fields{
+	"formSaveFailures": &graphql.Field{
+		Type:        graphql.NewList(FormSaveFailureType),
+		Description: "Get your forms with updates that could not be saved",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			cursor, err := formSaveFailureCollection.Find(ctxMongo, bson.M{
+				"owner": userIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			failures := []*FormSaveFailure{}
+			for cursor.Next(ctxMongo) {
+				var failure FormSaveFailure
+				if err = cursor.Decode(&failure); err != nil {
+					return nil, err
+				}
+				failureID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find failure id")
+				}
+				failure.ID = failureID.Hex()
+				failures = append(failures, &failure)
+			}
+			return failures, nil
+		},
+	},
+}
+
+var formSaveMutationFields = graphql.Fields{
+	"retryFormSave": &graphql.Field{
+		Type:        FormSaveFailureType,
+		Description: "Try saving a form with updates that could not be saved again",
+		Args: graphql.FieldConfigArgument{
+			"form": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			if params.Args["form"] == nil {
+				return nil, errors.New("form id not provided")
+			}
+			formIDString, ok := params.Args["form"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast form id to string")
+			}
+			var failure FormSaveFailure
+			err = formSaveFailureCollection.FindOne(ctxMongo, bson.M{
+				"form": formIDString,
+			}).Decode(&failure)
+			if err != nil {
+				return nil, errors.New("no failed save found for form")
+			}
+			if failure.Owner != userIDString {
+				return nil, errors.New("you are not the owner of the form")
+			}
+			if err = saveFormUpdates(formIDString); err != nil {
+				return nil, err
+			}
+			if err = deleteFormSaveFailures(formIDString); err != nil {
+				return nil, err
+			}
+			failure.Pending = 0
+			return &failure, nil
+		},
+	},
+}
@@ -6,114 +6,57 @@ import (
 
 	json "github.com/json-iterator/go"
 	"github.com/mitchellh/mapstructure"
+	"github.com/olivere/elastic/v7"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func updateForm(formIDString string) error {
-	// get update data from redis, then update elastic and mongodb from it
-	savedUpdateData, err := redisClient.Get(updateFormPath + formIDString).Result()
-	if err != nil {
-		// no update data found
-		return nil
-	}
-	var savedUpdateDataObj map[string]interface{}
-	err = json.UnmarshalFromString(savedUpdateData, &savedUpdateDataObj)
-	if err != nil {
-		return err
-	}
-	formID, err := primitive.ObjectIDFromHex(formIDString)
-	if err != nil {
-		return err
-	}
-	formData, err := getForm(formID, false)
-	if err != nil {
-		return err
-	}
-	updateDataDB := bson.M{
-		"$set": bson.M{},
+// queueFormSave saves the pending updates to the form after the autosave time
+func queueFormSave(formIDString string) {
+	msg := saveFormTask.WithArgs(ctxMessageQueue, formIDString).OnceInPeriod(time.Duration(autosaveTime) * time.Second)
+	msg.Delay = time.Duration(autosaveTime) * time.Second
+	if err := messageQueue.Add(msg); err != nil {
+		logger.Info("update already saved: " + err.Error())
 	}
-	updateDataElastic := bson.M{}
-	if savedUpdateDataObj["name"] != nil {
-		name, ok := savedUpdateDataObj["name"].(string)
+}
+
+// applyFormUpdate applies a logged update to the form, marking what changed in updateData
+func applyFormUpdate(formData *Form, update *FormUpdateData, updateData bson.M) error {
+	if update.Settings["name"] != nil {
+		name, ok := update.Settings["name"].(string)
 		if !ok {
 			return errors.New("problem casting name to string")
 		}
-		updateDataDB["$set"].(bson.M)["name"] = name
-		updateDataElastic["name"] = name
+		formData.Name = name
+		updateData["name"] = name
 	}
-	if savedUpdateDataObj["multiple"] != nil {
-		multiple, ok := savedUpdateDataObj["multiple"].(bool)
+	if update.Settings["multiple"] != nil {
+		multiple, ok := update.Settings["multiple"].(bool)
 		if !ok {
 			return errors.New("problem casting multple to bool")
 		}
-		updateDataDB["$set"].(bson.M)["multiple"] = multiple
-		updateDataElastic["multiple"] = multiple
-	}
-	if savedUpdateDataObj["items"] != nil {
-		itemsUpdateInterface, ok := savedUpdateDataObj["items"].([]interface{})
-		if !ok {
-			return errors.New("problem casting items to interface array")
-		}
-		itemsUpdate, err := interfaceListToMapList(itemsUpdateInterface)
-		if err != nil {
-			return err
-		}
-		for _, itemUpdate := range itemsUpdate {
-			action := itemUpdate["updateAction"].(string)
-			delete(itemUpdate, "updateAction")
-			var itemObj *FormItem
-			if err = mapstructure.Decode(itemUpdate, &itemObj); err != nil {
-				return err
-			}
-			if action == validUpdateArrayActions[0] {
-				// add
-				delete(itemUpdate, "index")
-				formData.Items = append(formData.Items, itemObj)
-			} else {
-				index := int(itemUpdate["index"].(float64))
-				delete(itemUpdate, "index")
-				if index >= len(formData.Items) || index < 0 {
-					continue
-				}
-				if action == validUpdateArrayActions[1] {
-					// remove
-					formData.Items = append(formData.Items[:index], formData.Items[index+1:]...)
-				} else if action == validUpdateArrayActions[2] {
-					// move to new index
-					newIndex := int(itemUpdate["newIndex"].(float64))
-					delete(itemUpdate, "newIndex")
-					err = moveSliceFormItems(formData.Items, index, newIndex)
-					if err != nil {
-						return err
-					}
-				} else if action == validUpdateArrayActions[3] {
-					// set index to value
-					formData.Items[index] = itemObj
-				}
-			}
-		}
-		updateDataDB["$set"].(bson.M)["items"] = formData.Items
-		updateDataElastic["items"] = formData.Items
+		formData.Multiple = multiple
+		updateData["multiple"] = multiple
 	}
-	if savedUpdateDataObj["public"] != nil {
-		public, ok := savedUpdateDataObj["public"].(string)
+	if update.Settings["public"] != nil {
+		public, ok := update.Settings["public"].(string)
 		if !ok {
 			return errors.New("problem casting public to string")
 		}
-		updateDataDB["$set"].(bson.M)["public"] = public
-		updateDataElastic["public"] = public
+		formData.Public = public
+		updateData["public"] = public
 	}
-	if savedUpdateDataObj["files"] != nil {
-		filesUpdateInterface, ok := savedUpdateDataObj["files"].([]interface{})
-		if !ok {
-			return errors.New("problem casting files to interface array")
-		}
-		filesUpdate, err := interfaceListToMapList(filesUpdateInterface)
+	if len(update.Operations) > 0 {
+		var err error
+		formData.Items, err = applyFormItemOperations(formData.Items, update.Operations)
 		if err != nil {
 			return err
 		}
-		for _, fileUpdate := range filesUpdate {
+		updateData["items"] = formData.Items
+	}
+	if len(update.Files) > 0 {
+		for _, fileUpdate := range update.Files {
 			index := int(fileUpdate["index"].(float64))
 			delete(fileUpdate, "index")
 			delete(fileUpdate, "fileIndex")
@@ -121,7 +64,7 @@ func updateForm(formIDString string) error {
 			action := fileUpdate["updateAction"].(string)
 			delete(fileUpdate, "updateAction")
 			var fileObj *File
-			if err = mapstructure.Decode(fileUpdate, &fileObj); err != nil {
+			if err := mapstructure.Decode(fileUpdate, &fileObj); err != nil {
 				return err
 			}
 			if action == validUpdateMapActions[0] {
@@ -135,56 +78,150 @@ func updateForm(formIDString string) error {
 					}
 				} else if action == validUpdateMapActions[2] {
 					// set to value
-					formData.Files[index] = fileObj
+					if index >= 0 && index < len(formData.Files) {
+						formData.Files[index] = fileObj
+					}
 				}
 			}
 		}
 		fileData := make([]*FileDB, len(formData.Files))
 		for i := range formData.Files {
-			if err = mapstructure.Decode(formData.Files[i], &fileData[i]); err != nil {
+			if err := mapstructure.Decode(formData.Files[i], &fileData[i]); err != nil {
 				return err
 			}
 		}
-		updateDataDB["$set"].(bson.M)["files"] = fileData
-		updateDataElastic["files"] = fileData
+		updateData["files"] = fileData
 	}
-	if formData.Responses > 0 {
-		// delete all previous responses (if there are any)
-		bytesRemoved, err := deleteAllResponses(formID)
-		if err != nil {
-			return err
-		}
-		ownerID, err := primitive.ObjectIDFromHex(formData.Owner)
-		if err != nil {
-			return err
-		}
-		if err = changeUserStorage(ownerID, -1*bytesRemoved); err != nil {
-			return err
-		}
-		formData.Responses = 0
-		updateDataElastic["responses"] = int64(0)
-		updateDataDB["$set"].(bson.M)["responses"] = int64(0)
+	return nil
+}
+
+// updateFormElastic sets the saved fields in elastic, unless a later save got there first
+func updateFormElastic(formIDString string, seq int64, updateData bson.M) error {
+	doc := bson.M{}
+	for key, value := range updateData {
+		doc[key] = value
 	}
-	updateDataElastic["updated"] = time.Now().Unix()
-	delete(updateDataElastic, "created")
-	_, err = elasticClient.Update().
+	doc["seq"] = seq
+	script := elastic.NewScript(saveFormScript).Params(map[string]interface{}{
+		"seq": seq,
+		"doc": doc,
+	})
+	_, err := elasticClient.Update().
 		Index(formElasticIndex).
 		Type(formElasticType).
 		Id(formIDString).
-		Doc(updateDataElastic).
+		Script(script).
 		Do(ctxElastic)
+	return err
+}
+
+func updateForm(formIDString string) error {
+	// apply logged updates after the saved sequence number to mongodb, then elastic
+	formID, err := primitive.ObjectIDFromHex(formIDString)
 	if err != nil {
 		return err
 	}
-	_, err = formCollection.UpdateOne(ctxMongo, bson.M{
-		"_id": formID,
-	}, updateDataDB)
-	if err != nil {
+	formData, err := getForm(formID, false)
+	if err == mongo.ErrNoDocuments {
+		// form was deleted, nothing left to save
+		return deleteFormUpdateLog(formIDString, -1)
+	} else if err != nil {
 		return err
 	}
-	err = redisClient.Del(updateFormPath + formIDString).Err()
+	entries, err := getFormUpdateLog(formIDString, formData.Seq)
 	if err != nil {
+		return err
+	}
+	savedSeq := formData.Seq
+	updateData := bson.M{}
+	var waiting = false
+	for _, entry := range entries {
+		if entry.First > savedSeq+1 && time.Since(time.Unix(entry.Created, 0)) < time.Duration(formSaveGapTimeout)*time.Second {
+			// an earlier update is still being logged. past the timeout it is taken as lost
+			waiting = true
+			break
+		}
+		var update FormUpdateData
+		if err = json.UnmarshalFromString(entry.Update, &update); err != nil {
+			return err
+		}
+		if err = applyFormUpdate(formData, &update, updateData); err != nil {
+			return err
+		}
+		savedSeq = entry.Seq
+	}
+	if savedSeq > formData.Seq {
+		if formData.Responses > 0 {
+			// delete all previous responses (if there are any)
+			bytesRemoved, err := deleteAllResponses(formID)
+			if err != nil {
+				return err
+			}
+			ownerID, err := primitive.ObjectIDFromHex(formData.Owner)
+			if err != nil {
+				return err
+			}
+			if err = changeOwnerStorage(ownerID, formData.Org, -1*bytesRemoved); err != nil {
+				return err
+			}
+			formData.Responses = 0
+			updateData["responses"] = int64(0)
+		}
+		updateData["seq"] = savedSeq
+		// only save over the state this save started from
+		seqFilter := bson.M{
+			"seq": formData.Seq,
+		}
+		if formData.Seq == 0 {
+			seqFilter = bson.M{
+				"seq": bson.M{
+					"$in": bson.A{0, nil},
+				},
+			}
+		}
+		result, err := formCollection.UpdateOne(ctxMongo, bson.M{
+			"$and": bson.A{
+				bson.M{
+					"_id": formID,
+				},
+				seqFilter,
+			},
+		}, bson.M{
+			"$set": updateData,
+		})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errors.New("form was saved by another task, trying again")
+		}
+		updateData["updated"] = time.Now().Unix()
+	} else {
+		// nothing new, make sure elastic has the last save
+		updateData = bson.M{
+			"name":     formData.Name,
+			"multiple": formData.Multiple,
+			"public":   formData.Public,
+			"items":    formData.Items,
+		}
+	}
+	if err = updateFormElastic(formIDString, savedSeq, updateData); err != nil {
+		return err
+	}
+	if err = redisClient.Del(updateFormPath + formIDString).Err(); err != nil {
+		logger.Error(err.Error())
+	}
+	if err = trimFormItemOperations(formIDString, savedSeq); err != nil {
 		logger.Error(err.Error())
 	}
+	if err = deleteFormUpdateLog(formIDString, savedSeq); err != nil {
+		logger.Error(err.Error())
+	}
+	if err = deleteFormSaveFailures(formIDString); err != nil {
+		logger.Error(err.Error())
+	}
+	if waiting {
+		return errors.New("waiting for earlier form updates to be logged")
+	}
 	return nil
 }
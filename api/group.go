@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/olivere/elastic/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// groups can be given access to projects and forms like users. access entries for a group
+// are keyed by the group id and marked with group, and membership is only stored with the
+// group, so changing members does not change any project or form.
+
+// Group named set of users sharing access
+type Group struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	Members []string `json:"members"`
+	Created int64    `json:"created"`
+	Updated int64    `json:"updated"`
+}
+
+// GroupType graphql group object
+var GroupType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Group",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"name": &graphql.Field{
+			Type: graphql.String,
+		},
+		"owner": &graphql.Field{
+			Type: graphql.String,
+		},
+		"members": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "user ids, including the owner",
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"updated": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+func getGroup(groupID primitive.ObjectID, updated bool) (*Group, error) {
+	var group Group
+	err := groupCollection.FindOne(ctxMongo, bson.M{
+		"_id": groupID,
+	}).Decode(&group)
+	if err != nil {
+		return nil, err
+	}
+	group.Created = objectidTimestamp(groupID).Unix()
+	if updated {
+		group.Updated = time.Now().Unix()
+	}
+	group.ID = groupID.Hex()
+	return &group, nil
+}
+
+// getUserGroupIDs returns the ids of all groups the user is a member of
+func getUserGroupIDs(userIDString string) ([]string, error) {
+	cursor, err := groupCollection.Find(ctxMongo, bson.M{
+		"members": userIDString,
+	}, options.Find().SetProjection(bson.M{
+		"_id": 1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctxMongo)
+	groupIDs := []string{}
+	for cursor.Next(ctxMongo) {
+		groupID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			return nil, errors.New("cannot find group id")
+		}
+		groupIDs = append(groupIDs, groupID.Hex())
+	}
+	return groupIDs, nil
+}
+
+// getUserAccessType returns the access the user has in the access map, either directly or
+// through the groups they are in, picking the highest access
+func getUserAccessType(access map[string]bson.M, userIDString string) (string, error) {
+	accessIDs := []string{userIDString}
+	var hasGroups = false
+	for _, accessData := range access {
+		if isGroup, _ := accessData["group"].(bool); isGroup {
+			hasGroups = true
+			break
+		}
+	}
+	if hasGroups {
+		groupIDs, err := getUserGroupIDs(userIDString)
+		if err != nil {
+			return "", err
+		}
+		accessIDs = append(accessIDs, groupIDs...)
+	}
+	return getBestAccessType(access, accessIDs), nil
+}
+
+// getBestAccessType returns the highest access any of the ids has in the access map
+func getBestAccessType(access map[string]bson.M, accessIDs []string) string {
+	var bestAccessType = ""
+	for _, accessID := range accessIDs {
+		accessData, ok := access[accessID]
+		if !ok {
+			continue
+		}
+		accessType, _ := accessData["type"].(string)
+		if len(bestAccessType) == 0 || getAccessTypeRank(accessType) < getAccessTypeRank(bestAccessType) {
+			bestAccessType = accessType
+		}
+	}
+	return bestAccessType
+}
+
+// getAccessTypeRank orders access types from most to least access
+func getAccessTypeRank(accessType string) int {
+	for i, currentType := range validAccessTypes {
+		if currentType == accessType {
+			return i
+		}
+	}
+	return len(validAccessTypes)
+}
+
+// getAccessQuery returns an elastic query matching documents the user can access with one
+// of the given types, directly or through a group
+func getAccessQuery(userIDString string, accessLevel []string) (elastic.Query, error) {
+	groupIDs, err := getUserGroupIDs(userIDString)
+	if err != nil {
+		return nil, err
+	}
+	accessTypes := stringListToInterfaceList(accessLevel)
+	shouldQueries := make([]elastic.Query, len(groupIDs)+1)
+	shouldQueries[0] = elastic.NewTermsQuery(fmt.Sprintf("access.%s.type", userIDString), accessTypes...)
+	for i, groupID := range groupIDs {
+		shouldQueries[i+1] = elastic.NewTermsQuery(fmt.Sprintf("access.%s.type", groupID), accessTypes...)
+	}
+	return elastic.NewBoolQuery().Should(shouldQueries...).MinimumNumberShouldMatch(1), nil
+}
+
+// checkGroupShare returns an error if the user cannot share with the group
+func checkGroupShare(groupIDString string, userIDString string) error {
+	groupID, err := primitive.ObjectIDFromHex(groupIDString)
+	if err != nil {
+		return err
+	}
+	group, err := getGroup(groupID, false)
+	if err != nil {
+		return errors.New("cannot find group")
+	}
+	if !findInArray(userIDString, group.Members) {
+		return errors.New("you can only share with groups you are in")
+	}
+	return nil
+}
+
+// removeGroupAccess takes the access of a deleted group off all projects and forms
+func removeGroupAccess(groupIDString string) error {
+	accessKey := "access." + groupIDString
+	for _, collection := range []string{projectMongoName, formMongoName} {
+		_, err := mongoClient.Database(mainDatabase).Collection(collection).UpdateMany(ctxMongo, bson.M{
+			accessKey: bson.M{
+				"$exists": true,
+			},
+		}, bson.M{
+			"$unset": bson.M{
+				accessKey: 1,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	script := elastic.NewScript("ctx._source.access.remove(params.id)").Params(map[string]interface{}{
+		"id": groupIDString,
+	})
+	_, err := elasticClient.UpdateByQuery(projectElasticIndex, formElasticIndex).
+		Query(elastic.NewExistsQuery(accessKey + ".type")).
+		Script(script).
+		Do(ctxElastic)
+	return err
+}
+
+func getGroupArgs(params graphql.ResolveParams) (*Group, string, error) {
+	claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+	if err != nil {
+		return nil, "", err
+	}
+	userIDString, ok := claims["id"].(string)
+	if !ok {
+		return nil, "", errors.New("cannot cast user id to string")
+	}
+	if params.Args["id"] == nil {
+		return nil, "", errors.New("group id not provided")
+	}
+	groupIDString, ok := params.Args["id"].(string)
+	if !ok {
+		return nil, "", errors.New("cannot cast group id to string")
+	}
+	groupID, err := primitive.ObjectIDFromHex(groupIDString)
+	if err != nil {
+		return nil, "", err
+	}
+	group, err := getGroup(groupID, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return group, userIDString, nil
+}
+
+func getGroupMemberArg(params graphql.ResolveParams) (string, error) {
+	if params.Args["member"] == nil {
+		return "", errors.New("member not provided")
+	}
+	memberIDString, ok := params.Args["member"].(string)
+	if !ok {
+		return "", errors.New("cannot cast member to string")
+	}
+	if _, err := primitive.ObjectIDFromHex(memberIDString); err != nil {
+		return "", err
+	}
+	return memberIDString, nil
+}
+
+func setGroupMembers(group *Group) error {
+	groupID, err := primitive.ObjectIDFromHex(group.ID)
+	if err != nil {
+		return err
+	}
+	_, err = groupCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": groupID,
+	}, bson.M{
+		"$set": bson.M{
+			"members": group.Members,
+			"updated": group.Updated,
+		},
+	})
+	return err
+}
+
+var groupQueryFields = graphql.Fields{
+	"groups": &graphql.Field{
+		Type:        graphql.NewList(GroupType),
+		Description: "Get the groups you are in",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			cursor, err := groupCollection.Find(ctxMongo, bson.M{
+				"members": userIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			groups := []*Group{}
+			for cursor.Next(ctxMongo) {
+				var group Group
+				if err = cursor.Decode(&group); err != nil {
+					return nil, err
+				}
+				groupID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find group id")
+				}
+				group.ID = groupID.Hex()
+				group.Created = objectidTimestamp(groupID).Unix()
+				groups = append(groups, &group)
+			}
+			return groups, nil
+		},
+	},
+}
+
+var groupMutationFields = graphql.Fields{
+	"addGroup": &graphql.Field{
+		Type:        GroupType,
+		Description: "Create a group to share projects and forms with",
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"members": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(graphql.String),
+				Description: "user ids",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			if params.Args["name"] == nil {
+				return nil, errors.New("name not provided")
+			}
+			name, ok := params.Args["name"].(string)
+			if !ok {
+				return nil, errors.New("problem casting name to string")
+			}
+			members := []string{userIDString}
+			if params.Args["members"] != nil {
+				membersInterface, ok := params.Args["members"].([]interface{})
+				if !ok {
+					return nil, errors.New("problem casting members to interface array")
+				}
+				newMembers, err := interfaceListToStringList(membersInterface)
+				if err != nil {
+					return nil, err
+				}
+				for _, member := range newMembers {
+					if _, err = primitive.ObjectIDFromHex(member); err != nil {
+						return nil, err
+					}
+					if !findInArray(member, members) {
+						members = append(members, member)
+					}
+				}
+			}
+			groupID := primitive.NewObjectID()
+			group := &Group{
+				ID:      groupID.Hex(),
+				Name:    name,
+				Owner:   userIDString,
+				Members: members,
+				Created: objectidTimestamp(groupID).Unix(),
+				Updated: time.Now().Unix(),
+			}
+			_, err = groupCollection.InsertOne(ctxMongo, bson.M{
+				"_id":     groupID,
+				"name":    group.Name,
+				"owner":   group.Owner,
+				"members": group.Members,
+				"updated": group.Updated,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return group, nil
+		},
+	},
+	"addGroupMember": &graphql.Field{
+		Type:        GroupType,
+		Description: "Add a user to a group you own",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"member": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "user id",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			group, userIDString, err := getGroupArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if group.Owner != userIDString {
+				return nil, errors.New("only the owner can change the group")
+			}
+			memberIDString, err := getGroupMemberArg(params)
+			if err != nil {
+				return nil, err
+			}
+			if findInArray(memberIDString, group.Members) {
+				return nil, errors.New("user is already in the group")
+			}
+			group.Members = append(group.Members, memberIDString)
+			if err = setGroupMembers(group); err != nil {
+				return nil, err
+			}
+			return group, nil
+		},
+	},
+	"removeGroupMember": &graphql.Field{
+		Type:        GroupType,
+		Description: "Remove a user from a group you own, or leave a group",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"member": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "user id",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			group, userIDString, err := getGroupArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			memberIDString, err := getGroupMemberArg(params)
+			if err != nil {
+				return nil, err
+			}
+			if group.Owner != userIDString && memberIDString != userIDString {
+				return nil, errors.New("only the owner can change the group")
+			}
+			if memberIDString == group.Owner {
+				return nil, errors.New("the owner cannot leave the group")
+			}
+			members := []string{}
+			for _, member := range group.Members {
+				if member != memberIDString {
+					members = append(members, member)
+				}
+			}
+			if len(members) == len(group.Members) {
+				return nil, errors.New("user is not in the group")
+			}
+			group.Members = members
+			if err = setGroupMembers(group); err != nil {
+				return nil, err
+			}
+			return group, nil
+		},
+	},
+	"deleteGroup": &graphql.Field{
+		Type:        GroupType,
+		Description: "Delete a group you own, removing its access everywhere",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			group, userIDString, err := getGroupArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if group.Owner != userIDString {
+				return nil, errors.New("only the owner can delete the group")
+			}
+			groupID, err := primitive.ObjectIDFromHex(group.ID)
+			if err != nil {
+				return nil, err
+			}
+			_, err = groupCollection.DeleteOne(ctxMongo, bson.M{
+				"_id": groupID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err = removeGroupAccess(group.ID); err != nil {
+				return nil, err
+			}
+			return group, nil
+		},
+	},
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetBestAccessType(t *testing.T) {
+	access := map[string]bson.M{
+		"user": {
+			"type": "view",
+		},
+		"editors": {
+			"type":  "edit",
+			"group": true,
+		},
+		"sharers": {
+			"type":  "shared",
+			"group": true,
+		},
+	}
+	for _, testCase := range []struct {
+		accessIDs []string
+		expected  string
+	}{
+		{[]string{"user"}, "view"},
+		// a group can give more access than the direct entry
+		{[]string{"user", "editors"}, "edit"},
+		// but less access through a group does not lower it
+		{[]string{"user", "sharers"}, "view"},
+		{[]string{"other", "sharers"}, "shared"},
+		{[]string{"other"}, ""},
+	} {
+		if accessType := getBestAccessType(access, testCase.accessIDs); accessType != testCase.expected {
+			t.Errorf("%v: expected %q, got %q", testCase.accessIDs, testCase.expected, accessType)
+		}
+	}
+}
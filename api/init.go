@@ -3,6 +3,7 @@ package main
 import (
 	"time"
 
+	"github.com/olivere/elastic/v7"
 	"github.com/stripe/stripe-go"
 	"github.com/vmihailenco/taskq/v2"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,6 +14,18 @@ var saveFormTask *taskq.Task
 
 var updateForexTask *taskq.Task
 
+var sendResponseEmailTask *taskq.Task
+
+var sendCommentMentionTask *taskq.Task
+
+var sendLockoutEmailTask *taskq.Task
+
+var rotateJWTKeysTask *taskq.Task
+
+var exportAccountDataTask *taskq.Task
+
+var deleteAccountExportTask *taskq.Task
+
 func initDefaultPlan() error {
 	_, err := getProduct(primitive.NilObjectID, false)
 	if err != nil {
@@ -37,12 +50,40 @@ func initDefaultPlan() error {
 	return nil
 }
 
+// initFormEditResponses lets respondents edit responses to forms from before the setting existed,
+// like they always could
+func initFormEditResponses() error {
+	_, err := formCollection.UpdateMany(ctxMongo, bson.M{
+		"editresponses": bson.M{
+			"$exists": false,
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"editresponses": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = elasticClient.UpdateByQuery(formElasticIndex).
+		Query(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("editresponses"))).
+		Script(elastic.NewScriptInline("ctx._source.editresponses = true")).
+		Do(ctxElastic)
+	return err
+}
+
 func initQueue() {
 	saveFormTask = taskq.RegisterTask(&taskq.TaskOptions{
 		Name: "saveForm",
 		Handler: func(formIDString string) error {
-			return updateForm(formIDString)
+			return saveFormUpdates(formIDString)
+		},
+		FallbackHandler: func(formIDString string) error {
+			return recordFormSaveFailure(formIDString)
 		},
+		RetryLimit: formSaveRetryLimit,
+		MinBackoff: time.Duration(formSaveMinBackoff) * time.Second,
+		MaxBackoff: time.Duration(formSaveMaxBackoff) * time.Minute,
 	})
 	updateForexTask = taskq.RegisterTask(&taskq.TaskOptions{
 		Name: "updateForex",
@@ -50,7 +91,59 @@ func initQueue() {
 			return updateForex()
 		},
 	})
+	sendResponseEmailTask = taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "sendResponseEmail",
+		Handler: func(responseIDString string) error {
+			return sendResponseEmail(responseIDString)
+		},
+	})
+	sendCommentMentionTask = taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "sendCommentMention",
+		Handler: func(commentIDString string, accountIDString string) error {
+			return sendCommentMention(commentIDString, accountIDString)
+		},
+	})
+	sendLockoutEmailTask = taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "sendLockoutEmail",
+		Handler: func(email string, ip string, failures int64, until int64) error {
+			return sendLockoutEmail(email, ip, failures, until)
+		},
+	})
+	rotateJWTKeysTask = taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "rotateJWTKeys",
+		Handler: func() error {
+			defer scheduleNextRotateJWTKeys()
+			if err := rotateJWTKeys(); err != nil {
+				return err
+			}
+			return loadJWTKeys()
+		},
+	})
+	exportAccountDataTask = taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "exportAccountData",
+		Handler: func(userIDString string) error {
+			return exportAccountData(userIDString)
+		},
+		FallbackHandler: func(userIDString string) error {
+			return releaseAccountExportRequest(userIDString)
+		},
+	})
+	deleteAccountExportTask = taskq.RegisterTask(&taskq.TaskOptions{
+		Name: "deleteAccountExport",
+		Handler: func(exportPath string) error {
+			return deleteAccountExport(exportPath)
+		},
+	})
 	scheduleNextUpdateForex()
+	scheduleNextRotateJWTKeys()
+}
+
+func scheduleNextRotateJWTKeys() {
+	msg := rotateJWTKeysTask.WithArgs(ctxMessageQueue).OnceInPeriod(time.Duration(jwtKeyRotationCheck) * time.Hour)
+	msg.Delay = time.Duration(jwtKeyRotationCheck) * time.Hour
+	if err := messageQueue.Add(msg); err != nil {
+		logger.Info("key rotation already scheduled: " + err.Error())
+	}
 }
 
 func scheduleNextUpdateForex() {
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tokens are signed with jwtSecret (HS256), or with asymmetric keys (RS256 or EdDSA) when
+// JWTALGORITHM is set. asymmetric keys are shared by all instances through the database, with
+// the private key encrypted with jwtSecret. a new key is published some time before it starts
+// signing, so services that cache /.well-known/jwks.json know it in time. old keys stay
+// published until the tokens they signed have expired. after the switch, tokens without a key id
+// are only checked with jwtSecret until JWTHS256UNTIL (unix time), so tokens from before the switch
+// keep working until they expire, and a leaked jwtSecret cannot sign new ones after that.
+
+// JWTKey signing key
+type JWTKey struct {
+	ID         string `json:"id"`
+	Algorithm  string `json:"algorithm"`
+	PrivateKey string `json:"privatekey"`
+	PublicKey  string `json:"publickey"`
+	Created    int64  `json:"created"`
+	Activates  int64  `json:"activates"`
+	Expires    int64  `json:"expires"`
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+// signingMethodEdDSA ed25519 signatures, which jwt-go does not have
+type signingMethodEdDSA struct{}
+
+var jwtSigningMethodEdDSA = &signingMethodEdDSA{}
+
+func (method *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (method *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (method *signingMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	signatureBytes, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), signatureBytes) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+var jwtKeyCache []*JWTKey
+
+var jwtKeysFetched time.Time
+
+var jwtKeyMutex sync.RWMutex
+
+func initJWTKeys() error {
+	jwt.RegisterSigningMethod(jwtSigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return jwtSigningMethodEdDSA
+	})
+	if len(jwtAlgorithm) == 0 {
+		jwtAlgorithm = validJWTAlgorithms[0]
+	}
+	if !findInArray(jwtAlgorithm, validJWTAlgorithms) {
+		return errors.New("invalid jwt algorithm " + jwtAlgorithm)
+	}
+	if err := rotateJWTKeys(); err != nil {
+		return err
+	}
+	return loadJWTKeys()
+}
+
+func isAsymmetricJWT() bool {
+	return jwtAlgorithm != validJWTAlgorithms[0]
+}
+
+func getJWTKeyCipher() (cipher.AEAD, error) {
+	encryptionKey := sha256.Sum256(jwtSecret)
+	block, err := aes.NewCipher(encryptionKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptJWTKey(privateKeyDER []byte) (string, error) {
+	aead, err := getJWTKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, privateKeyDER, nil)), nil
+}
+
+func decryptJWTKey(encrypted string) ([]byte, error) {
+	aead, err := getJWTKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	encryptedBytes, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptedBytes) < aead.NonceSize() {
+		return nil, errors.New("encrypted key is too short")
+	}
+	return aead.Open(nil, encryptedBytes[:aead.NonceSize()], encryptedBytes[aead.NonceSize():], nil)
+}
+
+// createJWTKey saves a new key of the configured algorithm, which starts signing at activates
+func createJWTKey(activates time.Time) error {
+	var privateKey interface{}
+	var publicKey interface{}
+	if jwtAlgorithm == jwt.SigningMethodRS256.Alg() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, jwtRSAKeySize)
+		if err != nil {
+			return err
+		}
+		privateKey = rsaKey
+		publicKey = &rsaKey.PublicKey
+	} else {
+		ed25519Public, ed25519Private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		privateKey = ed25519Private
+		publicKey = ed25519Public
+	}
+	privateKeyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	encryptedKey, err := encryptJWTKey(privateKeyDER)
+	if err != nil {
+		return err
+	}
+	kid := make([]byte, jwtKeyIDLength)
+	if _, err = rand.Read(kid); err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = jwtKeyCollection.InsertOne(ctxMongo, bson.M{
+		"_id":        base64.RawURLEncoding.EncodeToString(kid),
+		"algorithm":  jwtAlgorithm,
+		"privatekey": encryptedKey,
+		"publickey":  base64.StdEncoding.EncodeToString(publicKeyDER),
+		"created":    now.Unix(),
+		"activates":  activates.Unix(),
+		"expires":    activates.Add(time.Duration(jwtKeyRotationInterval+jwtKeyRetention) * time.Hour).Unix(),
+	})
+	return err
+}
+
+// rotateJWTKeys adds the next key when the signing key is about to be replaced, and removes
+// keys that cannot have signed any valid token anymore
+func rotateJWTKeys() error {
+	now := time.Now()
+	_, err := jwtKeyCollection.DeleteMany(ctxMongo, bson.M{
+		"expires": bson.M{
+			"$lte": now.Unix(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !isAsymmetricJWT() {
+		return nil
+	}
+	var latestKey JWTKey
+	err = jwtKeyCollection.FindOne(ctxMongo, bson.M{
+		"algorithm": jwtAlgorithm,
+	}, options.FindOne().SetSort(bson.M{
+		"activates": -1,
+	})).Decode(&latestKey)
+	if err != nil {
+		// nothing signs with this algorithm yet, so the first key is used right away
+		return createJWTKey(now)
+	}
+	publishDelay := time.Duration(jwtKeyPublishDelay) * time.Hour
+	nextActivation := time.Unix(latestKey.Activates, 0).Add(time.Duration(jwtKeyRotationInterval) * time.Hour)
+	if now.Add(publishDelay).Before(nextActivation) {
+		return nil
+	}
+	if nextActivation.Before(now.Add(publishDelay)) {
+		nextActivation = now.Add(publishDelay)
+	}
+	logger.Info("rotating jwt signing key")
+	return createJWTKey(nextActivation)
+}
+
+func parseJWTKey(key *JWTKey) error {
+	publicKeyDER, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		return err
+	}
+	key.publicKey, err = x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return err
+	}
+	privateKeyDER, err := decryptJWTKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	key.privateKey, err = x509.ParsePKCS8PrivateKey(privateKeyDER)
+	return err
+}
+
+// loadJWTKeys reads the published keys into the cache
+func loadJWTKeys() error {
+	cursor, err := jwtKeyCollection.Find(ctxMongo, bson.M{
+		"expires": bson.M{
+			"$gt": time.Now().Unix(),
+		},
+	}, options.Find().SetSort(bson.M{
+		"activates": -1,
+	}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctxMongo)
+	keys := []*JWTKey{}
+	for cursor.Next(ctxMongo) {
+		var key JWTKey
+		if err = cursor.Decode(&key); err != nil {
+			return err
+		}
+		kid, ok := cursor.Current.Lookup("_id").StringValueOK()
+		if !ok {
+			return errors.New("cannot find jwt key id")
+		}
+		key.ID = kid
+		if err = parseJWTKey(&key); err != nil {
+			logger.Error("cannot read jwt key " + kid + ": " + err.Error())
+			continue
+		}
+		keys = append(keys, &key)
+	}
+	jwtKeyMutex.Lock()
+	defer jwtKeyMutex.Unlock()
+	jwtKeyCache = keys
+	jwtKeysFetched = time.Now()
+	return nil
+}
+
+// getJWTKeys returns the published keys, newest first
+func getJWTKeys() []*JWTKey {
+	jwtKeyMutex.RLock()
+	expired := time.Since(jwtKeysFetched) > time.Duration(jwtKeyCacheTime)*time.Minute
+	jwtKeyMutex.RUnlock()
+	if expired {
+		if err := loadJWTKeys(); err != nil {
+			logger.Error("cannot load jwt keys: " + err.Error())
+		}
+	}
+	jwtKeyMutex.RLock()
+	defer jwtKeyMutex.RUnlock()
+	return jwtKeyCache
+}
+
+// signToken signs the claims with the current key
+func signToken(claims jwt.Claims) (string, error) {
+	if !isAsymmetricJWT() {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	}
+	now := time.Now().Unix()
+	for _, key := range getJWTKeys() {
+		if key.Algorithm != jwtAlgorithm || key.Activates > now || key.Expires <= now {
+			continue
+		}
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+		token.Header["kid"] = key.ID
+		return token.SignedString(key.privateKey)
+	}
+	return "", errors.New("no active jwt signing key")
+}
+
+// getTokenKey returns the key to check the signature of a token with, for jwt.Parse
+func getTokenKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if len(kid) == 0 {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("invalid token signing method")
+		}
+		if isAsymmetricJWT() && time.Now().Unix() >= jwtHS256Until {
+			return nil, errors.New("tokens without a key id are no longer accepted")
+		}
+		return jwtSecret, nil
+	}
+	key := findJWTKey(kid)
+	if key == nil {
+		// another instance could have created the key since the keys were loaded
+		jwtKeyMutex.RLock()
+		canReload := time.Since(jwtKeysFetched) > time.Duration(jwtKeyReloadInterval)*time.Second
+		jwtKeyMutex.RUnlock()
+		if !canReload {
+			return nil, errors.New("unknown token signing key")
+		}
+		if err := loadJWTKeys(); err != nil {
+			return nil, err
+		}
+		if key = findJWTKey(kid); key == nil {
+			return nil, errors.New("unknown token signing key")
+		}
+	}
+	if token.Method.Alg() != key.Algorithm || key.Expires <= time.Now().Unix() {
+		return nil, errors.New("invalid token signing key")
+	}
+	return key.publicKey, nil
+}
+
+func findJWTKey(kid string) *JWTKey {
+	for _, key := range getJWTKeys() {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func getJWK(key *JWTKey) map[string]interface{} {
+	jwk := map[string]interface{}{
+		"kid": key.ID,
+		"alg": key.Algorithm,
+		"use": "sig",
+	}
+	switch publicKey := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+	return jwk
+}
+
+/**
+ * @api {get} /.well-known/jwks.json Public token signing keys
+ * @apiVersion 0.0.1
+ * @apiSuccess {Object[]} keys Json web keys, including keys that will sign soon
+ * @apiGroup authentication
+ */
+func getJWKS(c *gin.Context) {
+	keys := []map[string]interface{}{}
+	for _, key := range getJWTKeys() {
+		keys = append(keys, getJWK(key))
+	}
+	keysJSON, err := json.Marshal(map[string]interface{}{
+		"keys": keys,
+	})
+	if err != nil {
+		handleError("error encoding keys: "+err.Error(), http.StatusBadRequest, c.Writer)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(jwtKeyCacheTime*60))
+	c.Writer.Write(keysJSON)
+}
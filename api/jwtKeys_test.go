@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestJWTSecretFallbackWindow(t *testing.T) {
+	jwtSecret = []byte("test")
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  "user",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatalf("cannot sign token: %s", err)
+	}
+	tests := []struct {
+		name      string
+		algorithm string
+		until     int64
+		valid     bool
+	}{
+		{
+			name:      "accepted while signing with the secret",
+			algorithm: validJWTAlgorithms[0],
+			valid:     true,
+		},
+		{
+			name:      "accepted during the migration window",
+			algorithm: validJWTAlgorithms[1],
+			until:     time.Now().Add(time.Hour).Unix(),
+			valid:     true,
+		},
+		{
+			name:      "rejected after the migration window",
+			algorithm: validJWTAlgorithms[1],
+			until:     time.Now().Add(-time.Hour).Unix(),
+		},
+		{
+			name:      "rejected without a migration window",
+			algorithm: validJWTAlgorithms[2],
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jwtAlgorithm = test.algorithm
+			jwtHS256Until = test.until
+			_, err := jwt.Parse(tokenString, getTokenKey)
+			if test.valid && err != nil {
+				t.Fatalf("expected the token to be accepted: %s", err)
+			} else if !test.valid && err == nil {
+				t.Fatal("expected the token to be rejected")
+			}
+		})
+	}
+	jwtAlgorithm = validJWTAlgorithms[0]
+	jwtHS256Until = 0
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// a link with a password is not opened with the secret directly. the secret and password are
+// exchanged for a link token (linkToken query), which is then used as the access key.
+// uses count the distinct users that opened the link.
+
+// linkAccessKind marks link tokens, which only open one link, so getTokenData rejects them
+var linkAccessKind = "link-access"
+
+type linkAccessClaims struct {
+	ItemID string `json:"itemid"`
+	Secret string `json:"secret"`
+	Kind   string `json:"kind"`
+	jwt.StandardClaims
+}
+
+func getLinkToken(itemIDString string, linkAccess *LinkAccess) (string, error) {
+	expirationTime := time.Now().Add(time.Duration(tokenExpiration) * time.Hour)
+	if linkAccess.Expires > 0 && linkAccess.Expires < expirationTime.Unix() {
+		expirationTime = time.Unix(linkAccess.Expires, 0)
+	}
+	return signToken(linkAccessClaims{
+		itemIDString,
+		linkAccess.Secret,
+		linkAccessKind,
+		jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+			Issuer:    jwtIssuer,
+		},
+	})
+}
+
+// checkLinkKey returns true if the access key opens the link
+func checkLinkKey(itemIDString string, linkAccess *LinkAccess, accessKey string) bool {
+	if !linkAccess.Protected {
+		return len(linkAccess.Secret) > 0 && linkAccess.Secret == accessKey
+	}
+	claims := &linkAccessClaims{}
+	token, err := jwt.ParseWithClaims(accessKey, claims, getTokenKey)
+	if err != nil || !token.Valid || claims.Kind != linkAccessKind {
+		return false
+	}
+	return claims.ItemID == itemIDString && claims.Secret == linkAccess.Secret
+}
+
+// checkLinkAccess returns an error if the link cannot be used by the user, and counts the first use
+// of each user against the max uses
+func checkLinkAccess(collection *mongo.Collection, elasticIndex string, elasticType string, itemID primitive.ObjectID, linkAccess *LinkAccess, accessKey string, claims jwt.MapClaims, necessaryAccess []string) error {
+	if linkAccess == nil || !checkLinkKey(itemID.Hex(), linkAccess, accessKey) {
+		return errors.New("invalid access key")
+	}
+	if !findInArray(linkAccess.Type, necessaryAccess) {
+		return errors.New("you do not have the necessary access")
+	}
+	if linkAccess.Expires > 0 && time.Now().Unix() > linkAccess.Expires {
+		return errors.New("link has expired")
+	}
+	if len(linkAccess.Domain) > 0 {
+		email, _ := claims["email"].(string)
+		if !strings.HasSuffix(strings.ToLower(email), "@"+linkAccess.Domain) {
+			return errors.New("link is only for " + linkAccess.Domain + " emails")
+		}
+	}
+	userIDString, ok := claims["id"].(string)
+	if !ok {
+		return errors.New("cannot cast user id to string")
+	}
+	if findInArray(userIDString, linkAccess.Users) {
+		return nil
+	}
+	filter := bson.M{
+		"_id":               itemID,
+		"linkaccess.secret": linkAccess.Secret,
+		"linkaccess.users": bson.M{
+			"$ne": userIDString,
+		},
+	}
+	if linkAccess.MaxUses > 0 {
+		filter["linkaccess.uses"] = bson.M{
+			"$lt": linkAccess.MaxUses,
+		}
+	}
+	var updatedItem struct {
+		LinkAccess *LinkAccess `bson:"linkaccess"`
+	}
+	err := collection.FindOneAndUpdate(ctxMongo, filter, bson.M{
+		"$addToSet": bson.M{
+			"linkaccess.users": userIDString,
+		},
+		"$inc": bson.M{
+			"linkaccess.uses": 1,
+		},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{
+		"linkaccess.uses": 1,
+	})).Decode(&updatedItem)
+	if err == mongo.ErrNoDocuments {
+		// the user could have been counted by another request in the meantime
+		count, err := collection.CountDocuments(ctxMongo, bson.M{
+			"_id":              itemID,
+			"linkaccess.users": userIDString,
+		})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("link has reached its maximum uses")
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = elasticClient.Update().
+		Index(elasticIndex).
+		Type(elasticType).
+		Id(itemID.Hex()).
+		Doc(bson.M{
+			"linkaccess": bson.M{
+				"uses": updatedItem.LinkAccess.Uses,
+			},
+		}).
+		Do(ctxElastic)
+	return err
+}
+
+// setLinkAccessArgs adds the link access arguments to the database and elastic updates
+func setLinkAccessArgs(params graphql.ResolveParams, linkAccess *LinkAccess, updateDataDB bson.M, updateDataElastic bson.M) error {
+	linkAccessElastic := bson.M{}
+	if params.Args["linkaccess"] != nil {
+		linkaccess, ok := params.Args["linkaccess"].(string)
+		if !ok {
+			return errors.New("problem casting link access to string")
+		}
+		if !findInArray(linkaccess, validAccessTypes) {
+			return errors.New("invalid link access level")
+		}
+		updateDataDB["$set"].(bson.M)["linkaccess.type"] = linkaccess
+		linkAccessElastic["type"] = linkaccess
+		if linkAccess != nil {
+			linkAccess.Type = linkaccess
+		}
+	}
+	if params.Args["linkexpires"] != nil {
+		expires, ok := params.Args["linkexpires"].(int)
+		if !ok {
+			return errors.New("problem casting link expires to int")
+		}
+		if expires < 0 {
+			return errors.New("invalid link expiration")
+		}
+		updateDataDB["$set"].(bson.M)["linkaccess.expires"] = int64(expires)
+		linkAccessElastic["expires"] = int64(expires)
+		if linkAccess != nil {
+			linkAccess.Expires = int64(expires)
+		}
+	}
+	if params.Args["linkmaxuses"] != nil {
+		maxUses, ok := params.Args["linkmaxuses"].(int)
+		if !ok {
+			return errors.New("problem casting link max uses to int")
+		}
+		if maxUses < 0 {
+			return errors.New("invalid link max uses")
+		}
+		updateDataDB["$set"].(bson.M)["linkaccess.maxuses"] = int64(maxUses)
+		linkAccessElastic["maxuses"] = int64(maxUses)
+		if linkAccess != nil {
+			linkAccess.MaxUses = int64(maxUses)
+		}
+	}
+	if params.Args["linkpassword"] != nil {
+		password, ok := params.Args["linkpassword"].(string)
+		if !ok {
+			return errors.New("problem casting link password to string")
+		}
+		var passwordHashed = ""
+		if len(password) > 0 {
+			passwordHashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), numHashes)
+			if err != nil {
+				return err
+			}
+			passwordHashed = string(passwordHashedBytes)
+		}
+		updateDataDB["$set"].(bson.M)["linkaccess.password"] = passwordHashed
+		updateDataDB["$set"].(bson.M)["linkaccess.protected"] = len(password) > 0
+		linkAccessElastic["protected"] = len(password) > 0
+		if linkAccess != nil {
+			linkAccess.Protected = len(password) > 0
+		}
+	}
+	if params.Args["linkdomain"] != nil {
+		domain, ok := params.Args["linkdomain"].(string)
+		if !ok {
+			return errors.New("problem casting link domain to string")
+		}
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+		updateDataDB["$set"].(bson.M)["linkaccess.domain"] = domain
+		linkAccessElastic["domain"] = domain
+		if linkAccess != nil {
+			linkAccess.Domain = domain
+		}
+	}
+	if len(linkAccessElastic) > 0 {
+		updateDataElastic["linkaccess"] = linkAccessElastic
+	}
+	return nil
+}
+
+// getLinkAccessChanges returns the link access arguments for the audit log, without the password
+func getLinkAccessChanges(params graphql.ResolveParams) bson.M {
+	changes := bson.M{}
+	for _, argName := range []string{"linkaccess", "linkexpires", "linkmaxuses", "linkdomain"} {
+		if params.Args[argName] != nil {
+			changes[argName] = params.Args[argName]
+		}
+	}
+	if password, ok := params.Args["linkpassword"].(string); ok {
+		changes["protected"] = len(password) > 0
+	}
+	return changes
+}
+
+var linkAccessQueryFields = graphql.Fields{
+	"linkToken": &graphql.Field{
+		Type:        graphql.String,
+		Description: "Get the access key for a password protected link",
+		Args: graphql.FieldConfigArgument{
+			"type": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "form or project",
+			},
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+			"password": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			if _, err := getTokenData(params.Context.Value(tokenKey).(string)); err != nil {
+				return nil, err
+			}
+			itemType, ok := params.Args["type"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast type to string")
+			}
+			itemIDString, ok := params.Args["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast id to string")
+			}
+			itemID, err := primitive.ObjectIDFromHex(itemIDString)
+			if err != nil {
+				return nil, err
+			}
+			accessKey, ok := params.Args["accessKey"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast access key to string")
+			}
+			password, ok := params.Args["password"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast password to string")
+			}
+			var linkAccess *LinkAccess
+			if itemType == formType {
+				form, err := getForm(itemID, false)
+				if err != nil {
+					return nil, err
+				}
+				linkAccess = form.LinkAccess
+			} else if itemType == projectType {
+				project, err := getProject(itemID, false)
+				if err != nil {
+					return nil, err
+				}
+				linkAccess = project.LinkAccess
+			} else {
+				return nil, errors.New("invalid type given")
+			}
+			if linkAccess == nil || len(linkAccess.Secret) == 0 || linkAccess.Secret != accessKey {
+				return nil, errors.New("invalid access key")
+			}
+			if !linkAccess.Protected {
+				return accessKey, nil
+			}
+			// limit password guesses the same way as logins
+			if err = checkSlidingRateLimit("link-password-ip-"+getParamsIP(params), linkPasswordIPLimit, time.Duration(linkPasswordWindow)*time.Minute); err != nil {
+				return nil, err
+			}
+			if err = checkSlidingRateLimit("link-password-"+itemIDString, linkPasswordLimit, time.Duration(linkPasswordWindow)*time.Minute); err != nil {
+				return nil, err
+			}
+			if err = bcrypt.CompareHashAndPassword([]byte(linkAccess.Password), []byte(password)); err != nil {
+				return nil, errors.New("invalid password")
+			}
+			return getLinkToken(itemIDString, linkAccess)
+		},
+	},
+}
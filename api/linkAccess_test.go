@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestLinkToken(t *testing.T) {
+	jwtAlgorithm = validJWTAlgorithms[0]
+	jwtSecret = []byte("test")
+	linkAccess := &LinkAccess{
+		Secret:    "secret",
+		Protected: true,
+	}
+	linkToken, err := getLinkToken("item", linkAccess)
+	if err != nil {
+		t.Fatalf("cannot create link token: %s", err)
+	}
+	if !checkLinkKey("item", linkAccess, linkToken) {
+		t.Fatal("expected the link token to open the link")
+	}
+	if checkLinkKey("other", linkAccess, linkToken) {
+		t.Fatal("expected a link token for another item to be rejected")
+	}
+	// a link token only opens the link, it cannot be an authorization token
+	if _, err = getTokenData(linkToken); err == nil {
+		t.Fatal("expected the link token to be rejected as an authorization token")
+	}
+	loginToken, err := signToken(jwt.MapClaims{
+		"id":     "user",
+		"itemid": "item",
+		"secret": "secret",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("cannot create login token: %s", err)
+	}
+	if checkLinkKey("item", linkAccess, loginToken) {
+		t.Fatal("expected a token without the link kind to be rejected")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// failed password logins are counted per email in a sliding window. after a few failures every
+// attempt has to wait longer than the previous one, and after more the password login is locked
+// for a while and the owner gets an email. a password reset unlocks the account.
+
+var loginFailurePath = "login-failures-"
+
+var loginDelayPath = "login-delay-"
+
+var loginLockoutPath = "login-lockout-"
+
+var lockoutEmailTemplate = template.Must(template.ParseFiles("templates/lockoutEmail.html"))
+
+// LockoutEmailData lockout email object
+type LockoutEmailData struct {
+	Email     string `json:"email"`
+	IP        string `json:"ip"`
+	Failures  int64  `json:"failures"`
+	Until     string `json:"until"`
+	ResetLink string `json:"resetlink"`
+}
+
+func getLoginProtectionKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginAllowed returns an error if the ip or email made too many attempts, or the email
+// still has to wait after failed logins
+func checkLoginAllowed(email string, ip string) error {
+	if err := checkSlidingRateLimit("login-ip-"+ip, loginIPLimit, time.Duration(loginIPWindow)*time.Minute); err != nil {
+		return err
+	}
+	key := getLoginProtectionKey(email)
+	if err := checkSlidingRateLimit("login-email-"+key, loginEmailLimit, time.Duration(loginEmailWindow)*time.Minute); err != nil {
+		return err
+	}
+	lockoutTime, err := redisClient.TTL(loginLockoutPath + key).Result()
+	if err != nil {
+		return err
+	}
+	if lockoutTime > 0 {
+		return errors.New("account is locked after too many failed logins, try again in " + strconv.Itoa(int(lockoutTime.Minutes())+1) + " minutes or reset your password")
+	}
+	delayTime, err := redisClient.PTTL(loginDelayPath + key).Result()
+	if err != nil {
+		return err
+	}
+	if delayTime > 0 {
+		return errors.New("too many failed logins, try again in " + strconv.Itoa(int(delayTime.Seconds())+1) + " seconds")
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login, and delays or locks further logins
+func recordLoginFailure(email string, ip string) error {
+	key := getLoginProtectionKey(email)
+	failures, err := addSlidingWindowEvent(loginFailurePath+key, time.Duration(loginFailureWindow)*time.Minute)
+	if err != nil {
+		return err
+	}
+	if failures >= loginLockoutThreshold {
+		lockoutDuration := time.Duration(loginLockoutDuration) * time.Minute
+		locked, err := redisClient.SetNX(loginLockoutPath+key, ip, lockoutDuration).Result()
+		if err != nil {
+			return err
+		}
+		if locked {
+			logger.Warn("login lockout",
+				zap.String("email", key),
+				zap.String("ip", ip),
+			)
+			msg := sendLockoutEmailTask.WithArgs(ctxMessageQueue, email, ip, failures, time.Now().Add(lockoutDuration).Unix())
+			if err = messageQueue.Add(msg); err != nil {
+				logger.Error("cannot queue lockout email: " + err.Error())
+			}
+		}
+		return nil
+	}
+	if failures <= loginFreeFailures {
+		return nil
+	}
+	delay := time.Duration(loginDelayBase) * time.Second << uint(failures-loginFreeFailures-1)
+	if maxDelay := time.Duration(loginMaxDelay) * time.Second; delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+	return redisClient.Set(loginDelayPath+key, ip, delay).Err()
+}
+
+// clearLoginFailures resets the failures of the email after a successful login or password reset
+func clearLoginFailures(email string, unlock bool) error {
+	key := getLoginProtectionKey(email)
+	keys := []string{
+		rateLimitPath + loginFailurePath + key,
+		loginDelayPath + key,
+	}
+	if unlock {
+		keys = append(keys, loginLockoutPath+key)
+	}
+	return redisClient.Del(keys...).Err()
+}
+
+func sendLockoutEmail(email string, ip string, failures int64, until int64) error {
+	var account struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Email string             `bson:"email"`
+	}
+	err := userCollection.FindOne(ctxMongo, bson.M{
+		"email": email,
+	}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		// nobody to tell for emails without an account
+		return nil
+	} else if err != nil {
+		return err
+	}
+	recordAudit(auditLockoutAction, "", userType, account.ID.Hex(), account.ID.Hex(), ip, bson.M{
+		"failures": failures,
+		"until":    until,
+	})
+	var templateData bytes.Buffer
+	err = lockoutEmailTemplate.Execute(&templateData, &LockoutEmailData{
+		Email:     account.Email,
+		IP:        ip,
+		Failures:  failures,
+		Until:     time.Unix(until, 0).UTC().Format("January 2, 2006 15:04 MST"),
+		ResetLink: websiteURL + "/reset",
+	})
+	if err != nil {
+		return err
+	}
+	emailContent, err := minifier.String("text/html", templateData.String())
+	if err != nil {
+		return err
+	}
+	_, err = sendEmail(account.Email, "Your account was locked", emailContent)
+	return err
+}
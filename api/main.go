@@ -43,6 +43,10 @@ import (
 
 var jwtSecret []byte
 
+var jwtAlgorithm string
+
+var jwtHS256Until int64
+
 var sendgridAPIKey string
 
 var websiteURL string
@@ -71,6 +75,26 @@ var blogCollection *mongo.Collection
 
 var shortLinkCollection *mongo.Collection
 
+var formHistoryCollection *mongo.Collection
+
+var commentCollection *mongo.Collection
+
+var formUpdateLogCollection *mongo.Collection
+
+var formSaveFailureCollection *mongo.Collection
+
+var orgCollection *mongo.Collection
+
+var groupCollection *mongo.Collection
+
+var auditCollection *mongo.Collection
+
+var apiKeyCollection *mongo.Collection
+
+var sessionCollection *mongo.Collection
+
+var jwtKeyCollection *mongo.Collection
+
 var elasticClient *elastic.Client
 
 var ctxElastic context.Context
@@ -109,6 +133,8 @@ var ctxMessageQueue context.Context
 
 var connections sync.Map
 
+var corsConfig cors.Config
+
 var stripeClient *client.API
 
 var stripeWebhookSecret string
@@ -117,6 +143,8 @@ var minifier *minify.M
 
 var httpClient *fasthttp.Client
 
+var oidcProviders map[string]*OIDCProvider
+
 /**
  * @api {get} /hello Test rest request
  * @apiVersion 0.0.1
@@ -143,7 +171,9 @@ func graphqlMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// before request
 		// set auth
-		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), tokenKey, getAuthToken(c.Request)))
+		ctx := context.WithValue(c.Request.Context(), tokenKey, getAuthToken(c.Request))
+		ctx = context.WithValue(ctx, ipKey, c.ClientIP())
+		c.Request = c.Request.WithContext(ctx)
 		c.Next()
 		// after request
 	}
@@ -242,12 +272,20 @@ func main() {
 		logger.Fatal("Error loading .env file")
 	}
 	jwtSecret = []byte(os.Getenv("SECRET"))
+	jwtAlgorithm = os.Getenv("JWTALGORITHM")
+	if jwtHS256UntilString := os.Getenv("JWTHS256UNTIL"); len(jwtHS256UntilString) > 0 {
+		jwtHS256Until, err = strconv.ParseInt(jwtHS256UntilString, 10, 64)
+		if err != nil {
+			logger.Fatal("invalid JWTHS256UNTIL: " + err.Error())
+		}
+	}
 	sendgridAPIKey = os.Getenv("SENDGRIDAPIKEY")
 	serviceEmail = os.Getenv("SERVICEEMAIL")
 	jwtIssuer = os.Getenv("JWTISSUER")
 	mode = os.Getenv("MODE")
 	websiteURL = os.Getenv("WEBSITEURL")
 	apiURL = os.Getenv("APIURL")
+	initOIDCProviders()
 	ctxMongo, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	cancel()
 	mongouri := os.Getenv("MONGOURI")
@@ -264,6 +302,16 @@ func main() {
 	projectCollection = mongoClient.Database(mainDatabase).Collection(projectMongoName)
 	blogCollection = mongoClient.Database(mainDatabase).Collection(blogMongoName)
 	shortLinkCollection = mongoClient.Database(mainDatabase).Collection(shortLinkMongoName)
+	formHistoryCollection = mongoClient.Database(mainDatabase).Collection(formHistoryMongoName)
+	commentCollection = mongoClient.Database(mainDatabase).Collection(commentMongoName)
+	formUpdateLogCollection = mongoClient.Database(mainDatabase).Collection(formUpdateLogMongoName)
+	formSaveFailureCollection = mongoClient.Database(mainDatabase).Collection(formSaveFailureMongoName)
+	orgCollection = mongoClient.Database(mainDatabase).Collection(orgMongoName)
+	groupCollection = mongoClient.Database(mainDatabase).Collection(groupMongoName)
+	auditCollection = mongoClient.Database(mainDatabase).Collection(auditMongoName)
+	apiKeyCollection = mongoClient.Database(mainDatabase).Collection(apiKeyMongoName)
+	sessionCollection = mongoClient.Database(mainDatabase).Collection(sessionMongoName)
+	jwtKeyCollection = mongoClient.Database(mainDatabase).Collection(jwtKeyMongoName)
 	elasticuri := os.Getenv("ELASTICURI")
 	elasticClient, err = elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(elasticuri))
 	if err != nil {
@@ -351,6 +399,13 @@ func main() {
 	if err = initDefaultPlan(); err != nil {
 		logger.Fatal(err.Error())
 	}
+	if err = initFormEditResponses(); err != nil {
+		logger.Fatal(err.Error())
+	}
+	if err = initJWTKeys(); err != nil {
+		logger.Fatal(err.Error())
+	}
+	go sweepFormUpdates()
 	minifier = minify.New()
 	minifier.AddFunc("text/css", minifyCSS.Minify)
 	minifier.AddFunc("text/html", minifyHTML.Minify)
@@ -373,7 +428,7 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
-	corsConfig := cors.DefaultConfig()
+	corsConfig = cors.DefaultConfig()
 	if isDebug() {
 		corsConfig.AllowAllOrigins = true
 	} else {
@@ -408,8 +463,17 @@ func main() {
 	router.PUT("/loginEmailPassword", loginEmailPassword)
 	router.POST("/register", register)
 	router.POST("/verify", verifyEmail)
+	router.POST("/confirmEmail", confirmEmail)
 	router.PUT("/sendResetEmail", sendPasswordResetEmail)
 	router.POST("/reset", resetPassword)
+	router.PUT("/refreshToken", refreshLoginToken)
+	router.PUT("/loginTOTP", loginTOTP)
+	router.GET("/oidcProviders", getOIDCProviders)
+	router.GET("/oidcAuthorize", oidcAuthorize)
+	router.GET("/oidcCallback", oidcCallback)
+	router.GET("/samlMetadata/:org", samlMetadata)
+	router.GET("/samlLogin", samlLogin)
+	router.POST("/samlACS/:org", samlACS)
 	router.GET("/getFile", getFile)
 	router.PUT("/writeFile", writeFile)
 	router.DELETE("/deleteFiles", deleteFiles)
@@ -420,6 +484,7 @@ func main() {
 	router.GET("/countBlogs", countBlogs)
 	router.Any("/stripehooks", handleStripeHooks)
 	router.Any("/shortLink", shortLinkRedirect)
+	router.GET("/.well-known/jwks.json", getJWKS)
 	router.GET("/hello", hello)
 	router.GET("/", defaultHandler)
 	router.Run()
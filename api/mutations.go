@@ -40,8 +40,10 @@ func getFormattedAccessGQLData(currentAccess interface{}, changedAccess []map[st
 	}
 	newAccessMap := make(map[string]*Access, len(currentAccessMap))
 	for currentUserID, accessUser := range currentAccessMap {
+		isGroup, _ := accessUser["group"].(bool)
 		newAccessMap[currentUserID] = &Access{
-			Type: accessUser["type"].(string),
+			Type:  accessUser["type"].(string),
+			Group: isGroup,
 		}
 	}
 	if changedAccess != nil {
@@ -52,8 +54,10 @@ func getFormattedAccessGQLData(currentAccess interface{}, changedAccess []map[st
 					delete(newAccessMap, currentUserID)
 				}
 			} else {
+				isGroup, _ := accessUser["group"].(bool)
 				newAccessMap[currentUserID] = &Access{
-					Type: accessUser["type"].(string),
+					Type:  accessUser["type"].(string),
+					Group: isGroup,
 				}
 			}
 		}
@@ -62,8 +66,9 @@ func getFormattedAccessGQLData(currentAccess interface{}, changedAccess []map[st
 	var i = 0
 	for id, accessElem := range newAccessMap {
 		newAccess[i] = &Access{
-			ID:   id,
-			Type: accessElem.Type,
+			ID:    id,
+			Type:  accessElem.Type,
+			Group: accessElem.Group,
 		}
 		i++
 	}
@@ -82,6 +87,11 @@ func checkAccessObj(accessObj map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
+	if accessObj["group"] != nil {
+		if _, ok := accessObj["group"].(bool); !ok {
+			return errors.New("cannot cast group to bool")
+		}
+	}
 	if accessObj["type"] != nil {
 		accessType, ok := accessObj["type"].(string)
 		if !ok {
@@ -119,6 +129,11 @@ func changeUserAccessData(itemID primitive.ObjectID, itemType string, userIDStri
 		if err := checkAccessObj(accessUser); err != nil {
 			return nil, err
 		}
+		if isGroup, _ := accessUser["group"].(bool); isGroup {
+			if err := checkGroupShare(accessUser["id"].(string), userIDString); err != nil {
+				return nil, err
+			}
+		}
 	}
 	itemUpdateData := bson.M{
 		"$set": bson.M{
@@ -139,6 +154,9 @@ func changeUserAccessData(itemID primitive.ObjectID, itemType string, userIDStri
 				itemUpdateData["$set"].(bson.M)["access"].(bson.M)[currentUserIDString] = bson.M{
 					"type": accessUser["type"].(string),
 				}
+				if isGroup, _ := accessUser["group"].(bool); isGroup {
+					itemUpdateData["$set"].(bson.M)["access"].(bson.M)[currentUserIDString].(bson.M)["group"] = true
+				}
 				if currentUserIDString != userIDString {
 					itemUpdateData["$setOnInsert"].(bson.M)["access"].(bson.M)[currentUserIDString] = bson.M{
 						"categories": bson.A{},
@@ -181,15 +199,49 @@ func rootMutation() *graphql.Object {
 	for key := range formMutationFields {
 		fields[key] = formMutationFields[key]
 	}
+	for key := range formHistoryMutationFields {
+		fields[key] = formHistoryMutationFields[key]
+	}
+	for key := range commentMutationFields {
+		fields[key] = commentMutationFields[key]
+	}
+	for key := range formSaveMutationFields {
+		fields[key] = formSaveMutationFields[key]
+	}
+	for key := range orgMutationFields {
+		fields[key] = orgMutationFields[key]
+	}
+	for key := range samlMutationFields {
+		fields[key] = samlMutationFields[key]
+	}
+	for key := range groupMutationFields {
+		fields[key] = groupMutationFields[key]
+	}
+	for key := range apiKeyMutationFields {
+		fields[key] = apiKeyMutationFields[key]
+	}
+	for key := range sessionMutationFields {
+		fields[key] = sessionMutationFields[key]
+	}
+	for key := range totpMutationFields {
+		fields[key] = totpMutationFields[key]
+	}
 	for key := range userMutationFields {
 		fields[key] = userMutationFields[key]
 	}
+	for key := range accountEmailMutationFields {
+		fields[key] = accountEmailMutationFields[key]
+	}
+	for key := range accountDataMutationFields {
+		fields[key] = accountDataMutationFields[key]
+	}
 	for key := range projectMutationFields {
 		fields[key] = projectMutationFields[key]
 	}
 	for key := range blogMutationFields {
 		fields[key] = blogMutationFields[key]
 	}
+	addAPIKeyScopeChecks(fields)
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name:   "Mutation",
 		Fields: fields,
@@ -0,0 +1,735 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// sign in with an external openid connect provider (authorization code flow with pkce).
+// providers are set in the environment:
+//   OIDCPROVIDERS=google,microsoft
+//   OIDC<NAME>ISSUER, OIDC<NAME>CLIENTID, OIDC<NAME>CLIENTSECRET, OIDC<NAME>SCOPES (optional)
+// the issuer has to support discovery, so any issuer url works, including a local mock issuer.
+// github does not support openid connect, and needs a bridge issuer like dex.
+// external identities are linked to accounts by the email, only when the provider verified it.
+
+var oidcStatePath = "oidc-state-"
+
+// oidcStateCookie ties the state to the browser that started the login, so a callback url
+// from another login cannot be used to sign someone in to the wrong account
+var oidcStateCookie = "oidc-state"
+
+// OIDCProvider external login provider
+type OIDCProvider struct {
+	Name                 string
+	Issuer               string
+	ClientID             string
+	ClientSecret         string
+	Scopes               string
+	configuration        *oidcConfiguration
+	configurationFetched time.Time
+	keys                 map[string]interface{}
+	keysFetched          time.Time
+	mutex                sync.Mutex
+}
+
+// Identity external login linked to an account
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	Created  int64  `json:"created"`
+}
+
+type oidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+}
+
+var oidcSigningMethods = []string{
+	"RS256",
+	"RS384",
+	"RS512",
+	"ES256",
+	"ES384",
+}
+
+func initOIDCProviders() {
+	oidcProviders = map[string]*OIDCProvider{}
+	for _, name := range strings.Split(os.Getenv("OIDCPROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		envPrefix := "OIDC" + strings.ToUpper(name)
+		provider := &OIDCProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(envPrefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(envPrefix + "CLIENTID"),
+			ClientSecret: os.Getenv(envPrefix + "CLIENTSECRET"),
+			Scopes:       os.Getenv(envPrefix + "SCOPES"),
+		}
+		if len(provider.Issuer) == 0 || len(provider.ClientID) == 0 {
+			logger.Fatal("missing issuer or client id for oidc provider " + name)
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = oidcDefaultScopes
+		}
+		oidcProviders[name] = provider
+	}
+}
+
+func getOIDCRedirectURI() string {
+	return apiURL + "/oidcCallback"
+}
+
+func generateOIDCSecret() (string, error) {
+	secret := make([]byte, oidcSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func fetchOIDCJSON(request *http.Request, result interface{}) error {
+	client := &http.Client{
+		Timeout: time.Duration(oidcRequestTimeout) * time.Second,
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		var errorData struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &errorData) == nil && len(errorData.Error) > 0 {
+			return errors.New("provider error " + errorData.Error + ": " + errorData.ErrorDescription)
+		}
+		return errors.New("provider returned status " + response.Status)
+	}
+	return json.Unmarshal(body, result)
+}
+
+// getOIDCConfiguration returns the discovery document of the provider, cached for a while
+func getOIDCConfiguration(provider *OIDCProvider) (*oidcConfiguration, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.configuration != nil && time.Since(provider.configurationFetched) < time.Duration(oidcCacheTime)*time.Hour {
+		return provider.configuration, nil
+	}
+	request, err := http.NewRequest(http.MethodGet, provider.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var configuration oidcConfiguration
+	if err = fetchOIDCJSON(request, &configuration); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(configuration.Issuer, "/") != provider.Issuer {
+		return nil, errors.New("issuer of provider configuration does not match")
+	}
+	if len(configuration.AuthorizationEndpoint) == 0 || len(configuration.TokenEndpoint) == 0 || len(configuration.JWKSURI) == 0 {
+		return nil, errors.New("provider configuration is missing endpoints")
+	}
+	provider.configuration = &configuration
+	provider.configurationFetched = time.Now()
+	return provider.configuration, nil
+}
+
+func decodeOIDCKeyInt(value string) (*big.Int, error) {
+	valueBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(valueBytes), nil
+}
+
+func parseOIDCKey(key *oidcJWK) (interface{}, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeOIDCKeyInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeOIDCKeyInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{
+			N: n,
+			E: int(e.Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("unsupported curve " + key.Crv)
+		}
+		x, err := decodeOIDCKeyInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeOIDCKeyInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     x,
+			Y:     y,
+		}, nil
+	}
+	return nil, errors.New("unsupported key type " + key.Kty)
+}
+
+// getOIDCKey returns the signing key of the provider with the given id. keys are fetched again
+// when an unknown key id shows up, so rotated keys are picked up
+func getOIDCKey(provider *OIDCProvider, jwksURI string, kid string) (interface{}, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	key, foundKey := provider.keys[kid]
+	if foundKey && time.Since(provider.keysFetched) < time.Duration(oidcCacheTime)*time.Hour {
+		return key, nil
+	}
+	if time.Since(provider.keysFetched) < time.Duration(oidcKeyRefreshInterval)*time.Second {
+		if foundKey {
+			return key, nil
+		}
+		return nil, errors.New("unknown signing key")
+	}
+	request, err := http.NewRequest(http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var keySet struct {
+		Keys []*oidcJWK `json:"keys"`
+	}
+	if err = fetchOIDCJSON(request, &keySet); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range keySet.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		parsedKey, err := parseOIDCKey(jwk)
+		if err != nil {
+			logger.Warn("skipping key of oidc provider "+provider.Name+": "+err.Error(), zap.String("kid", jwk.Kid))
+			continue
+		}
+		keys[jwk.Kid] = parsedKey
+	}
+	provider.keys = keys
+	provider.keysFetched = time.Now()
+	key, foundKey = provider.keys[kid]
+	if !foundKey {
+		return nil, errors.New("unknown signing key")
+	}
+	return key, nil
+}
+
+// exchangeOIDCCode exchanges the authorization code for the id token
+func exchangeOIDCCode(provider *OIDCProvider, configuration *oidcConfiguration, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", getOIDCRedirectURI())
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("code_verifier", verifier)
+	request, err := http.NewRequest(http.MethodPost, configuration.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	var tokenData struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = fetchOIDCJSON(request, &tokenData); err != nil {
+		return "", err
+	}
+	if len(tokenData.IDToken) == 0 {
+		return "", errors.New("provider did not return an id token")
+	}
+	return tokenData.IDToken, nil
+}
+
+func checkOIDCAudience(claims jwt.MapClaims, clientID string) bool {
+	switch audience := claims["aud"].(type) {
+	case string:
+		return audience == clientID
+	case []interface{}:
+		var foundClient = false
+		for _, audienceItem := range audience {
+			if audienceItem == clientID {
+				foundClient = true
+				break
+			}
+		}
+		if !foundClient {
+			return false
+		}
+		if len(audience) > 1 {
+			authorizedParty, _ := claims["azp"].(string)
+			return authorizedParty == clientID
+		}
+		return true
+	}
+	return false
+}
+
+// verifyOIDCIDToken checks the signature and claims of the id token, returning the claims
+func verifyOIDCIDToken(provider *OIDCProvider, configuration *oidcConfiguration, idToken string, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if !findInArray(token.Method.Alg(), oidcSigningMethods) {
+			return nil, errors.New("unsupported signing method " + token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return getOIDCKey(provider, configuration.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid id token")
+	}
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, errors.New("id token has no expiration")
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != provider.Issuer {
+		return nil, errors.New("invalid id token issuer")
+	}
+	if !checkOIDCAudience(claims, provider.ClientID) {
+		return nil, errors.New("id token is not for this client")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("invalid id token nonce")
+	}
+	if subject, _ := claims["sub"].(string); len(subject) == 0 {
+		return nil, errors.New("id token has no subject")
+	}
+	return claims, nil
+}
+
+func getOIDCEmailVerified(claims jwt.MapClaims) bool {
+	switch emailVerified := claims["email_verified"].(type) {
+	case bool:
+		return emailVerified
+	case string:
+		return emailVerified == "true"
+	}
+	return false
+}
+
+// linkOIDCIdentity returns the account of the external identity. the identity is added to the
+// account with the same email, or to a new account
+func linkOIDCIdentity(provider *OIDCProvider, claims jwt.MapClaims, ip string) (primitive.ObjectID, error) {
+	subject := claims["sub"].(string)
+	var linkedAccount struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		Email         string             `bson:"email"`
+		EmailVerified bool               `bson:"emailverified"`
+	}
+	err := userCollection.FindOne(ctxMongo, bson.M{
+		"identities": bson.M{
+			"$elemMatch": bson.M{
+				"provider": provider.Name,
+				"subject":  subject,
+			},
+		},
+	}).Decode(&linkedAccount)
+	if err == nil {
+		if err = checkSSORequired(linkedAccount.Email); err != nil {
+			return primitive.NilObjectID, err
+		}
+		return linkedAccount.ID, nil
+	} else if err != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, err
+	}
+	email, _ := claims["email"].(string)
+	if len(email) == 0 {
+		return primitive.NilObjectID, errors.New("provider did not share an email")
+	}
+	if !getOIDCEmailVerified(claims) {
+		return primitive.NilObjectID, errors.New("email is not verified by the provider")
+	}
+	if err = checkSSORequired(email); err != nil {
+		return primitive.NilObjectID, err
+	}
+	identity := bson.M{
+		"provider": provider.Name,
+		"subject":  subject,
+		"email":    email,
+		"created":  time.Now().Unix(),
+	}
+	err = userCollection.FindOne(ctxMongo, bson.M{
+		"email": email,
+	}).Decode(&linkedAccount)
+	if err == mongo.ErrNoDocuments {
+		userID, err := createAccount(email, "", true)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if _, err = userCollection.UpdateOne(ctxMongo, bson.M{
+			"_id": userID,
+		}, bson.M{
+			"$push": bson.M{
+				"identities": identity,
+			},
+		}); err != nil {
+			return primitive.NilObjectID, err
+		}
+		recordAudit(auditRegisterAction, userID.Hex(), userType, userID.Hex(), userID.Hex(), ip, bson.M{
+			"email":    email,
+			"provider": provider.Name,
+		})
+		logger.Info("User register",
+			zap.String("id", userID.Hex()),
+			zap.String("email", email),
+			zap.String("provider", provider.Name),
+		)
+		return userID, nil
+	} else if err != nil {
+		return primitive.NilObjectID, err
+	}
+	// otherwise whoever registered the unverified email could log in with the password later
+	if !linkedAccount.EmailVerified {
+		return primitive.NilObjectID, errors.New("verify the account email before signing in with " + provider.Name)
+	}
+	res, err := userCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": linkedAccount.ID,
+		"identities.provider": bson.M{
+			"$ne": provider.Name,
+		},
+	}, bson.M{
+		"$push": bson.M{
+			"identities": identity,
+		},
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if res.ModifiedCount == 0 {
+		return primitive.NilObjectID, errors.New("account is already linked to another " + provider.Name + " login")
+	}
+	recordAudit(auditIdentityLinkAction, linkedAccount.ID.Hex(), userType, linkedAccount.ID.Hex(), linkedAccount.ID.Hex(), ip, bson.M{
+		"provider": provider.Name,
+		"email":    email,
+	})
+	return linkedAccount.ID, nil
+}
+
+// getOIDCAuthorizeURL returns the url of the provider login page, which sends the user to /oidcCallback
+func getOIDCAuthorizeURL(provider *OIDCProvider, configuration *oidcConfiguration, state string, nonce string, verifier string) (string, error) {
+	authorizeURL, err := url.Parse(configuration.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := authorizeURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", getOIDCRedirectURI())
+	query.Set("scope", provider.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authorizeURL.RawQuery = query.Encode()
+	return authorizeURL.String(), nil
+}
+
+// isWebsitePath returns true for an empty or relative redirect, so logins cannot send users elsewhere
+func isWebsitePath(redirect string) bool {
+	return len(redirect) == 0 || (strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//") && !strings.Contains(redirect, "\\"))
+}
+
+// redirectExternalLoginResult sends the browser back to the website page of the login flow. the result is
+// in the fragment, so tokens do not end up in server logs
+func redirectExternalLoginResult(c *gin.Context, websitePath string, result url.Values) {
+	c.Redirect(http.StatusFound, websiteURL+websitePath+"#"+result.Encode())
+}
+
+func redirectExternalLoginError(c *gin.Context, websitePath string, message string) {
+	logger.Error("external login failed: " + message)
+	redirectExternalLoginResult(c, websitePath, url.Values{
+		"error": {message},
+	})
+}
+
+// finishExternalLogin creates the session for a user that logged in with an external provider,
+// or sends them to /loginTOTP first
+func finishExternalLogin(c *gin.Context, websitePath string, userID primitive.ObjectID, providerName string, redirect string) {
+	userIDString := userID.Hex()
+	totpData, err := getAccountTOTP(userID)
+	if err != nil {
+		redirectExternalLoginError(c, websitePath, err.Error())
+		return
+	}
+	if totpData.Enabled {
+		totpToken, err := getTOTPLoginToken(userIDString)
+		if err != nil {
+			redirectExternalLoginError(c, websitePath, "error creating totp token: "+err.Error())
+			return
+		}
+		redirectExternalLoginResult(c, websitePath, url.Values{
+			"totpRequired": {"true"},
+			"totpToken":    {totpToken},
+			"redirect":     {redirect},
+		})
+		return
+	}
+	tokenString, refreshToken, err := createSession(userID, getRequestDevice(c.Request), c.ClientIP())
+	if err != nil {
+		redirectExternalLoginError(c, websitePath, "error creating session: "+err.Error())
+		return
+	}
+	recordAudit(auditLoginAction, userIDString, userType, userIDString, userIDString, c.ClientIP(), bson.M{
+		"provider": providerName,
+	})
+	logger.Info("User login",
+		zap.String("id", userIDString),
+		zap.String("provider", providerName),
+	)
+	redirectExternalLoginResult(c, websitePath, url.Values{
+		"token":        {tokenString},
+		"refreshToken": {refreshToken},
+		"redirect":     {redirect},
+	})
+}
+
+/**
+ * @api {get} /oidcProviders Get external login providers
+ * @apiVersion 0.0.1
+ * @apiSuccess {String[]} providers Provider names for /oidcAuthorize
+ * @apiGroup authentication
+ */
+func getOIDCProviders(c *gin.Context) {
+	providers := []string{}
+	for name := range oidcProviders {
+		providers = append(providers, name)
+	}
+	providersJSON, err := json.Marshal(providers)
+	if err != nil {
+		handleError("error encoding providers: "+err.Error(), http.StatusBadRequest, c.Writer)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Write([]byte(`{"providers":` + string(providersJSON) + `}`))
+}
+
+func getOIDCStateHash(state string) string {
+	hash := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   !isDebug(),
+		HttpOnly: true,
+		// lax so the cookie is sent on the redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+/**
+ * @api {get} /oidcAuthorize Start login with an external provider
+ * @apiVersion 0.0.1
+ * @apiParam {String} provider Provider name
+ * @apiParam {String} redirect Website path to go to after login
+ * @apiSuccess (302) Redirect to the provider
+ * @apiGroup authentication
+ */
+func oidcAuthorize(c *gin.Context) {
+	response := c.Writer
+	provider, ok := oidcProviders[c.Query("provider")]
+	if !ok {
+		handleError("invalid provider", http.StatusBadRequest, response)
+		return
+	}
+	redirect := c.Query("redirect")
+	if !isWebsitePath(redirect) {
+		handleError("redirect has to be a website path", http.StatusBadRequest, response)
+		return
+	}
+	configuration, err := getOIDCConfiguration(provider)
+	if err != nil {
+		handleError("error getting provider configuration: "+err.Error(), http.StatusBadGateway, response)
+		return
+	}
+	state, err := generateOIDCSecret()
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	nonce, err := generateOIDCSecret()
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	verifier, err := generateOIDCSecret()
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	stateData, err := json.Marshal(oidcState{
+		Provider: provider.Name,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Redirect: redirect,
+	})
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	err = redisClient.Set(oidcStatePath+state, string(stateData), time.Duration(oidcStateExpiration)*time.Minute).Err()
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	authorizeURL, err := getOIDCAuthorizeURL(provider, configuration, state, nonce, verifier)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadGateway, response)
+		return
+	}
+	setOIDCStateCookie(c, getOIDCStateHash(state), oidcStateExpiration*60)
+	c.Redirect(http.StatusFound, authorizeURL)
+}
+
+/**
+ * @api {get} /oidcCallback Finish login with an external provider
+ * @apiVersion 0.0.1
+ * @apiParam {String} state State from /oidcAuthorize, which has to match the cookie it set
+ * @apiParam {String} code Authorization code from the provider
+ * @apiSuccess (302) Redirect to the website /login/oidc, with token, refreshToken and redirect, or
+ * totpRequired and totpToken for /loginTOTP, or error in the fragment
+ * @apiGroup authentication
+ */
+func oidcCallback(c *gin.Context) {
+	state := c.Query("state")
+	if len(state) == 0 {
+		redirectExternalLoginError(c, oidcWebsitePath, "no state provided")
+		return
+	}
+	// check the browser before using up the state, so a forged callback cannot cancel the real login
+	stateCookie, err := c.Request.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(getOIDCStateHash(state))) != 1 {
+		redirectExternalLoginError(c, oidcWebsitePath, "login was not started in this browser")
+		return
+	}
+	setOIDCStateCookie(c, "", -1)
+	stateString, err := redisClient.Get(oidcStatePath + state).Result()
+	if err != nil {
+		redirectExternalLoginError(c, oidcWebsitePath, "login attempt expired")
+		return
+	}
+	// the state can only be used once
+	deleted, err := redisClient.Del(oidcStatePath + state).Result()
+	if err != nil || deleted == 0 {
+		redirectExternalLoginError(c, oidcWebsitePath, "login attempt expired")
+		return
+	}
+	var stateData oidcState
+	if err = json.Unmarshal([]byte(stateString), &stateData); err != nil {
+		redirectExternalLoginError(c, oidcWebsitePath, "error parsing login state: "+err.Error())
+		return
+	}
+	if providerError := c.Query("error"); len(providerError) > 0 {
+		redirectExternalLoginError(c, oidcWebsitePath, "provider error "+providerError+": "+c.Query("error_description"))
+		return
+	}
+	code := c.Query("code")
+	if len(code) == 0 {
+		redirectExternalLoginError(c, oidcWebsitePath, "no code provided")
+		return
+	}
+	provider, ok := oidcProviders[stateData.Provider]
+	if !ok {
+		redirectExternalLoginError(c, oidcWebsitePath, "invalid provider")
+		return
+	}
+	configuration, err := getOIDCConfiguration(provider)
+	if err != nil {
+		redirectExternalLoginError(c, oidcWebsitePath, "error getting provider configuration: "+err.Error())
+		return
+	}
+	idToken, err := exchangeOIDCCode(provider, configuration, code, stateData.Verifier)
+	if err != nil {
+		redirectExternalLoginError(c, oidcWebsitePath, "error exchanging code: "+err.Error())
+		return
+	}
+	claims, err := verifyOIDCIDToken(provider, configuration, idToken, stateData.Nonce)
+	if err != nil {
+		redirectExternalLoginError(c, oidcWebsitePath, err.Error())
+		return
+	}
+	userID, err := linkOIDCIdentity(provider, claims, c.ClientIP())
+	if err != nil {
+		redirectExternalLoginError(c, oidcWebsitePath, err.Error())
+		return
+	}
+	finishExternalLogin(c, oidcWebsitePath, userID, provider.Name, stateData.Redirect)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+var mockOIDCClientID = "client"
+
+var mockOIDCClientSecret = "secret"
+
+var mockOIDCKeyID = "mock-key"
+
+// mockOIDCCode authorization request the mock issuer gave a code for
+type mockOIDCCode struct {
+	RedirectURI string
+	Nonce       string
+	Challenge   string
+}
+
+// mockOIDCIssuer openid connect provider with discovery, jwks, authorize and token endpoints
+type mockOIDCIssuer struct {
+	Server        *httptest.Server
+	Key           *rsa.PrivateKey
+	Subject       string
+	Email         string
+	EmailVerified bool
+	codes         map[string]*mockOIDCCode
+	mutex         sync.Mutex
+}
+
+func writeMockOIDCJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeMockOIDCError(w http.ResponseWriter, oidcError string, description string) {
+	writeMockOIDCJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             oidcError,
+		"error_description": description,
+	})
+}
+
+func newMockOIDCIssuer(t *testing.T) *mockOIDCIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	issuer := &mockOIDCIssuer{
+		Key:           key,
+		Subject:       "subject",
+		Email:         "user@example.com",
+		EmailVerified: true,
+		codes:         map[string]*mockOIDCCode{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeMockOIDCJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 issuer.Server.URL,
+			"authorization_endpoint": issuer.Server.URL + "/authorize",
+			"token_endpoint":         issuer.Server.URL + "/token",
+			"jwks_uri":               issuer.Server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeMockOIDCJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kid": mockOIDCKeyID,
+					"kty": "RSA",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/authorize", issuer.authorize)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+// authorize logs the user in right away and sends them back with a code
+func (issuer *mockOIDCIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != mockOIDCClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid client or response type", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) == 0 {
+		http.Error(w, "pkce is required", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(" "+query.Get("scope")+" ", " openid ") {
+		http.Error(w, "openid scope is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := primitive.NewObjectID().Hex()
+	issuer.mutex.Lock()
+	issuer.codes[code] = &mockOIDCCode{
+		RedirectURI: redirectURI.String(),
+		Nonce:       query.Get("nonce"),
+		Challenge:   query.Get("code_challenge"),
+	}
+	issuer.mutex.Unlock()
+	redirectQuery := redirectURI.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectURI.RawQuery = redirectQuery.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges a code once for an id token, checking the client and the pkce verifier
+func (issuer *mockOIDCIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "token method not POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeMockOIDCError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeMockOIDCError(w, "unsupported_grant_type", "")
+		return
+	}
+	if r.PostForm.Get("client_id") != mockOIDCClientID || r.PostForm.Get("client_secret") != mockOIDCClientSecret {
+		writeMockOIDCError(w, "invalid_client", "")
+		return
+	}
+	issuer.mutex.Lock()
+	code, ok := issuer.codes[r.PostForm.Get("code")]
+	delete(issuer.codes, r.PostForm.Get("code"))
+	issuer.mutex.Unlock()
+	if !ok {
+		writeMockOIDCError(w, "invalid_grant", "unknown code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.RedirectURI {
+		writeMockOIDCError(w, "invalid_grant", "redirect uri does not match")
+		return
+	}
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != code.Challenge {
+		writeMockOIDCError(w, "invalid_grant", "code verifier does not match")
+		return
+	}
+	idToken, err := issuer.signIDToken(code.Nonce)
+	if err != nil {
+		writeMockOIDCError(w, "server_error", err.Error())
+		return
+	}
+	writeMockOIDCJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (issuer *mockOIDCIssuer) signIDToken(nonce string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            issuer.Server.URL,
+		"aud":            mockOIDCClientID,
+		"sub":            issuer.Subject,
+		"email":          issuer.Email,
+		"email_verified": issuer.EmailVerified,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = mockOIDCKeyID
+	return token.SignedString(issuer.Key)
+}
+
+func (issuer *mockOIDCIssuer) getProvider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:         "mock",
+		Issuer:       issuer.Server.URL,
+		ClientID:     mockOIDCClientID,
+		ClientSecret: mockOIDCClientSecret,
+		Scopes:       oidcDefaultScopes,
+	}
+}
+
+// noRedirectClient returns redirects instead of following them, so each step can be checked
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// followMockOIDCAuthorize sends the browser to the provider, returning where it redirects back to
+func followMockOIDCAuthorize(t *testing.T, authorizeURL string) *url.URL {
+	t.Helper()
+	response, err := noRedirectClient.Get(authorizeURL)
+	if err != nil {
+		t.Fatalf("cannot get authorize url: %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned status %s", response.Status)
+	}
+	callbackURL, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("cannot parse callback url: %s", err)
+	}
+	return callbackURL
+}
+
+func TestOIDCProviderCodeExchange(t *testing.T) {
+	logger = zap.NewNop()
+	apiURL = "https://api.example.com"
+	issuer := newMockOIDCIssuer(t)
+	defer issuer.Server.Close()
+	provider := issuer.getProvider()
+	configuration, err := getOIDCConfiguration(provider)
+	if err != nil {
+		t.Fatalf("cannot get configuration: %s", err)
+	}
+	login := func(nonce string, verifier string) string {
+		authorizeURL, err := getOIDCAuthorizeURL(provider, configuration, "state", nonce, verifier)
+		if err != nil {
+			t.Fatalf("cannot get authorize url: %s", err)
+		}
+		callbackURL := followMockOIDCAuthorize(t, authorizeURL)
+		if !strings.HasPrefix(callbackURL.String(), getOIDCRedirectURI()+"?") {
+			t.Fatalf("provider redirected to %s", callbackURL)
+		}
+		if callbackURL.Query().Get("state") != "state" {
+			t.Fatalf("provider returned state %q", callbackURL.Query().Get("state"))
+		}
+		return callbackURL.Query().Get("code")
+	}
+
+	code := login("nonce", "verifier")
+	idToken, err := exchangeOIDCCode(provider, configuration, code, "verifier")
+	if err != nil {
+		t.Fatalf("cannot exchange code: %s", err)
+	}
+	claims, err := verifyOIDCIDToken(provider, configuration, idToken, "nonce")
+	if err != nil {
+		t.Fatalf("cannot verify id token: %s", err)
+	}
+	if claims["sub"] != issuer.Subject || claims["email"] != issuer.Email || !getOIDCEmailVerified(claims) {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+	if _, err = exchangeOIDCCode(provider, configuration, code, "verifier"); err == nil {
+		t.Fatal("expected a used code to be rejected")
+	}
+	if _, err = verifyOIDCIDToken(provider, configuration, idToken, "other"); err == nil {
+		t.Fatal("expected an id token with another nonce to be rejected")
+	}
+
+	code = login("nonce", "verifier")
+	if _, err = exchangeOIDCCode(provider, configuration, code, "other"); err == nil {
+		t.Fatal("expected a wrong code verifier to be rejected")
+	}
+
+	otherProvider := issuer.getProvider()
+	otherProvider.ClientID = "other"
+	if _, err = verifyOIDCIDToken(otherProvider, configuration, idToken, "nonce"); err == nil {
+		t.Fatal("expected an id token for another client to be rejected")
+	}
+}
+
+// TestOIDCLoginEndToEnd logs in through /oidcAuthorize and /oidcCallback with the mock issuer.
+// it needs a mongodb given by MONGOTESTURI and a redis given by REDISTESTADDR
+func TestOIDCLoginEndToEnd(t *testing.T) {
+	mongouri := os.Getenv("MONGOTESTURI")
+	redisaddr := os.Getenv("REDISTESTADDR")
+	if len(mongouri) == 0 || len(redisaddr) == 0 {
+		t.Skip("MONGOTESTURI or REDISTESTADDR not set")
+	}
+	logger = zap.NewNop()
+	ctxMongo = context.Background()
+	connectCtx, cancel := context.WithTimeout(ctxMongo, 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(mongouri))
+	if err != nil {
+		t.Fatalf("cannot connect to mongodb: %s", err)
+	}
+	defer client.Disconnect(ctxMongo)
+	database := client.Database("test-" + primitive.NewObjectID().Hex())
+	defer database.Drop(ctxMongo)
+	userCollection = database.Collection(userMongoName)
+	orgCollection = database.Collection(orgMongoName)
+	auditCollection = database.Collection(auditMongoName)
+	sessionCollection = database.Collection(sessionMongoName)
+	redisClient = redis.NewClient(&redis.Options{
+		Addr: redisaddr,
+	})
+	defer redisClient.Close()
+	jwtAlgorithm = validJWTAlgorithms[0]
+	jwtSecret = []byte("test")
+	apiURL = "https://api.example.com"
+	websiteURL = "https://example.com"
+
+	issuer := newMockOIDCIssuer(t)
+	defer issuer.Server.Close()
+	oidcProviders = map[string]*OIDCProvider{
+		"mock": issuer.getProvider(),
+	}
+	// an existing account with the verified email gets the identity linked, so no account is created
+	userID := primitive.NewObjectID()
+	_, err = userCollection.InsertOne(ctxMongo, bson.M{
+		"_id":           userID,
+		"email":         issuer.Email,
+		"emailverified": true,
+		"type":          userType,
+	})
+	if err != nil {
+		t.Fatalf("cannot insert account: %s", err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/oidcAuthorize", oidcAuthorize)
+	router.GET("/oidcCallback", oidcCallback)
+	var cookies []*http.Cookie
+	serve := func(requestURL string) *url.URL {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, requestURL, nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusFound {
+			t.Fatalf("%s returned status %d: %s", requestURL, recorder.Code, recorder.Body.String())
+		}
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("cannot parse redirect: %s", err)
+		}
+		if setCookies := recorder.Result().Cookies(); len(setCookies) > 0 {
+			cookies = setCookies
+		}
+		return location
+	}
+	getResult := func(location *url.URL) url.Values {
+		if location.Scheme+"://"+location.Host+location.Path != websiteURL+oidcWebsitePath {
+			t.Fatalf("callback redirected to %s", location)
+		}
+		result, err := url.ParseQuery(location.Fragment)
+		if err != nil {
+			t.Fatalf("cannot parse login result: %s", err)
+		}
+		return result
+	}
+
+	authorizeURL := serve("/oidcAuthorize?provider=mock&redirect=" + url.QueryEscape("/forms"))
+	if !strings.HasPrefix(authorizeURL.String(), issuer.Server.URL+"/authorize?") {
+		t.Fatalf("authorize redirected to %s", authorizeURL)
+	}
+	callbackURL := followMockOIDCAuthorize(t, authorizeURL.String())
+	callbackPath := "/oidcCallback?" + callbackURL.RawQuery
+	// a callback from a browser that did not start the login is rejected, without using up the state
+	stateCookies := cookies
+	cookies = nil
+	if result := getResult(serve(callbackPath)); result.Get("error") != "login was not started in this browser" {
+		t.Fatalf("expected the callback without the state cookie to be rejected, got %v", result)
+	}
+	cookies = stateCookies
+	result := getResult(serve(callbackPath))
+	if len(result.Get("error")) > 0 {
+		t.Fatalf("login failed: %s", result.Get("error"))
+	}
+	if result.Get("redirect") != "/forms" || len(result.Get("refreshToken")) == 0 {
+		t.Fatalf("unexpected login result %v", result)
+	}
+	claims, err := getTokenData(result.Get("token"))
+	if err != nil {
+		t.Fatalf("cannot read login token: %s", err)
+	}
+	if claims["id"] != userID.Hex() {
+		t.Fatalf("logged in as %v instead of %s", claims["id"], userID.Hex())
+	}
+	count, err := userCollection.CountDocuments(ctxMongo, bson.M{
+		"_id":                 userID,
+		"identities.provider": "mock",
+		"identities.subject":  issuer.Subject,
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("identity was not linked: %d %v", count, err)
+	}
+
+	// the state can only be used once
+	cookies = stateCookies
+	if result = getResult(serve(callbackPath)); result.Get("error") != "login attempt expired" {
+		t.Fatalf("expected the used state to be rejected, got %v", result)
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/graphql-go/graphql"
+	"github.com/olivere/elastic/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Org organization owning projects and forms together with its members
+type Org struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Owner   string       `json:"owner"`
+	Plan    string       `json:"plan"`
+	Members []*OrgMember `json:"members"`
+	Storage int64        `json:"storage"`
+	SSO     *OrgSSO      `json:"sso"`
+	Created int64        `json:"created"`
+	Updated int64        `json:"updated"`
+}
+
+// OrgMember member of an organization
+type OrgMember struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+}
+
+// OrgMemberType graphql organization member object
+var OrgMemberType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "OrgMember",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"role": &graphql.Field{
+			Type:        graphql.String,
+			Description: "owner, admin, member or viewer",
+		},
+	},
+})
+
+// OrgType graphql organization object
+var OrgType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Org",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"name": &graphql.Field{
+			Type: graphql.String,
+		},
+		"owner": &graphql.Field{
+			Type: graphql.String,
+		},
+		"plan": &graphql.Field{
+			Type:        graphql.String,
+			Description: "product used for the limits, the owner's plan when empty",
+		},
+		"members": &graphql.Field{
+			Type: graphql.NewList(OrgMemberType),
+		},
+		"storage": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "storage used by all projects and forms of the organization",
+		},
+		"sso": &graphql.Field{
+			Type: OrgSSOType,
+		},
+		"ssoentityid": &graphql.Field{
+			Type:        graphql.String,
+			Description: "saml entity id and metadata url for the identity provider",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				org, ok := params.Source.(*Org)
+				if !ok {
+					return nil, errors.New("cannot cast source to organization")
+				}
+				return getSAMLEntityID(org.ID), nil
+			},
+		},
+		"ssoacsurl": &graphql.Field{
+			Type:        graphql.String,
+			Description: "saml assertion consumer service url for the identity provider",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				org, ok := params.Source.(*Org)
+				if !ok {
+					return nil, errors.New("cannot cast source to organization")
+				}
+				return getSAMLACSURL(org.ID), nil
+			},
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"updated": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+func getOrg(orgID primitive.ObjectID, updated bool) (*Org, error) {
+	var org Org
+	err := orgCollection.FindOne(ctxMongo, bson.M{
+		"_id": orgID,
+	}).Decode(&org)
+	if err != nil {
+		return nil, err
+	}
+	org.Created = objectidTimestamp(orgID).Unix()
+	if updated {
+		org.Updated = time.Now().Unix()
+	}
+	org.ID = orgID.Hex()
+	return &org, nil
+}
+
+func getOrgMemberRole(org *Org, userIDString string) string {
+	for _, member := range org.Members {
+		if member.ID == userIDString {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// checkOrgAccess returns the organization if the user has one of the given roles in it
+func checkOrgAccess(orgIDString string, userIDString string, roles []string) (*Org, error) {
+	orgID, err := primitive.ObjectIDFromHex(orgIDString)
+	if err != nil {
+		return nil, err
+	}
+	org, err := getOrg(orgID, false)
+	if err != nil {
+		return nil, err
+	}
+	if !findInArray(getOrgMemberRole(org, userIDString), roles) {
+		return nil, errors.New("you do not have the necessary role in the organization")
+	}
+	return org, nil
+}
+
+// getOrgAccessType returns the access the user has to everything the organization owns
+func getOrgAccessType(orgIDString string, userIDString string) (string, error) {
+	org, err := checkOrgAccess(orgIDString, userIDString, validOrgRoles)
+	if err != nil {
+		return "", err
+	}
+	if findInArray(getOrgMemberRole(org, userIDString), orgEditRoles) {
+		return editAccessLevel[0], nil
+	}
+	return validAccessTypes[1], nil
+}
+
+func getOrgProduct(org *Org) (*Product, error) {
+	if len(org.Plan) > 0 {
+		productID, err := primitive.ObjectIDFromHex(org.Plan)
+		if err != nil {
+			return nil, err
+		}
+		return getProduct(productID, !isDebug())
+	}
+	ownerID, err := primitive.ObjectIDFromHex(org.Owner)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := getAccount(ownerID, false)
+	if err != nil {
+		return nil, err
+	}
+	return getProductFromUserData(owner)
+}
+
+// checkUsageLimit returns an error if the user, or the organization when one is given, cannot
+// make more projects or forms under its plan. usage in an organization is pooled
+func checkUsageLimit(claims jwt.MapClaims, orgIDString string, elasticType string) error {
+	userIDString, ok := claims["id"].(string)
+	if !ok {
+		return errors.New("cannot cast user id to string")
+	}
+	var productData *Product
+	query := elastic.NewBoolQuery()
+	if len(orgIDString) > 0 {
+		org, err := checkOrgAccess(orgIDString, userIDString, orgEditRoles)
+		if err != nil {
+			return err
+		}
+		productData, err = getOrgProduct(org)
+		if err != nil {
+			return err
+		}
+		query = query.Must(elastic.NewTermQuery("org", orgIDString))
+	} else {
+		planIDString, ok := claims["plan"].(string)
+		if !ok {
+			return errors.New("cannot convert plan to string")
+		}
+		var planID = primitive.NilObjectID
+		if len(planIDString) > 0 {
+			var err error
+			planID, err = primitive.ObjectIDFromHex(planIDString)
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		productData, err = getProduct(planID, !isDebug())
+		if err != nil {
+			return err
+		}
+		query = query.Must(elastic.NewTermsQuery("owner", userIDString)).
+			MustNot(elastic.NewExistsQuery("org"))
+	}
+	count, err := elasticClient.Count().
+		Type(elasticType).
+		Query(query).
+		Pretty(false).
+		Do(ctxElastic)
+	if err != nil {
+		return err
+	}
+	if elasticType == projectElasticType && count >= productData.MaxProjects {
+		return errors.New("you reached the maximum amount of projects")
+	}
+	if elasticType == formElasticType && count >= productData.MaxForms {
+		return errors.New("you reached the maximum amount of forms")
+	}
+	return nil
+}
+
+// checkStorageLimit returns an error if the owner, or the organization when one is given,
+// does not have the given amount of storage left
+func checkStorageLimit(ownerID primitive.ObjectID, orgIDString string, size int64) error {
+	var productData *Product
+	var used int64
+	if len(orgIDString) > 0 {
+		orgID, err := primitive.ObjectIDFromHex(orgIDString)
+		if err != nil {
+			return err
+		}
+		org, err := getOrg(orgID, false)
+		if err != nil {
+			return err
+		}
+		productData, err = getOrgProduct(org)
+		if err != nil {
+			return err
+		}
+		used = org.Storage
+	} else {
+		account, err := getAccount(ownerID, false)
+		if err != nil {
+			return err
+		}
+		productData, err = getProductFromUserData(account)
+		if err != nil {
+			return err
+		}
+		used = account.Storage
+	}
+	if size > productData.MaxStorage-used {
+		return errors.New("not enough storage remaining")
+	}
+	return nil
+}
+
+// changeOwnerStorage counts storage against the organization when one is given, otherwise the owner
+func changeOwnerStorage(ownerID primitive.ObjectID, orgIDString string, amountChange int64) error {
+	if len(orgIDString) == 0 {
+		return changeUserStorage(ownerID, amountChange)
+	}
+	orgID, err := primitive.ObjectIDFromHex(orgIDString)
+	if err != nil {
+		return err
+	}
+	_, err = orgCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": orgID,
+	}, bson.M{
+		"$inc": bson.M{
+			"storage": amountChange,
+		},
+	})
+	return err
+}
+
+func getOrgArgs(params graphql.ResolveParams) (string, string, error) {
+	claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+	if err != nil {
+		return "", "", err
+	}
+	userIDString, ok := claims["id"].(string)
+	if !ok {
+		return "", "", errors.New("cannot cast user id to string")
+	}
+	if params.Args["id"] == nil {
+		return "", "", errors.New("organization id not provided")
+	}
+	orgIDString, ok := params.Args["id"].(string)
+	if !ok {
+		return "", "", errors.New("cannot cast organization id to string")
+	}
+	return orgIDString, userIDString, nil
+}
+
+func setOrgMembers(org *Org) error {
+	orgID, err := primitive.ObjectIDFromHex(org.ID)
+	if err != nil {
+		return err
+	}
+	org.Updated = time.Now().Unix()
+	_, err = orgCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": orgID,
+	}, bson.M{
+		"$set": bson.M{
+			"owner":   org.Owner,
+			"members": org.Members,
+			"updated": org.Updated,
+		},
+	})
+	return err
+}
+
+var orgQueryFields = graphql.Fields{
+	"org": &graphql.Field{
+		Type:        OrgType,
+		Description: "Get an organization you are a member of",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			orgIDString, userIDString, err := getOrgArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			return checkOrgAccess(orgIDString, userIDString, validOrgRoles)
+		},
+	},
+	"orgs": &graphql.Field{
+		Type:        graphql.NewList(OrgType),
+		Description: "Get the organizations you are a member of",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			cursor, err := orgCollection.Find(ctxMongo, bson.M{
+				"members.id": userIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			orgs := []*Org{}
+			for cursor.Next(ctxMongo) {
+				var org Org
+				if err = cursor.Decode(&org); err != nil {
+					return nil, err
+				}
+				orgID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find organization id")
+				}
+				org.ID = orgID.Hex()
+				org.Created = objectidTimestamp(orgID).Unix()
+				orgs = append(orgs, &org)
+			}
+			return orgs, nil
+		},
+	},
+}
+
+var orgMutationFields = graphql.Fields{
+	"addOrg": &graphql.Field{
+		Type:        OrgType,
+		Description: "Create an organization",
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			if params.Args["name"] == nil {
+				return nil, errors.New("name not provided")
+			}
+			name, ok := params.Args["name"].(string)
+			if !ok {
+				return nil, errors.New("problem casting name to string")
+			}
+			orgID := primitive.NewObjectID()
+			org := &Org{
+				ID:    orgID.Hex(),
+				Name:  name,
+				Owner: userIDString,
+				Members: []*OrgMember{{
+					ID:   userIDString,
+					Role: orgOwnerRole,
+				}},
+				Created: objectidTimestamp(orgID).Unix(),
+				Updated: time.Now().Unix(),
+			}
+			_, err = orgCollection.InsertOne(ctxMongo, bson.M{
+				"_id":     orgID,
+				"name":    org.Name,
+				"owner":   org.Owner,
+				"plan":    org.Plan,
+				"members": org.Members,
+				"storage": int64(0),
+				"updated": org.Updated,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+	"updateOrg": &graphql.Field{
+		Type:        OrgType,
+		Description: "Update an organization",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"plan": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "product id, admin only",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			orgIDString, userIDString, err := getOrgArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			org, err := checkOrgAccess(orgIDString, userIDString, orgManageRoles)
+			if err != nil {
+				return nil, err
+			}
+			updateData := bson.M{}
+			if params.Args["name"] != nil {
+				name, ok := params.Args["name"].(string)
+				if !ok {
+					return nil, errors.New("problem casting name to string")
+				}
+				org.Name = name
+				updateData["name"] = name
+			}
+			if params.Args["plan"] != nil {
+				if _, err := validateAdmin(params.Context.Value(tokenKey).(string)); err != nil {
+					return nil, errors.New("only admins can change the plan of an organization")
+				}
+				plan, ok := params.Args["plan"].(string)
+				if !ok {
+					return nil, errors.New("problem casting plan to string")
+				}
+				if len(plan) > 0 {
+					productID, err := primitive.ObjectIDFromHex(plan)
+					if err != nil {
+						return nil, err
+					}
+					if _, err = getProduct(productID, false); err != nil {
+						return nil, err
+					}
+				}
+				org.Plan = plan
+				updateData["plan"] = plan
+			}
+			org.Updated = time.Now().Unix()
+			updateData["updated"] = org.Updated
+			orgID, err := primitive.ObjectIDFromHex(orgIDString)
+			if err != nil {
+				return nil, err
+			}
+			_, err = orgCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": orgID,
+			}, bson.M{
+				"$set": updateData,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if params.Args["plan"] != nil {
+				recordAudit(auditPlanChangeAction, userIDString, orgType, orgIDString, org.Owner, getParamsIP(params), bson.M{
+					"plan": org.Plan,
+				})
+			}
+			return org, nil
+		},
+	},
+	"deleteOrg": &graphql.Field{
+		Type:        OrgType,
+		Description: "Delete an organization without projects",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			orgIDString, userIDString, err := getOrgArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			org, err := checkOrgAccess(orgIDString, userIDString, []string{orgOwnerRole})
+			if err != nil {
+				return nil, err
+			}
+			numProjects, err := projectCollection.CountDocuments(ctxMongo, bson.M{
+				"org": orgIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if numProjects > 0 {
+				return nil, errors.New("delete the projects of the organization first")
+			}
+			orgID, err := primitive.ObjectIDFromHex(orgIDString)
+			if err != nil {
+				return nil, err
+			}
+			_, err = orgCollection.DeleteOne(ctxMongo, bson.M{
+				"_id": orgID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+	"setOrgMember": &graphql.Field{
+		Type:        OrgType,
+		Description: "Add a member to an organization or change their role",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"member": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "user id",
+			},
+			"role": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			orgIDString, userIDString, err := getOrgArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			org, err := checkOrgAccess(orgIDString, userIDString, orgManageRoles)
+			if err != nil {
+				return nil, err
+			}
+			if params.Args["member"] == nil {
+				return nil, errors.New("member not provided")
+			}
+			memberIDString, ok := params.Args["member"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast member to string")
+			}
+			memberID, err := primitive.ObjectIDFromHex(memberIDString)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = getAccount(memberID, false); err != nil {
+				return nil, errors.New("cannot find user")
+			}
+			if params.Args["role"] == nil {
+				return nil, errors.New("role not provided")
+			}
+			role, ok := params.Args["role"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast role to string")
+			}
+			if !findInArray(role, validOrgRoles) {
+				return nil, errors.New("invalid role given")
+			}
+			currentRole := getOrgMemberRole(org, memberIDString)
+			if role == orgOwnerRole || currentRole == orgOwnerRole {
+				// ownership can only be handed over by the owner
+				if org.Owner != userIDString {
+					return nil, errors.New("only the owner can change who owns the organization")
+				}
+				if memberIDString == userIDString {
+					return nil, errors.New("make another member the owner instead")
+				}
+			}
+			if role == orgOwnerRole {
+				for _, member := range org.Members {
+					if member.ID == org.Owner {
+						member.Role = orgManageRoles[1]
+					}
+				}
+				org.Owner = memberIDString
+			}
+			if len(currentRole) > 0 {
+				for _, member := range org.Members {
+					if member.ID == memberIDString {
+						member.Role = role
+					}
+				}
+			} else {
+				org.Members = append(org.Members, &OrgMember{
+					ID:   memberIDString,
+					Role: role,
+				})
+			}
+			if err = setOrgMembers(org); err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+	"removeOrgMember": &graphql.Field{
+		Type:        OrgType,
+		Description: "Remove a member from an organization, or leave it",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"member": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "user id",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			orgIDString, userIDString, err := getOrgArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if params.Args["member"] == nil {
+				return nil, errors.New("member not provided")
+			}
+			memberIDString, ok := params.Args["member"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast member to string")
+			}
+			roles := orgManageRoles
+			if memberIDString == userIDString {
+				roles = validOrgRoles
+			}
+			org, err := checkOrgAccess(orgIDString, userIDString, roles)
+			if err != nil {
+				return nil, err
+			}
+			if memberIDString == org.Owner {
+				return nil, errors.New("the owner cannot be removed from the organization")
+			}
+			members := []*OrgMember{}
+			for _, member := range org.Members {
+				if member.ID != memberIDString {
+					members = append(members, member)
+				}
+			}
+			if len(members) == len(org.Members) {
+				return nil, errors.New("user is not a member of the organization")
+			}
+			org.Members = members
+			if err = setOrgMembers(org); err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+)
+
+var formPresencePath = "form-presence-"
+
+// FormPresence collaborator currently editing a form
+type FormPresence struct {
+	Connection string `json:"connection"`
+	User       string `json:"user"`
+	Focus      string `json:"focus"`
+	Updated    int64  `json:"updated"`
+}
+
+// FormPresenceType graphql collaborator object
+var FormPresenceType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "FormPresence",
+	Fields: graphql.Fields{
+		"connection": &graphql.Field{
+			Type: graphql.String,
+		},
+		"user": &graphql.Field{
+			Type: graphql.String,
+		},
+		"focus": &graphql.Field{
+			Type:        graphql.String,
+			Description: "id of the item the collaborator is focused on",
+		},
+		"updated": &graphql.Field{
+			Type: graphql.Int,
+		},
+	},
+})
+
+func publishFormPresence(formIDString string, updateType string, presence *FormPresence, presenceList []*FormPresence) error {
+	updateData := map[string]interface{}{
+		"id":    presence.Connection,
+		"type":  updateType,
+		"user":  presence.User,
+		"focus": presence.Focus,
+	}
+	if presenceList != nil {
+		updateData["presence"] = presenceList
+	}
+	updateDataJSON, err := json.Marshal(updateData)
+	if err != nil {
+		return err
+	}
+	return redisClient.Publish(updateFormPath+formIDString, updateDataJSON).Err()
+}
+
+func setFormPresence(formIDString string, presence *FormPresence) error {
+	presence.Updated = time.Now().Unix()
+	presenceJSON, err := json.MarshalToString(presence)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(formPresencePath+formIDString, presence.Connection, presenceJSON).Err()
+}
+
+// getFormPresence returns everyone in the form, removing collaborators that have not been seen recently
+func getFormPresence(formIDString string) ([]*FormPresence, error) {
+	presenceData, err := redisClient.HGetAll(formPresencePath + formIDString).Result()
+	if err != nil {
+		return nil, err
+	}
+	staleTime := time.Now().Add(-time.Duration(presenceTimeout) * time.Second).Unix()
+	presenceList := []*FormPresence{}
+	for _, currentPresenceData := range presenceData {
+		var presence FormPresence
+		if err = json.UnmarshalFromString(currentPresenceData, &presence); err != nil {
+			return nil, err
+		}
+		if presence.Updated < staleTime {
+			if err = removeFormPresence(formIDString, &presence); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		presenceList = append(presenceList, &presence)
+	}
+	return presenceList, nil
+}
+
+func joinFormPresence(formIDString string, presence *FormPresence) error {
+	if err := setFormPresence(formIDString, presence); err != nil {
+		return err
+	}
+	presenceList, err := getFormPresence(formIDString)
+	if err != nil {
+		return err
+	}
+	return publishFormPresence(formIDString, validFormUpdateTypes[1], presence, presenceList)
+}
+
+func removeFormPresence(formIDString string, presence *FormPresence) error {
+	removed, err := redisClient.HDel(formPresencePath+formIDString, presence.Connection).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		// someone else already sent the leave update
+		return nil
+	}
+	return publishFormPresence(formIDString, validFormUpdateTypes[2], presence, nil)
+}
+
+func focusFormPresence(formIDString string, presence *FormPresence) error {
+	if err := setFormPresence(formIDString, presence); err != nil {
+		return err
+	}
+	return publishFormPresence(formIDString, validFormUpdateTypes[3], presence, nil)
+}
+
+// touchFormPresence marks the collaborator as still connected
+func touchFormPresence(formIDString string, connectionIDString string, userIDString string) error {
+	presenceData, err := redisClient.HGet(formPresencePath+formIDString, connectionIDString).Result()
+	if err == redis.Nil {
+		// presence expired while the connection was still open
+		return joinFormPresence(formIDString, &FormPresence{
+			Connection: connectionIDString,
+			User:       userIDString,
+		})
+	} else if err != nil {
+		return err
+	}
+	var presence FormPresence
+	if err = json.UnmarshalFromString(presenceData, &presence); err != nil {
+		return err
+	}
+	return setFormPresence(formIDString, &presence)
+}
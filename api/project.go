@@ -19,6 +19,7 @@ type Project struct {
 	ID         string      `json:"id"`
 	Name       string      `json:"name"`
 	Owner      string      `json:"owner"`
+	Org        string      `json:"org"`
 	Created    int64       `json:"created"`
 	Updated    int64       `json:"updated"`
 	Forms      int64       `json:"forms"`
@@ -43,6 +44,10 @@ var ProjectType = graphql.NewObject(graphql.ObjectConfig{
 		"owner": &graphql.Field{
 			Type: graphql.String,
 		},
+		"org": &graphql.Field{
+			Type:        graphql.String,
+			Description: "organization owning it, if any",
+		},
 		"created": &graphql.Field{
 			Type: graphql.Int,
 		},
@@ -117,20 +122,33 @@ func checkProjectAccess(projectID primitive.ObjectID, accessToken string, access
 	if claims["type"].(string) == adminType || claims["type"].(string) == superAdminType {
 		return project, editAccessLevel[0], nil
 	}
+	if err = checkAPIKeyProject(claims, project.ID); err != nil {
+		return nil, "", err
+	}
 	// check for valid key
 	if len(accessKey) > 0 {
-		if project.LinkAccess.Secret != accessKey {
-			return nil, "", errors.New("invalid access key")
-		}
-		if !findInArray(project.LinkAccess.Type, necessaryAccess) {
-			return nil, "", errors.New("you do not have the necessary access")
+		if err = checkLinkAccess(projectCollection, projectElasticIndex, projectElasticType, projectID, project.LinkAccess, accessKey, claims, necessaryAccess); err != nil {
+			return nil, "", err
 		}
 		return project, "", nil
 	}
 	var userIDString = claims["id"].(string)
-	for currentUserID, accessVal := range project.Access.(map[string]bson.M) {
-		if currentUserID == userIDString {
-			return project, accessVal["type"].(string), nil
+	if accessVal, ok := project.Access.(map[string]bson.M)[userIDString]; ok {
+		return project, accessVal["type"].(string), nil
+	}
+	// groups the user is in can give access
+	groupAccessType, err := getUserAccessType(project.Access.(map[string]bson.M), userIDString)
+	if err != nil {
+		return nil, "", err
+	}
+	if findInArray(groupAccessType, necessaryAccess) {
+		return project, groupAccessType, nil
+	}
+	// members of the organization owning the project get access through their role
+	if len(project.Org) > 0 {
+		accessType, err := getOrgAccessType(project.Org, userIDString)
+		if err == nil && findInArray(accessType, necessaryAccess) {
+			return project, accessType, nil
 		}
 	}
 	return nil, "", errors.New("user not authorized to access project")
@@ -155,6 +173,10 @@ func countProjects(c *gin.Context) {
 		handleError("user not logged in", http.StatusBadRequest, response)
 		return
 	}
+	if err = checkAPIKeyScope(getAuthToken(request), apiKeyFormsReadScope); err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
+		return
+	}
 	userIDString, ok := claims["id"].(string)
 	if !ok {
 		handleError("cannot cast user id to string", http.StatusBadRequest, response)
@@ -185,7 +207,12 @@ func countProjects(c *gin.Context) {
 	if onlyShared {
 		necessaryAccessLevel = []string{sharedAccessLevel}
 	}
-	mustQueries[0] = elastic.NewTermsQuery(fmt.Sprintf("access.%s.type", userIDString), stringListToInterfaceList(necessaryAccessLevel)...)
+	accessQuery, err := getAccessQuery(userIDString, necessaryAccessLevel)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	mustQueries[0] = accessQuery
 	for i, tag := range tags {
 		mustQueries[i+1] = elastic.NewTermQuery(fmt.Sprintf("access.%s.tags", userIDString), tag)
 	}
@@ -196,6 +223,9 @@ func countProjects(c *gin.Context) {
 	if len(mustQueries) > 0 {
 		query = query.Must(mustQueries...)
 	}
+	if apiKeyProjects := getAPIKeyProjects(claims); len(apiKeyProjects) > 0 {
+		query = query.Filter(elastic.NewIdsQuery().Ids(apiKeyProjects...))
+	}
 	if len(searchterm) > 0 {
 		mainquery := elastic.NewMultiMatchQuery(searchterm, projectSearchFields...)
 		query = query.Filter(mainquery)
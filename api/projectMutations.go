@@ -27,6 +27,10 @@ var projectMutationFields = graphql.Fields{
 			"tags": &graphql.ArgumentConfig{
 				Type: graphql.NewList(graphql.String),
 			},
+			"org": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "organization to own the project",
+			},
 		},
 		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
@@ -41,38 +45,16 @@ var projectMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
-			planIDString, ok := claims["plan"].(string)
-			if !ok {
-				return nil, errors.New("cannot convert plan to string")
-			}
-			var planID primitive.ObjectID
-			if len(planIDString) > 0 {
-				planID, err = primitive.ObjectIDFromHex(planIDString)
-				if err != nil {
-					return nil, err
+			var org = ""
+			if params.Args["org"] != nil {
+				org, ok = params.Args["org"].(string)
+				if !ok {
+					return nil, errors.New("problem casting org to string")
 				}
-			} else {
-				planID = primitive.NilObjectID
 			}
-			productData, err := getProduct(planID, !isDebug())
-			if err != nil {
+			if err = checkUsageLimit(claims, org, projectElasticType); err != nil {
 				return nil, err
 			}
-			mustQueries := []elastic.Query{
-				elastic.NewTermsQuery("owner", userIDString),
-			}
-			query := elastic.NewBoolQuery().Must(mustQueries...)
-			numProjectsAlready, err := elasticClient.Count().
-				Type(projectElasticType).
-				Query(query).
-				Pretty(false).
-				Do(ctxElastic)
-			if err != nil {
-				return nil, err
-			}
-			if numProjectsAlready >= int64(productData.MaxProjects) {
-				return nil, errors.New("you reached the maximum amount of projects")
-			}
 			if params.Args["name"] == nil {
 				return nil, errors.New("name not provided")
 			}
@@ -139,6 +121,9 @@ var projectMutationFields = graphql.Fields{
 				},
 				"public": noAccessLevel,
 			}
+			if len(org) > 0 {
+				projectData["org"] = org
+			}
 			_, err = projectCollection.InsertOne(ctxMongo, projectData)
 			if err != nil {
 				return nil, err
@@ -178,6 +163,22 @@ var projectMutationFields = graphql.Fields{
 			"linkaccess": &graphql.ArgumentConfig{
 				Type: graphql.String,
 			},
+			"linkexpires": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "unix time the link stops working, 0 to never expire",
+			},
+			"linkmaxuses": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "number of users that can open the link, 0 for no limit",
+			},
+			"linkpassword": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "password needed to open the link, empty to remove it",
+			},
+			"linkdomain": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "email domain users need to open the link, empty for any",
+			},
 			"categories": &graphql.ArgumentConfig{
 				Type: graphql.NewList(graphql.String),
 			},
@@ -317,27 +318,15 @@ var projectMutationFields = graphql.Fields{
 				projectData.Public = public
 				updateDataElastic["public"] = public
 			}
-			if params.Args["linkaccess"] != nil {
-				linkaccess, ok := params.Args["linkaccess"].(string)
-				if !ok {
-					return nil, errors.New("problem casting public to string")
-				}
-				if !findInArray(linkaccess, validAccessTypes) {
-					return nil, errors.New("invalid link access level")
-				}
-				updateDataDB["$set"].(bson.M)["linkaccess"] = bson.M{
-					"type": linkaccess,
-				}
-				projectData.LinkAccess.Type = linkaccess
-				updateDataElastic["linkaccess"] = bson.M{
-					"type": linkaccess,
-				}
+			if err = setLinkAccessArgs(params, projectData.LinkAccess, updateDataDB, updateDataElastic); err != nil {
+				return nil, err
 			}
 			if len(access) > 0 {
 				script := elastic.NewScript(addRemoveAccessScript).Params(map[string]interface{}{
-					"access":     access,
-					"tags":       tags,
-					"categories": categories,
+					"access":       access,
+					"tags":         tags,
+					"categories":   categories,
+					"userIDString": userIDString,
 				})
 				_, err = elasticClient.Update().
 					Index(projectElasticIndex).
@@ -365,6 +354,13 @@ var projectMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
+			if len(access) > 0 {
+				recordAudit(auditAccessChangeAction, userIDString, projectType, projectIDString, projectData.Owner, getParamsIP(params), access)
+			}
+			if linkAccessChanges := getLinkAccessChanges(params); len(linkAccessChanges) > 0 {
+				recordAudit(auditLinkAccessChangeAction, userIDString, projectType, projectIDString, projectData.Owner, getParamsIP(params), linkAccessChanges)
+			}
+			publishProjectChanged(projectData, validProjectChangeTypes[0])
 			return projectData, nil
 		},
 	},
@@ -431,6 +427,10 @@ var projectMutationFields = graphql.Fields{
 			if err = deleteProject(projectID); err != nil {
 				return nil, err
 			}
+			recordAudit(auditProjectDeleteAction, userIDString, projectType, projectIDString, projectData.Owner, getParamsIP(params), bson.M{
+				"name": projectData.Name,
+			})
+			publishProjectChanged(projectData, validProjectChangeTypes[1])
 			return projectData, nil
 		},
 	},
@@ -135,7 +135,11 @@ var projectQueryFields = graphql.Fields{
 					if onlyShared {
 						necessaryAccessLevel = []string{sharedAccessLevel}
 					}
-					mustQueries[0] = elastic.NewTermsQuery(fmt.Sprintf("access.%s.type", userIDString), stringListToInterfaceList(necessaryAccessLevel)...)
+					accessQuery, err := getAccessQuery(userIDString, necessaryAccessLevel)
+					if err != nil {
+						return nil, err
+					}
+					mustQueries[0] = accessQuery
 				}
 				for i, tag := range tags {
 					mustQueries[i+startIndex] = elastic.NewTermQuery(fmt.Sprintf("access.%s.tags", userIDString), tag)
@@ -144,6 +148,9 @@ var projectQueryFields = graphql.Fields{
 					mustQueries[i+numtags+startIndex] = elastic.NewTermQuery(fmt.Sprintf("access.%s.categories", userIDString), category)
 				}
 				query := elastic.NewBoolQuery().Must(mustQueries...)
+				if apiKeyProjects := getAPIKeyProjects(claims); len(apiKeyProjects) > 0 {
+					query = query.Filter(elastic.NewIdsQuery().Ids(apiKeyProjects...))
+				}
 				if len(searchterm) > 0 {
 					mainquery := elastic.NewMultiMatchQuery(searchterm, projectSearchFields...)
 					query = query.Filter(mainquery)
@@ -12,6 +12,8 @@ import (
 	"github.com/stripe/stripe-go/webhook"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
 )
 
 // see https://stripe.com/docs/billing/subscriptions/payment
@@ -42,6 +44,25 @@ func handleStripeHooks(c *gin.Context) {
 			handleError(err.Error(), http.StatusBadRequest, response)
 			return
 		}
+		if responseIDString, ok := paymentIntent.Metadata["responseID"]; ok {
+			// payment for a form response
+			responseID, err := primitive.ObjectIDFromHex(responseIDString)
+			if err != nil {
+				handleError(err.Error(), http.StatusBadRequest, response)
+				return
+			}
+			err = markResponsePaid(responseID, paymentIntent.ID)
+			if err == mongo.ErrNoDocuments {
+				// the response was deleted after paying, stripe should not retry the event
+				logger.Warn("payment for deleted response "+responseIDString, zap.String("paymentintent", paymentIntent.ID))
+				break
+			}
+			if err != nil {
+				handleError(err.Error(), http.StatusBadRequest, response)
+				return
+			}
+			break
+		}
 		userUpdateData := bson.M{
 			"$addToSet": bson.M{},
 		}
@@ -58,6 +79,7 @@ func handleStripeHooks(c *gin.Context) {
 			handleError(err.Error(), http.StatusBadRequest, response)
 			return
 		}
+		publishAccountChanged(userID)
 	case "customer.subscription.trial_will_end":
 		logger.Info("trial ended")
 	case "payment_method.attached":
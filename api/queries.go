@@ -29,6 +29,33 @@ func rootQuery() *graphql.Object {
 	for key := range formQueryFields {
 		fields[key] = formQueryFields[key]
 	}
+	for key := range formHistoryQueryFields {
+		fields[key] = formHistoryQueryFields[key]
+	}
+	for key := range commentQueryFields {
+		fields[key] = commentQueryFields[key]
+	}
+	for key := range formSaveQueryFields {
+		fields[key] = formSaveQueryFields[key]
+	}
+	for key := range orgQueryFields {
+		fields[key] = orgQueryFields[key]
+	}
+	for key := range groupQueryFields {
+		fields[key] = groupQueryFields[key]
+	}
+	for key := range linkAccessQueryFields {
+		fields[key] = linkAccessQueryFields[key]
+	}
+	for key := range auditQueryFields {
+		fields[key] = auditQueryFields[key]
+	}
+	for key := range apiKeyQueryFields {
+		fields[key] = apiKeyQueryFields[key]
+	}
+	for key := range sessionQueryFields {
+		fields[key] = sessionQueryFields[key]
+	}
 	for key := range projectQueryFields {
 		fields[key] = projectQueryFields[key]
 	}
@@ -38,6 +65,7 @@ func rootQuery() *graphql.Object {
 	for key := range blogQueryFields {
 		fields[key] = blogQueryFields[key]
 	}
+	addAPIKeyScopeChecks(fields)
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name:   "Query",
 		Fields: fields,
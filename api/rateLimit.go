@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var rateLimitPath = "rate-limit-"
+
+// checkRateLimit counts a request against a fixed window limit
+func checkRateLimit(key string, limit int64, window time.Duration) error {
+	// the counter is created with its expiration in the same transaction, so it cannot be left
+	// without one
+	pipeline := redisClient.TxPipeline()
+	pipeline.SetNX(rateLimitPath+key, 0, window)
+	count := pipeline.Incr(rateLimitPath + key)
+	if _, err := pipeline.Exec(); err != nil {
+		return err
+	}
+	if count.Val() > limit {
+		return errors.New("too many requests, try again later")
+	}
+	return nil
+}
+
+// addSlidingWindowEvent records an event and returns the number of events in the last window
+func addSlidingWindowEvent(key string, window time.Duration) (int64, error) {
+	now := time.Now()
+	pipeline := redisClient.TxPipeline()
+	pipeline.ZRemRangeByScore(rateLimitPath+key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano()/int64(time.Microsecond), 10))
+	pipeline.ZAdd(rateLimitPath+key, &redis.Z{
+		Score:  float64(now.UnixNano() / int64(time.Microsecond)),
+		Member: primitive.NewObjectID().Hex(),
+	})
+	count := pipeline.ZCard(rateLimitPath + key)
+	pipeline.Expire(rateLimitPath+key, window)
+	if _, err := pipeline.Exec(); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// checkSlidingRateLimit counts a request against a sliding window limit, so bursts at the edge
+// of a window are not let through twice
+func checkSlidingRateLimit(key string, limit int64, window time.Duration) error {
+	count, err := addSlidingWindowEvent(key, window)
+	if err != nil {
+		return err
+	}
+	if count > limit {
+		return errors.New("too many requests, try again later")
+	}
+	return nil
+}
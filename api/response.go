@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"time"
@@ -15,16 +18,25 @@ import (
 
 // Response response object
 type Response struct {
-	ID      string          `json:"id"`
-	Views   int64           `json:"views"`
-	Owner   string          `json:"owner"`
-	User    string          `json:"user"`
-	Form    string          `json:"form"`
-	Project string          `json:"project"`
-	Created int64           `json:"created"`
-	Updated int64           `json:"updated"`
-	Items   []*ResponseItem `json:"items"`
-	Files   []*File         `json:"files"`
+	ID        string          `json:"id"`
+	Views     int64           `json:"views"`
+	Owner     string          `json:"owner"`
+	Org       string          `json:"org"`
+	User      string          `json:"user"`
+	Form      string          `json:"form"`
+	Project   string          `json:"project"`
+	Created   int64           `json:"created"`
+	Updated   int64           `json:"updated"`
+	Items     []*ResponseItem `json:"items"`
+	Files     []*File         `json:"files"`
+	Duplicate bool            `json:"duplicate"`
+	Amount    int64           `json:"amount"`
+	Currency  string          `json:"currency"`
+	Paid      bool            `json:"paid"`
+	// PaymentIntent stripe payment intent id, not exposed in graphql
+	PaymentIntent string `json:"paymentintent"`
+	// UniqueKeys hmacs of the unique answers, not exposed in graphql
+	UniqueKeys []string `json:"uniquekeys"`
 }
 
 // ResponseType response to form
@@ -40,6 +52,10 @@ var ResponseType = graphql.NewObject(graphql.ObjectConfig{
 		"owner": &graphql.Field{
 			Type: graphql.String,
 		},
+		"org": &graphql.Field{
+			Type:        graphql.String,
+			Description: "organization owning it, if any",
+		},
 		"user": &graphql.Field{
 			Type: graphql.String,
 		},
@@ -61,6 +77,24 @@ var ResponseType = graphql.NewObject(graphql.ObjectConfig{
 		"files": &graphql.Field{
 			Type: graphql.NewList(FileType),
 		},
+		"duplicate": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "response matches another response's unique items",
+		},
+		"amount": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "amount owed for payment items, in the smallest currency unit",
+		},
+		"currency": &graphql.Field{
+			Type: graphql.String,
+		},
+		"paid": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+		"paymentSecret": &graphql.Field{
+			Type:        graphql.String,
+			Description: "stripe client secret used to confirm payment after submitting",
+		},
 		"editAccessToken": &graphql.Field{
 			Type: graphql.String,
 		},
@@ -148,6 +182,27 @@ func checkResponseAccess(responseID primitive.ObjectID, accessToken string, nece
 	return nil, errors.New("user not authorized to access form")
 }
 
+// getResponseNullifier returns a one-way identifier for a user's response to a form,
+// used for anonymous forms to prevent duplicate submissions without storing the user
+func getResponseNullifier(formID primitive.ObjectID, userID primitive.ObjectID) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(formID.Hex() + userID.Hex()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkResponseEditWindow(response *Response, form *Form) error {
+	if !form.EditResponses {
+		return errors.New("form does not allow editing responses")
+	}
+	if form.EditWindow > 0 {
+		editDeadline := intTimestamp(response.Created).Add(time.Duration(form.EditWindow) * time.Minute)
+		if time.Now().After(editDeadline) {
+			return errors.New("edit window for response has passed")
+		}
+	}
+	return nil
+}
+
 /**
  * @api {get} /countResponses Count responses for search term
  * @apiVersion 0.0.1
@@ -167,6 +222,10 @@ func countResponses(c *gin.Context) {
 		handleError("user not logged in", http.StatusBadRequest, response)
 		return
 	}
+	if err = checkAPIKeyScope(getAuthToken(request), apiKeyResponsesReadScope); err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
+		return
+	}
 	userIDString, ok := claims["id"].(string)
 	if !ok {
 		handleError("cannot cast user id to string", http.StatusBadRequest, response)
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/olivere/elastic/v7"
+)
+
+// unique answers are reserved in redis by the response that has them, so two responses sent at the
+// same time cannot both get through before elastic has indexed either of them.
+
+var responseUniquePath = "response-unique-"
+
+// claimResponseUniqueKeysScript reserves all the keys for the response in ARGV[1], or none of them
+// if another response has one
+var claimResponseUniqueKeysScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	local owner = redis.call("GET", key)
+	if owner and owner ~= ARGV[1] then
+		return 0
+	end
+end
+for _, key in ipairs(KEYS) do
+	redis.call("SET", key, ARGV[1])
+end
+return 1
+`)
+
+// releaseResponseUniqueKeysScript removes the reservations the response in ARGV[1] holds
+var releaseResponseUniqueKeysScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call("GET", key) == ARGV[1] then
+		redis.call("DEL", key)
+	end
+end
+return 1
+`)
+
+func normalizeUniqueAnswer(answer string) string {
+	return strings.ToLower(strings.Join(strings.Fields(answer), " "))
+}
+
+// getResponseUniqueKeySecret returns the hmac key for the unique answers of the form, so the
+// answers cannot be found from the keys by hashing guesses
+func getResponseUniqueKeySecret(formIDString string) []byte {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(responseUniquePath + formIDString))
+	return mac.Sum(nil)
+}
+
+// getResponseUniqueKeys returns hmacs of the normalized answers to items marked unique
+func getResponseUniqueKeys(form *Form, items []*ResponseItem) []string {
+	keys := []string{}
+	secret := getResponseUniqueKeySecret(form.ID)
+	for _, item := range items {
+		if item.FormIndex < 0 || int(item.FormIndex) >= len(form.Items) {
+			continue
+		}
+		formItem := form.Items[item.FormIndex]
+		if !formItem.Unique {
+			continue
+		}
+		var answer string
+		if findInArray(formItem.Type, itemTypesRequireOptions) {
+			options := make([]string, len(item.Options))
+			for i, option := range item.Options {
+				options[i] = normalizeUniqueAnswer(option)
+			}
+			sort.Strings(options)
+			answer = strings.Join(options, "\n")
+		} else {
+			answer = normalizeUniqueAnswer(item.Text)
+		}
+		if len(answer) == 0 {
+			continue
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(strconv.FormatInt(item.FormIndex, 10) + ":" + answer))
+		keys = append(keys, hex.EncodeToString(mac.Sum(nil)))
+	}
+	return keys
+}
+
+// checkDuplicateResponse returns true if the form has another response with one of the given keys
+func checkDuplicateResponse(form *Form, keys []string, excludeResponseIDString string) (bool, error) {
+	if len(keys) == 0 {
+		return false, nil
+	}
+	query := elastic.NewBoolQuery().Must(
+		elastic.NewTermQuery("form", form.ID),
+		elastic.NewTermsQuery("uniquekeys", stringListToInterfaceList(keys)...),
+	)
+	if len(excludeResponseIDString) > 0 {
+		query = query.MustNot(elastic.NewIdsQuery().Ids(excludeResponseIDString))
+	}
+	count, err := elasticClient.Count().
+		Index(responseElasticIndex).
+		Query(query).
+		Pretty(false).
+		Do(ctxElastic)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func getResponseUniqueKeyPaths(formIDString string, keys []string) []string {
+	paths := make([]string, len(keys))
+	for i, key := range keys {
+		paths[i] = responseUniquePath + formIDString + "-" + key
+	}
+	return paths
+}
+
+// claimResponseUniqueKeys reserves the keys for the response, returning false if another response has one
+func claimResponseUniqueKeys(formIDString string, keys []string, responseIDString string) (bool, error) {
+	if len(keys) == 0 {
+		return true, nil
+	}
+	claimed, err := claimResponseUniqueKeysScript.Run(redisClient, getResponseUniqueKeyPaths(formIDString, keys), responseIDString).Int64()
+	if err != nil {
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+// getRemovedResponseUniqueKeys returns the old keys that are not in the new keys
+func getRemovedResponseUniqueKeys(oldKeys []string, newKeys []string) []string {
+	removed := []string{}
+	for _, key := range oldKeys {
+		if !findInArray(key, newKeys) {
+			removed = append(removed, key)
+		}
+	}
+	return removed
+}
+
+// releaseResponseUniqueKeys lets other responses use the keys, once the response is gone or has other answers
+func releaseResponseUniqueKeys(formIDString string, keys []string, responseIDString string) {
+	if len(keys) == 0 {
+		return
+	}
+	if err := releaseResponseUniqueKeysScript.Run(redisClient, getResponseUniqueKeyPaths(formIDString, keys), responseIDString).Err(); err != nil {
+		logger.Error("cannot release unique keys of response " + responseIDString + ": " + err.Error())
+	}
+}
+
+// getResponseDuplicateData checks the response against the form's duplicate policy, reserving its
+// unique answers when it is not a duplicate
+func getResponseDuplicateData(form *Form, items []*ResponseItem, responseIDString string) ([]string, bool, error) {
+	keys := getResponseUniqueKeys(form, items)
+	// elastic finds responses from before the answers were reserved
+	duplicate, err := checkDuplicateResponse(form, keys, responseIDString)
+	if err != nil {
+		return nil, false, err
+	}
+	if !duplicate {
+		claimed, err := claimResponseUniqueKeys(form.ID, keys, responseIDString)
+		if err != nil {
+			return nil, false, err
+		}
+		duplicate = !claimed
+	}
+	if duplicate && form.DuplicateAction != duplicateActionFlag {
+		return nil, false, errors.New("a response with the same answers already exists")
+	}
+	return keys, duplicate, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var responseEmailTemplate = template.Must(template.ParseFiles("templates/responseEmail.html"))
+
+// ResponseEmailItem question and answer pair for response emails
+type ResponseEmailItem struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// ResponseEmailData response email object
+type ResponseEmailData struct {
+	Form     *Form                `json:"form"`
+	Items    []*ResponseEmailItem `json:"items"`
+	EditLink string               `json:"editlink"`
+}
+
+func getResponseEmailData(emailData *ResponseEmailData) (string, error) {
+	var templateData bytes.Buffer
+	err := responseEmailTemplate.Execute(&templateData, emailData)
+	if err != nil {
+		return "", err
+	}
+	minifiedData, err := minifier.String("text/html", templateData.String())
+	if err != nil {
+		return "", err
+	}
+	return minifiedData, nil
+}
+
+func getResponseEmailItems(form *Form, response *Response) []*ResponseEmailItem {
+	items := make([]*ResponseEmailItem, 0, len(response.Items))
+	for _, responseItem := range response.Items {
+		if responseItem.FormIndex < 0 || int(responseItem.FormIndex) >= len(form.Items) {
+			continue
+		}
+		formItem := form.Items[responseItem.FormIndex]
+		var answer string
+		if findInArray(formItem.Type, itemTypesRequireOptions) {
+			answer = strings.Join(responseItem.Options, ", ")
+		} else if findInArray(formItem.Type, itemTypesFile) {
+			answer = strconv.Itoa(len(responseItem.Files)) + " file(s) uploaded"
+		} else {
+			answer = responseItem.Text
+		}
+		items = append(items, &ResponseEmailItem{
+			Question: formItem.Question,
+			Answer:   answer,
+		})
+	}
+	return items
+}
+
+func sendResponseEmail(responseIDString string) error {
+	responseID, err := primitive.ObjectIDFromHex(responseIDString)
+	if err != nil {
+		return err
+	}
+	response, err := getResponse(responseID, false)
+	if err != nil {
+		return err
+	}
+	formID, err := primitive.ObjectIDFromHex(response.Form)
+	if err != nil {
+		return err
+	}
+	form, err := getForm(formID, false)
+	if err != nil {
+		return err
+	}
+	if !form.EmailResponses || form.Anonymous {
+		return nil
+	}
+	userID, err := primitive.ObjectIDFromHex(response.User)
+	if err != nil {
+		return err
+	}
+	account, err := getAccount(userID, false)
+	if err != nil {
+		return err
+	}
+	if len(account.Email) == 0 {
+		return errors.New("no email found for respondent")
+	}
+	var editLink string
+	if form.EditResponses {
+		tokenString, err := getResponseEditToken(responseIDString, response.User, response.Created, form)
+		if err != nil {
+			return err
+		}
+		editLink = websiteURL + "/form/" + response.Form + "?response=" + responseIDString + "&token=" + tokenString
+	}
+	emailContent, err := getResponseEmailData(&ResponseEmailData{
+		Form:     form,
+		Items:    getResponseEmailItems(form, response),
+		EditLink: editLink,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sendEmail(account.Email, "Your response to "+form.Name, emailContent)
+	return err
+}
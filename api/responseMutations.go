@@ -135,6 +135,27 @@ func getResponseAddTokenData(accessToken string, accessLevel []string) (string,
 	return formIDString, projectIDString, ownerIDString, userIDString, nil
 }
 
+func getResponseEditToken(responseIDString string, userIDString string, created int64, form *Form) (string, error) {
+	// tokens for forms that do not allow edits can still be used to view the response
+	accessType := validAccessTypes[1]
+	if form.EditResponses {
+		accessType = validAccessTypes[0]
+	}
+	standardClaims := jwt.StandardClaims{
+		Issuer: jwtIssuer,
+	}
+	if form.EditWindow > 0 {
+		standardClaims.ExpiresAt = intTimestamp(created).Add(time.Duration(form.EditWindow) * time.Minute).Unix()
+	}
+	return signToken(responseEditClaims{
+		responseIDString,
+		userIDString,
+		accessType,
+		form.Owner,
+		standardClaims,
+	})
+}
+
 var responseMutationFields = graphql.Fields{
 	"addResponse": &graphql.Field{
 		Type:        ResponseType,
@@ -156,6 +177,17 @@ var responseMutationFields = graphql.Fields{
 				Type:        graphql.String,
 				Description: "sharable link key",
 			},
+			"recaptcha": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"honeypot": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "hidden field that should be left empty",
+			},
+			"startToken": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "response start token from the form query",
+			},
 		},
 		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 			formIDString, ok := params.Args["id"].(string)
@@ -243,7 +275,7 @@ var responseMutationFields = graphql.Fields{
 			if !ok {
 				return nil, errors.New("problem casting files to interface array")
 			}
-			var getResponseEditToken = false
+			var getEditAccessToken = false
 			fieldarray := params.Info.FieldASTs
 			fieldselections := fieldarray[0].SelectionSet.Selections
 			for _, field := range fieldselections {
@@ -252,26 +284,42 @@ var responseMutationFields = graphql.Fields{
 					return nil, errors.New("field cannot be converted to *ast.FIeld")
 				}
 				if fieldast.Name.Value == "editAccessToken" {
-					getResponseEditToken = true
+					getEditAccessToken = true
 					continue
 				}
 			}
-			responseData, err := addResponse(itemsInterface, filesInterface, formID, projectID, ownerID, userID)
+			spamData := &ResponseSpamData{}
+			if ip, ok := params.Context.Value(ipKey).(string); ok {
+				spamData.IP = ip
+			}
+			if params.Args["recaptcha"] != nil {
+				spamData.Recaptcha, ok = params.Args["recaptcha"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast recaptcha to string")
+				}
+			}
+			if params.Args["honeypot"] != nil {
+				spamData.Honeypot, ok = params.Args["honeypot"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast honeypot to string")
+				}
+			}
+			if params.Args["startToken"] != nil {
+				spamData.StartToken, ok = params.Args["startToken"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast start token to string")
+				}
+			}
+			responseData, err := addResponse(itemsInterface, filesInterface, formID, projectID, ownerID, userID, spamData)
 			if err != nil {
 				return nil, err
 			}
-			if getResponseEditToken {
-				// return access token with current claims + project
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, responseEditClaims{
-					responseData["id"].(string),
-					userIDString,
-					validAccessTypes[0],
-					ownerIDString,
-					jwt.StandardClaims{
-						Issuer: jwtIssuer,
-					},
-				})
-				tokenString, err := token.SignedString(jwtSecret)
+			if getEditAccessToken {
+				formData, err := getForm(formID, false)
+				if err != nil {
+					return nil, err
+				}
+				tokenString, err := getResponseEditToken(responseData["id"].(string), userIDString, responseData["created"].(int64), formData)
 				if err != nil {
 					return nil, err
 				}
@@ -316,6 +364,7 @@ var responseMutationFields = graphql.Fields{
 			var accessToken string
 			var userIDString string
 			var responseData *Response
+			var isRespondent = true
 			if useAccessToken {
 				accessToken, ok = params.Args["accessToken"].(string)
 				if !ok {
@@ -348,11 +397,26 @@ var responseMutationFields = graphql.Fields{
 					return nil, err
 				}
 				userIDString = claims["id"].(string)
+				// admins can edit responses outside of the form's edit policy
+				isRespondent = userIDString == responseData.User
 			}
 			userID, err := primitive.ObjectIDFromHex(userIDString)
 			if err != nil {
 				return nil, err
 			}
+			formID, err := primitive.ObjectIDFromHex(responseData.Form)
+			if err != nil {
+				return nil, errors.New("unable to create object id from string")
+			}
+			if isRespondent {
+				formData, err := getForm(formID, false)
+				if err != nil {
+					return nil, err
+				}
+				if err = checkResponseEditWindow(responseData, formData); err != nil {
+					return nil, err
+				}
+			}
 			updateDataDB := bson.M{
 				"$set": bson.M{},
 			}
@@ -406,10 +470,6 @@ var responseMutationFields = graphql.Fields{
 				updateDataDB["$set"].(bson.M)["files"] = fileData
 				updateDataElastic["files"] = fileData
 			}
-			formID, err := primitive.ObjectIDFromHex(responseData.Form)
-			if err != nil {
-				return nil, errors.New("unable to create object id from string")
-			}
 			if params.Args["items"] != nil {
 				itemsInterface, ok := params.Args["items"].([]interface{})
 				if !ok {
@@ -419,7 +479,8 @@ var responseMutationFields = graphql.Fields{
 				if err != nil {
 					return nil, err
 				}
-				if err = validateResponseItems(formID, userID, true, &itemsUpdate); err != nil {
+				formData, err := validateResponseItems(formID, userID, true, &itemsUpdate)
+				if err != nil {
 					return nil, err
 				}
 				for _, itemUpdate := range itemsUpdate {
@@ -458,6 +519,25 @@ var responseMutationFields = graphql.Fields{
 				}
 				updateDataDB["$set"].(bson.M)["items"] = responseData.Items
 				updateDataElastic["items"] = responseData.Items
+				amount, _, err := getResponsePaymentAmount(formData, responseData.Items)
+				if err != nil {
+					return nil, err
+				}
+				if amount != responseData.Amount {
+					return nil, errors.New("cannot change payment items after submitting")
+				}
+				uniqueKeys, duplicate, err := getResponseDuplicateData(formData, responseData.Items, responseIDString)
+				if err != nil {
+					return nil, err
+				}
+				// the answers this response no longer has can be used by others
+				releaseResponseUniqueKeys(formData.ID, getRemovedResponseUniqueKeys(responseData.UniqueKeys, uniqueKeys), responseIDString)
+				responseData.UniqueKeys = uniqueKeys
+				responseData.Duplicate = duplicate
+				updateDataDB["$set"].(bson.M)["uniquekeys"] = uniqueKeys
+				updateDataElastic["uniquekeys"] = uniqueKeys
+				updateDataDB["$set"].(bson.M)["duplicate"] = duplicate
+				updateDataElastic["duplicate"] = duplicate
 			}
 			_, err = elasticClient.Update().
 				Index(responseElasticIndex).
@@ -513,7 +593,7 @@ var responseMutationFields = graphql.Fields{
 			if err != nil {
 				return nil, err
 			}
-			if err = changeUserStorage(ownerID, -1*bytesRemoved); err != nil {
+			if err = changeOwnerStorage(ownerID, response.Org, -1*bytesRemoved); err != nil {
 				return nil, err
 			}
 			if !justDeleteElastic {
@@ -541,6 +621,13 @@ var responseMutationFields = graphql.Fields{
 				if err != nil {
 					return nil, err
 				}
+				var actorIDString = ""
+				if claims, err := getTokenData(accessToken); err == nil {
+					actorIDString, _ = claims["id"].(string)
+				}
+				recordAudit(auditResponseDeleteAction, actorIDString, responseType, responseIDString, response.Owner, getParamsIP(params), bson.M{
+					"form": response.Form,
+				})
 			}
 			return response, nil
 		},
@@ -554,6 +641,9 @@ var responseMutationFields = graphql.Fields{
  * @apiParam {String} accessToken Token for authentication
  * @apiParam {Array} items Item objects
  * @apiParam {Array} files File objects
+ * @apiParam {String} recaptcha Recaptcha token (if required by form)
+ * @apiParam {String} honeypot Hidden field, must be empty
+ * @apiParam {String} startToken Response start token (if form has a minimum fill time)
  * @apiSuccess {Object} data Response data
  */
 func addResponseHandler(c *gin.Context) {
@@ -616,6 +706,22 @@ func addResponseHandler(c *gin.Context) {
 		handleError("token form id does not match given form id", http.StatusBadRequest, response)
 		return
 	}
+	// scripts submitting with an api key need the same scope as the addResponse mutation
+	if authToken := getAuthToken(request); isAPIKey(authToken) {
+		if err = checkAPIKeyScope(authToken, apiKeyResponsesManageScope); err != nil {
+			handleError(err.Error(), http.StatusUnauthorized, response)
+			return
+		}
+		claims, err := getTokenData(authToken)
+		if err != nil {
+			handleError(err.Error(), http.StatusUnauthorized, response)
+			return
+		}
+		if err = checkAPIKeyProject(claims, projectIDString); err != nil {
+			handleError(err.Error(), http.StatusUnauthorized, response)
+			return
+		}
+	}
 	userID, _ := primitive.ObjectIDFromHex(userIDString)
 	if responsedata["items"] == nil {
 		handleError("items was not provided", http.StatusBadRequest, response)
@@ -635,7 +741,31 @@ func addResponseHandler(c *gin.Context) {
 		handleError("problem casting files to interface array", http.StatusBadRequest, response)
 		return
 	}
-	responseData, err := addResponse(itemsInterface, filesInterface, formID, projectID, ownerID, userID)
+	spamData := &ResponseSpamData{
+		IP: c.ClientIP(),
+	}
+	if responsedata["recaptcha"] != nil {
+		spamData.Recaptcha, ok = responsedata["recaptcha"].(string)
+		if !ok {
+			handleError("cannot cast recaptcha to string", http.StatusBadRequest, response)
+			return
+		}
+	}
+	if responsedata["honeypot"] != nil {
+		spamData.Honeypot, ok = responsedata["honeypot"].(string)
+		if !ok {
+			handleError("cannot cast honeypot to string", http.StatusBadRequest, response)
+			return
+		}
+	}
+	if responsedata["startToken"] != nil {
+		spamData.StartToken, ok = responsedata["startToken"].(string)
+		if !ok {
+			handleError("cannot cast start token to string", http.StatusBadRequest, response)
+			return
+		}
+	}
+	responseData, err := addResponse(itemsInterface, filesInterface, formID, projectID, ownerID, userID, spamData)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
@@ -649,7 +779,10 @@ func addResponseHandler(c *gin.Context) {
 	response.Write(responseDataBytes)
 }
 
-func addResponse(itemsInterface []interface{}, filesInterface []interface{}, formID primitive.ObjectID, projectID primitive.ObjectID, ownerID primitive.ObjectID, userID primitive.ObjectID) (map[string]interface{}, error) {
+func addResponse(itemsInterface []interface{}, filesInterface []interface{}, formID primitive.ObjectID, projectID primitive.ObjectID, ownerID primitive.ObjectID, userID primitive.ObjectID, spamData *ResponseSpamData) (map[string]interface{}, error) {
+	if err := checkResponseSpam(formID, spamData); err != nil {
+		return nil, err
+	}
 	items, err := interfaceListToMapList(itemsInterface)
 	if err != nil {
 		return nil, err
@@ -668,26 +801,74 @@ func addResponse(itemsInterface []interface{}, filesInterface []interface{}, for
 			return nil, err
 		}
 	}
-	if err = validateResponseItems(formID, userID, false, &items); err != nil {
+	formData, err := validateResponseItems(formID, userID, false, &items)
+	if err != nil {
+		return nil, err
+	}
+	responseItems := make([]*ResponseItem, len(items))
+	for i := range items {
+		if err = mapstructure.Decode(items[i], &responseItems[i]); err != nil {
+			return nil, err
+		}
+	}
+	amount, currency, err := getResponsePaymentAmount(formData, responseItems)
+	if err != nil {
+		return nil, err
+	}
+	responseID := primitive.NewObjectID()
+	responseIDString := responseID.Hex()
+	uniqueKeys, duplicate, err := getResponseDuplicateData(formData, responseItems, responseIDString)
+	if err != nil {
 		return nil, err
 	}
 	now := time.Now()
+	userIDString := userID.Hex()
+	if formData.Anonymous {
+		userIDString = ""
+	}
 	responseData := bson.M{
-		"project": projectID.Hex(),
-		"updated": now.Unix(),
-		"user":    userID.Hex(),
-		"form":    formID.Hex(),
-		"items":   items,
-		"files":   files,
-		"views":   0,
-		"owner":   ownerID.Hex(),
-	}
-	responseCreateRes, err := responseCollection.InsertOne(ctxMongo, responseData)
+		"project":    projectID.Hex(),
+		"updated":    now.Unix(),
+		"user":       userIDString,
+		"form":       formID.Hex(),
+		"items":      items,
+		"files":      files,
+		"views":      0,
+		"owner":      ownerID.Hex(),
+		"uniquekeys": uniqueKeys,
+		"duplicate":  duplicate,
+	}
+	if len(formData.Org) > 0 {
+		responseData["org"] = formData.Org
+	}
+	if formData.Anonymous && !formData.Multiple {
+		// only store the nullifier when needed, otherwise it would link a user's responses
+		responseData["nullifier"] = getResponseNullifier(formID, userID)
+	}
+	var paymentSecret string
+	if amount > 0 {
+		// the payment intent needs the response id before the response is saved
+		paymentIntentID, clientSecret, err := createResponsePayment(responseID, formData, amount, currency)
+		if err != nil {
+			releaseResponseUniqueKeys(formData.ID, uniqueKeys, responseIDString)
+			return nil, err
+		}
+		paymentSecret = clientSecret
+		responseData["amount"] = amount
+		responseData["currency"] = currency
+		responseData["paid"] = false
+		responseData["paymentintent"] = paymentIntentID
+	}
+	responseData["_id"] = responseID
+	_, err = responseCollection.InsertOne(ctxMongo, responseData)
 	if err != nil {
+		if paymentIntentID, ok := responseData["paymentintent"].(string); ok {
+			cancelResponsePayment(paymentIntentID)
+		}
+		releaseResponseUniqueKeys(formData.ID, uniqueKeys, responseIDString)
 		return nil, err
 	}
-	responseID := responseCreateRes.InsertedID.(primitive.ObjectID)
-	responseIDString := responseID.Hex()
+	delete(responseData, "_id")
 	responseData["created"] = now.Unix()
 	_, err = elasticClient.Index().
 		Index(responseElasticIndex).
@@ -696,6 +877,16 @@ func addResponse(itemsInterface []interface{}, filesInterface []interface{}, for
 		BodyJson(responseData).
 		Do(ctxElastic)
 	if err != nil {
+		// without the index the response cannot be found, so undo it rather than leave a payment for it
+		if paymentIntentID, ok := responseData["paymentintent"].(string); ok {
+			cancelResponsePayment(paymentIntentID)
+		}
+		if _, deleteErr := responseCollection.DeleteOne(ctxMongo, bson.M{
+			"_id": responseID,
+		}); deleteErr != nil {
+			logger.Error("cannot delete unindexed response " + responseIDString + ": " + deleteErr.Error())
+		}
+		releaseResponseUniqueKeys(formData.ID, uniqueKeys, responseIDString)
 		return nil, err
 	}
 	script := elastic.NewScriptInline("ctx._source.responses+=1")
@@ -719,6 +910,17 @@ func addResponse(itemsInterface []interface{}, filesInterface []interface{}, for
 		return nil, err
 	}
 	responseData["id"] = responseIDString
+	delete(responseData, "paymentintent")
+	publishResponseAdded(formID.Hex(), responseData)
+	if len(paymentSecret) > 0 {
+		responseData["paymentSecret"] = paymentSecret
+	}
+	if formData.EmailResponses && !formData.Anonymous {
+		msg := sendResponseEmailTask.WithArgs(ctxMessageQueue, responseIDString)
+		if err = messageQueue.Add(msg); err != nil {
+			logger.Error("cannot queue response email: " + err.Error())
+		}
+	}
 	return responseData, nil
 }
 
@@ -749,6 +951,7 @@ func deleteResponse(responseID primitive.ObjectID, response *Response) (int64, e
 		if err != nil {
 			return 0, err
 		}
+		releaseResponseUniqueKeys(response.Form, response.UniqueKeys, responseIDString)
 		for _, file := range response.Files {
 			newBytesRemoved, err := deleteFile(responseType, response.ID, file.ID)
 			if err != nil {
@@ -760,15 +963,19 @@ func deleteResponse(responseID primitive.ObjectID, response *Response) (int64, e
 	return bytesRemoved, nil
 }
 
-func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID, updating bool, responseItems *[]map[string]interface{}) error {
+func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID, updating bool, responseItems *[]map[string]interface{}) (*Form, error) {
 	formData, err := getForm(formID, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !formData.Multiple && !updating {
 		mustQueries := make([]elastic.Query, 2)
 		mustQueries[0] = elastic.NewTermsQuery("form", formID.Hex())
-		mustQueries[1] = elastic.NewTermQuery("user", userID.Hex())
+		if formData.Anonymous {
+			mustQueries[1] = elastic.NewTermQuery("nullifier", getResponseNullifier(formID, userID))
+		} else {
+			mustQueries[1] = elastic.NewTermQuery("user", userID.Hex())
+		}
 		query := elastic.NewBoolQuery()
 		if len(mustQueries) > 0 {
 			query = query.Must(mustQueries...)
@@ -779,10 +986,10 @@ func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID,
 			Pretty(false).
 			Do(ctxElastic)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if count != 0 {
-			return errors.New("cannot submit multiple responses")
+			return nil, errors.New("cannot submit multiple responses")
 		}
 	}
 	formItems := formData.Items
@@ -790,35 +997,37 @@ func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID,
 	for i, responseItem := range *responseItems {
 		formIndex, _ := responseItem["formIndex"].(int)
 		if formIndex >= len(formItems) || formIndex < 0 {
-			return errors.New("response index outside length of form")
+			return nil, errors.New("response index outside length of form")
 		}
 		if _, ok := responseItemIndexes[formIndex]; ok {
-			return errors.New("cannot have duplicate form index")
+			return nil, errors.New("cannot have duplicate form index")
 		}
 		formItemObj := formItems[formIndex]
 		questionType := formItemObj.Type
 		if !findInArray(questionType, validResponseItemTypes) {
-			return errors.New("invalid type for response item found")
+			return nil, errors.New("invalid type for response item found")
 		}
 		questionRequired := formItemObj.Required
 		if findInArray(questionType, itemTypesRequireOptions) {
 			selectedOptions, err := interfaceListToStringList(responseItem["options"].([]interface{}))
 			if err != nil {
-				return errors.New("problem casting selected options to int array")
+				return nil, errors.New("problem casting selected options to int array")
 			}
 			if !findInArray(questionType, itemTypesAllowMultipleOptions) && len(selectedOptions) > 1 {
-				return errors.New("cannot select multiple options")
+				return nil, errors.New("cannot select multiple options")
 			}
 			questionOptions := formItemObj.Options
 			var foundOption = false
 			for _, option := range selectedOptions {
 				if !findInArray(option, questionOptions) {
-					return errors.New("cannot find given option in question options")
+					return nil, errors.New("cannot find given option in question options")
 				}
 				foundOption = true
 			}
-			if questionRequired && !foundOption {
-				return errors.New("cannot find a valid selected option")
+			// fixed price payment items have no options to select
+			fixedPayment := findInArray(questionType, itemTypesPayment) && len(questionOptions) == 0
+			if questionRequired && !foundOption && !fixedPayment {
+				return nil, errors.New("cannot find a valid selected option")
 			}
 		} else {
 			(*responseItems)[i]["options"] = bson.A{}
@@ -826,7 +1035,7 @@ func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID,
 		if findInArray(questionType, itemTypesText) {
 			// text input
 			if questionRequired && len(responseItem["text"].(string)) == 0 {
-				return errors.New("cannot find any text for response item")
+				return nil, errors.New("cannot find any text for response item")
 			}
 		} else {
 			(*responseItems)[i]["text"] = ""
@@ -834,7 +1043,7 @@ func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID,
 		if findInArray(questionType, itemTypesFile) {
 			// file input
 			if questionRequired && len(responseItem["files"].([]interface{})) == 0 {
-				return errors.New("cannot find any files for response item")
+				return nil, errors.New("cannot find any files for response item")
 			}
 		} else {
 			(*responseItems)[i]["files"] = bson.A{}
@@ -844,9 +1053,9 @@ func validateResponseItems(formID primitive.ObjectID, userID primitive.ObjectID,
 	for i, formItem := range formItems {
 		if formItem.Required {
 			if _, ok := responseItemIndexes[i]; !ok {
-				return errors.New("required item not found")
+				return nil, errors.New("required item not found")
 			}
 		}
 	}
-	return nil
+	return formData, nil
 }
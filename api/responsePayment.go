@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/stripe/stripe-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// getResponsePaymentAmount returns the total owed for the payment items of the form. fixed
+// prices are owed whether or not the response answers the item, only the chosen options come
+// from the response
+func getResponsePaymentAmount(form *Form, items []*ResponseItem) (int64, string, error) {
+	responseItems := make(map[int64]*ResponseItem, len(items))
+	for _, item := range items {
+		responseItems[item.FormIndex] = item
+	}
+	var amount int64
+	var currency string
+	for formIndex, formItem := range form.Items {
+		if !findInArray(formItem.Type, itemTypesPayment) {
+			continue
+		}
+		itemCurrency := formItem.Currency
+		if len(itemCurrency) == 0 {
+			itemCurrency = defaultCurrency
+		}
+		if len(currency) == 0 {
+			currency = itemCurrency
+		} else if currency != itemCurrency {
+			return 0, "", errors.New("payment items must use the same currency")
+		}
+		amount += formItem.Price
+		item := responseItems[int64(formIndex)]
+		if item == nil {
+			continue
+		}
+		for _, option := range item.Options {
+			for i := range formItem.Options {
+				if formItem.Options[i] == option && i < len(formItem.Prices) {
+					amount += formItem.Prices[i]
+					break
+				}
+			}
+		}
+	}
+	return amount, currency, nil
+}
+
+// createResponsePayment creates a payment intent for the response, returning the intent id and client secret
+func createResponsePayment(responseID primitive.ObjectID, form *Form, amount int64, currency string) (string, string, error) {
+	paymentIntentParams := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(amount),
+		Currency: stripe.String(currency),
+		Params: stripe.Params{
+			Metadata: map[string]string{
+				"responseID": responseID.Hex(),
+				"formID":     form.ID,
+			},
+		},
+	}
+	paymentIntent, err := stripeClient.PaymentIntents.New(paymentIntentParams)
+	if err != nil {
+		return "", "", err
+	}
+	return paymentIntent.ID, paymentIntent.ClientSecret, nil
+}
+
+// cancelResponsePayment cancels the payment intent of a response that could not be saved
+func cancelResponsePayment(paymentIntentID string) {
+	if _, err := stripeClient.PaymentIntents.Cancel(paymentIntentID, nil); err != nil {
+		logger.Error("cannot cancel payment intent " + paymentIntentID + ": " + err.Error())
+	}
+}
+
+func markResponsePaid(responseID primitive.ObjectID, paymentIntentID string) error {
+	response, err := getResponse(responseID, false)
+	if err != nil {
+		return err
+	}
+	if response.PaymentIntent != paymentIntentID {
+		return errors.New("payment does not match response")
+	}
+	_, err = responseCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": responseID,
+	}, bson.M{
+		"$set": bson.M{
+			"paid": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = elasticClient.Update().
+		Index(responseElasticIndex).
+		Type(responseElasticType).
+		Id(response.ID).
+		Doc(bson.M{
+			"paid": true,
+		}).
+		Do(ctxElastic)
+	return err
+}
@@ -34,6 +34,10 @@ var responseQueryFields = graphql.Fields{
 			"form": &graphql.ArgumentConfig{
 				Type: graphql.String,
 			},
+			"duplicate": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "only get responses with the given duplicate flag",
+			},
 			"accessKey": &graphql.ArgumentConfig{
 				Type:        graphql.String,
 				Description: "sharable link key",
@@ -140,6 +144,13 @@ var responseQueryFields = graphql.Fields{
 					mainquery := elastic.NewMultiMatchQuery(searchterm, responseSearchFields...)
 					query = query.Filter(mainquery)
 				}
+				if params.Args["duplicate"] != nil {
+					duplicate, ok := params.Args["duplicate"].(bool)
+					if !ok {
+						return nil, errors.New("duplicate could not be cast to boolean")
+					}
+					query = query.Filter(elastic.NewTermQuery("duplicate", duplicate))
+				}
 				searchResult, err := elasticClient.Search().
 					Index(responseElasticIndex).
 					Query(query).
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/olivere/elastic/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var responseStartPath = "response-start-"
+
+// responseStartKind marks start tokens, which anyone opening a form gets, so getTokenData rejects them
+var responseStartKind = "response-start"
+
+type responseStartClaims struct {
+	FormID string `json:"formid"`
+	Kind   string `json:"kind"`
+	jwt.StandardClaims
+}
+
+// ResponseSpamData request data used for bot protection when adding a response
+type ResponseSpamData struct {
+	IP         string
+	Recaptcha  string
+	Honeypot   string
+	StartToken string
+}
+
+func getResponseStartToken(formIDString string) (string, error) {
+	now := time.Now()
+	return signToken(responseStartClaims{
+		formIDString,
+		responseStartKind,
+		jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Duration(tokenExpiration) * time.Hour).Unix(),
+			Issuer:    jwtIssuer,
+		},
+	})
+}
+
+func parseResponseStartToken(startToken string) (*responseStartClaims, error) {
+	claims := &responseStartClaims{}
+	token, err := jwt.ParseWithClaims(startToken, claims, getTokenKey)
+	if err != nil || !token.Valid || claims.Kind != responseStartKind {
+		return nil, errors.New("invalid response start token")
+	}
+	return claims, nil
+}
+
+func checkResponseFillTime(form *Form, startToken string) error {
+	if form.MinFillTime <= 0 {
+		return nil
+	}
+	claims, err := parseResponseStartToken(startToken)
+	if err != nil {
+		return err
+	}
+	if claims.FormID != form.ID {
+		return errors.New("response start token is for a different form")
+	}
+	if claims.IssuedAt == 0 {
+		return errors.New("cannot find start time in token")
+	}
+	if time.Since(time.Unix(claims.IssuedAt, 0)) < time.Duration(form.MinFillTime)*time.Second {
+		return errors.New("response submitted too quickly")
+	}
+	return nil
+}
+
+func recordRejectedResponse(formID primitive.ObjectID) {
+	script := elastic.NewScriptInline("ctx._source.rejectedresponses = (ctx._source.rejectedresponses == null ? 0 : ctx._source.rejectedresponses) + 1")
+	_, err := elasticClient.Update().
+		Index(formElasticIndex).
+		Type(formElasticType).
+		Id(formID.Hex()).
+		Script(script).
+		Do(ctxElastic)
+	if err != nil {
+		logger.Error("cannot count rejected response in elastic: " + err.Error())
+	}
+	_, err = formCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": formID,
+	}, bson.M{
+		"$inc": bson.M{
+			"rejectedresponses": 1,
+		},
+	})
+	if err != nil {
+		logger.Error("cannot count rejected response in mongo: " + err.Error())
+	}
+}
+
+func checkResponseSpam(formID primitive.ObjectID, spamData *ResponseSpamData) error {
+	form, err := getForm(formID, false)
+	if err != nil {
+		return err
+	}
+	if err = checkResponseSpamForm(form, spamData); err != nil {
+		recordRejectedResponse(formID)
+		return err
+	}
+	return nil
+}
+
+func checkResponseSpamForm(form *Form, spamData *ResponseSpamData) error {
+	// bots fill in hidden fields, people do not
+	if len(spamData.Honeypot) > 0 {
+		return errors.New("invalid response submission")
+	}
+	if len(spamData.IP) > 0 {
+		if err := checkRateLimit("response-ip-"+spamData.IP, responseRateLimitIP, time.Minute); err != nil {
+			return err
+		}
+	}
+	if err := checkRateLimit("response-form-"+form.ID, responseRateLimitForm, time.Minute); err != nil {
+		return err
+	}
+	if err := checkResponseFillTime(form, spamData.StartToken); err != nil {
+		return err
+	}
+	if form.Recaptcha {
+		if len(spamData.Recaptcha) == 0 {
+			return errors.New("no recaptcha token provided")
+		}
+		if err := verifyRecaptcha(spamData.Recaptcha, mainRecaptchaSecret); err != nil {
+			return err
+		}
+	}
+	if form.MinFillTime > 0 {
+		if err := useResponseStartToken(spamData.StartToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// useResponseStartToken marks the start token as used, so it only works for one response
+func useResponseStartToken(startToken string) error {
+	claims, err := parseResponseStartToken(startToken)
+	if err != nil {
+		return err
+	}
+	if claims.ExpiresAt == 0 {
+		return errors.New("cannot find expiration in start token")
+	}
+	tokenHash := sha256.Sum256([]byte(startToken))
+	unused, err := redisClient.SetNX(responseStartPath+hex.EncodeToString(tokenHash[:]), true, time.Until(time.Unix(claims.ExpiresAt, 0))).Result()
+	if err != nil {
+		return err
+	}
+	if !unused {
+		return errors.New("response start token was already used")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestResponseStartToken(t *testing.T) {
+	jwtAlgorithm = validJWTAlgorithms[0]
+	jwtSecret = []byte("test")
+	startToken, err := getResponseStartToken("form")
+	if err != nil {
+		t.Fatalf("cannot create start token: %s", err)
+	}
+	claims, err := parseResponseStartToken(startToken)
+	if err != nil {
+		t.Fatalf("cannot parse start token: %s", err)
+	}
+	if claims.FormID != "form" {
+		t.Fatalf("start token is for form %q", claims.FormID)
+	}
+	// anyone opening a public form gets a start token, so it cannot be an authorization token
+	if _, err = getTokenData(startToken); err == nil {
+		t.Fatal("expected the start token to be rejected as an authorization token")
+	}
+	if err = checkResponseFillTime(&Form{ID: "form", MinFillTime: 60}, startToken); err == nil {
+		t.Fatal("expected a response right after the start to be rejected")
+	}
+	if err = checkResponseFillTime(&Form{ID: "other", MinFillTime: 1}, startToken); err == nil {
+		t.Fatal("expected a start token for another form to be rejected")
+	}
+	loginToken, err := signToken(jwt.MapClaims{
+		"id":     "user",
+		"formid": "form",
+		"iat":    time.Now().Add(-time.Hour).Unix(),
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("cannot create login token: %s", err)
+	}
+	if _, err = parseResponseStartToken(loginToken); err == nil {
+		t.Fatal("expected a token without the start kind to be rejected")
+	}
+}
@@ -0,0 +1,868 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// saml single sign-on for organizations. an organization proves it owns an email domain with a
+// dns txt record, and then its identity provider logs in every user of the domain. users are
+// created and added to the organization on their first login. when sso is required, users of
+// the domain cannot log in with a password or another provider.
+
+var samlRequestPath = "saml-request-"
+
+var samlAssertionPath = "saml-assertion-"
+
+var samlProtocolNamespace = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+var samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+var samlSuccessStatus = "urn:oasis:names:tc:SAML:2.0:status:Success"
+
+var samlBearerMethod = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+var samlEmailFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+var samlPostBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+var samlEmailAttributes = []string{
+	"email",
+	"mail",
+	"emailaddress",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	"urn:oid:0.9.2342.19200300.100.1.3",
+}
+
+// OrgSSO saml settings of an organization
+type OrgSSO struct {
+	Enabled           bool         `json:"enabled"`
+	Required          bool         `json:"required"`
+	IDPEntityID       string       `json:"idpentityid"`
+	IDPSSOURL         string       `json:"idpssourl"`
+	IDPCertificate    string       `json:"idpcertificate"`
+	AllowIDPInitiated bool         `json:"allowidpinitiated"`
+	DefaultRole       string       `json:"defaultrole"`
+	Domains           []*SSODomain `json:"domains"`
+}
+
+// SSODomain email domain of an organization
+type SSODomain struct {
+	Domain   string `json:"domain"`
+	Token    string `json:"token"`
+	Verified bool   `json:"verified"`
+}
+
+// SSODomainType graphql sso domain object
+var SSODomainType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "SSODomain",
+	Fields: graphql.Fields{
+		"domain": &graphql.Field{
+			Type: graphql.String,
+		},
+		"token": &graphql.Field{
+			Type:        graphql.String,
+			Description: "value of the dns txt record that verifies the domain",
+		},
+		"verified": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+	},
+})
+
+// OrgSSOType graphql organization sso object
+var OrgSSOType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "OrgSSO",
+	Fields: graphql.Fields{
+		"enabled": &graphql.Field{
+			Type: graphql.Boolean,
+		},
+		"required": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "users of the domains cannot log in with a password",
+		},
+		"idpentityid": &graphql.Field{
+			Type: graphql.String,
+		},
+		"idpssourl": &graphql.Field{
+			Type:        graphql.String,
+			Description: "single sign-on url of the identity provider for the redirect binding",
+		},
+		"idpcertificate": &graphql.Field{
+			Type: graphql.String,
+		},
+		"allowidpinitiated": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "accept logins started at the identity provider",
+		},
+		"defaultrole": &graphql.Field{
+			Type:        graphql.String,
+			Description: "role of users added on their first login",
+		},
+		"domains": &graphql.Field{
+			Type: graphql.NewList(SSODomainType),
+		},
+	},
+})
+
+type samlAssertionData struct {
+	ID           string
+	Email        string
+	InResponseTo string
+	Expires      time.Time
+}
+
+func getSAMLEntityID(orgIDString string) string {
+	return apiURL + "/samlMetadata/" + orgIDString
+}
+
+func getSAMLACSURL(orgIDString string) string {
+	return apiURL + "/samlACS/" + orgIDString
+}
+
+func getEmailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// getSSOOrg returns the organization that verified the domain of the email, or nil
+func getSSOOrg(email string) (*Org, error) {
+	var orgData struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err := orgCollection.FindOne(ctxMongo, bson.M{
+		"sso.domains": bson.M{
+			"$elemMatch": bson.M{
+				"domain":   getEmailDomain(email),
+				"verified": true,
+			},
+		},
+	}, options.FindOne().SetProjection(bson.M{
+		"_id": 1,
+	})).Decode(&orgData)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return getOrg(orgData.ID, false)
+}
+
+// checkSSORequired returns an error if the organization of the email only allows sso logins
+func checkSSORequired(email string) error {
+	org, err := getSSOOrg(email)
+	if err != nil {
+		return err
+	}
+	if org != nil && org.SSO.Enabled && org.SSO.Required {
+		return errors.New("your organization requires single sign-on")
+	}
+	return nil
+}
+
+func generateSAMLID() (string, error) {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(id), nil
+}
+
+func escapeSAMLAttr(value string) string {
+	return xmlAttrEscaper.Replace(value)
+}
+
+func parseSAMLTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339, value)
+}
+
+// checkSAMLTimes returns an error if now is not between the optional not before and not on or after
+func checkSAMLTimes(node *xmlNode) error {
+	now := time.Now()
+	skew := time.Duration(samlClockSkew) * time.Second
+	if notBefore := getXMLAttr(node, "NotBefore"); len(notBefore) > 0 {
+		notBeforeTime, err := parseSAMLTime(notBefore)
+		if err != nil {
+			return err
+		}
+		if now.Add(skew).Before(notBeforeTime) {
+			return errors.New("assertion is not valid yet")
+		}
+	}
+	if notOnOrAfter := getXMLAttr(node, "NotOnOrAfter"); len(notOnOrAfter) > 0 {
+		notOnOrAfterTime, err := parseSAMLTime(notOnOrAfter)
+		if err != nil {
+			return err
+		}
+		if !now.Add(-skew).Before(notOnOrAfterTime) {
+			return errors.New("assertion has expired")
+		}
+	}
+	return nil
+}
+
+// parseSAMLResponse verifies the response of the identity provider and returns the assertion
+func parseSAMLResponse(org *Org, encodedResponse string) (*samlAssertionData, error) {
+	if len(encodedResponse) > samlMaxResponseSize {
+		return nil, errors.New("saml response is too large")
+	}
+	responseXML, err := decodeXMLBase64(encodedResponse)
+	if err != nil {
+		return nil, errors.New("saml response is not base64")
+	}
+	response, err := parseXMLTree(responseXML)
+	if err != nil {
+		return nil, err
+	}
+	if response.Space != samlProtocolNamespace || response.Local != "Response" {
+		return nil, errors.New("document is not a saml response")
+	}
+	acsURL := getSAMLACSURL(org.ID)
+	if destination := getXMLAttr(response, "Destination"); len(destination) > 0 && destination != acsURL {
+		return nil, errors.New("saml response is for another destination")
+	}
+	statusCode := getXMLChild(getXMLChild(response, samlProtocolNamespace, "Status"), samlProtocolNamespace, "StatusCode")
+	if statusCode == nil || getXMLAttr(statusCode, "Value") != samlSuccessStatus {
+		return nil, errors.New("identity provider did not log the user in")
+	}
+	certificate, err := parseSAMLCertificate(org.SSO.IDPCertificate)
+	if err != nil {
+		return nil, err
+	}
+	var responseSigned = false
+	if hasXMLSignature(response) {
+		if err = verifyXMLSignature(response, certificate); err != nil {
+			return nil, err
+		}
+		responseSigned = true
+	}
+	if issuer := getXMLChild(response, samlAssertionNamespace, "Issuer"); issuer != nil && getXMLText(issuer) != org.SSO.IDPEntityID {
+		return nil, errors.New("saml response is from another identity provider")
+	}
+	if len(getXMLChildren(response, samlAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := getXMLChildren(response, samlAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("saml response needs exactly one assertion")
+	}
+	assertion := assertions[0]
+	if hasXMLSignature(assertion) || !responseSigned {
+		if err = verifyXMLSignature(assertion, certificate); err != nil {
+			return nil, err
+		}
+	}
+	if getXMLText(getXMLChild(assertion, samlAssertionNamespace, "Issuer")) != org.SSO.IDPEntityID {
+		return nil, errors.New("assertion is from another identity provider")
+	}
+	conditions := getXMLChild(assertion, samlAssertionNamespace, "Conditions")
+	if conditions == nil {
+		return nil, errors.New("assertion has no conditions")
+	}
+	if err = checkSAMLTimes(conditions); err != nil {
+		return nil, err
+	}
+	audienceRestrictions := getXMLChildren(conditions, samlAssertionNamespace, "AudienceRestriction")
+	if len(audienceRestrictions) == 0 {
+		return nil, errors.New("assertion has no audience")
+	}
+	for _, audienceRestriction := range audienceRestrictions {
+		var foundAudience = false
+		for _, audience := range getXMLChildren(audienceRestriction, samlAssertionNamespace, "Audience") {
+			if getXMLText(audience) == getSAMLEntityID(org.ID) {
+				foundAudience = true
+				break
+			}
+		}
+		if !foundAudience {
+			return nil, errors.New("assertion is for another service")
+		}
+	}
+	assertionData := &samlAssertionData{
+		ID:           getXMLAttr(assertion, "ID"),
+		InResponseTo: getXMLAttr(response, "InResponseTo"),
+	}
+	subject := getXMLChild(assertion, samlAssertionNamespace, "Subject")
+	var foundConfirmation = false
+	for _, confirmation := range getXMLChildren(subject, samlAssertionNamespace, "SubjectConfirmation") {
+		confirmationData := getXMLChild(confirmation, samlAssertionNamespace, "SubjectConfirmationData")
+		if getXMLAttr(confirmation, "Method") != samlBearerMethod || confirmationData == nil {
+			continue
+		}
+		if getXMLAttr(confirmationData, "Recipient") != acsURL || len(getXMLAttr(confirmationData, "NotBefore")) > 0 {
+			continue
+		}
+		notOnOrAfter, err := parseSAMLTime(getXMLAttr(confirmationData, "NotOnOrAfter"))
+		if err != nil || checkSAMLTimes(confirmationData) != nil {
+			continue
+		}
+		if inResponseTo := getXMLAttr(confirmationData, "InResponseTo"); inResponseTo != assertionData.InResponseTo {
+			continue
+		}
+		assertionData.Expires = notOnOrAfter
+		foundConfirmation = true
+		break
+	}
+	if !foundConfirmation {
+		return nil, errors.New("assertion has no valid bearer confirmation")
+	}
+	nameID := getXMLChild(subject, samlAssertionNamespace, "NameID")
+	if nameID != nil && getXMLAttr(nameID, "Format") == samlEmailFormat {
+		assertionData.Email = getXMLText(nameID)
+	}
+	if len(assertionData.Email) == 0 {
+		for _, attributeStatement := range getXMLChildren(assertion, samlAssertionNamespace, "AttributeStatement") {
+			for _, attribute := range getXMLChildren(attributeStatement, samlAssertionNamespace, "Attribute") {
+				if findInArray(strings.ToLower(getXMLAttr(attribute, "Name")), samlEmailAttributes) {
+					assertionData.Email = getXMLText(getXMLChild(attribute, samlAssertionNamespace, "AttributeValue"))
+					break
+				}
+			}
+		}
+	}
+	if !strings.Contains(assertionData.Email, "@") {
+		return nil, errors.New("assertion has no email")
+	}
+	if len(assertionData.ID) == 0 {
+		return nil, errors.New("assertion has no id")
+	}
+	return assertionData, nil
+}
+
+// provisionSSOUser returns the account for the email, creating it and adding it to the
+// organization if needed
+func provisionSSOUser(org *Org, email string, ip string) (primitive.ObjectID, error) {
+	var account struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		EmailVerified bool               `bson:"emailverified"`
+	}
+	err := userCollection.FindOne(ctxMongo, bson.M{
+		"email": email,
+	}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		account.ID, err = createAccount(email, "", true)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		recordAudit(auditRegisterAction, account.ID.Hex(), userType, account.ID.Hex(), account.ID.Hex(), ip, bson.M{
+			"email":    email,
+			"provider": samlProviderName,
+			"org":      org.ID,
+		})
+		logger.Info("User register",
+			zap.String("id", account.ID.Hex()),
+			zap.String("email", email),
+			zap.String("org", org.ID),
+		)
+	} else if err != nil {
+		return primitive.NilObjectID, err
+	} else if !account.EmailVerified {
+		// the organization owns the domain, so the password of whoever registered the email is not kept
+		_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+			"_id": account.ID,
+		}, bson.M{
+			"$set": bson.M{
+				"emailverified": true,
+				"password":      "",
+			},
+		})
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+	}
+	if len(getOrgMemberRole(org, account.ID.Hex())) > 0 {
+		return account.ID, nil
+	}
+	role := org.SSO.DefaultRole
+	if !findInArray(role, validOrgRoles) || role == orgOwnerRole {
+		role = validOrgRoles[2]
+	}
+	orgID, err := primitive.ObjectIDFromHex(org.ID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	_, err = orgCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": orgID,
+		"members.id": bson.M{
+			"$ne": account.ID.Hex(),
+		},
+	}, bson.M{
+		"$push": bson.M{
+			"members": &OrgMember{
+				ID:   account.ID.Hex(),
+				Role: role,
+			},
+		},
+		"$set": bson.M{
+			"updated": time.Now().Unix(),
+		},
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return account.ID, nil
+}
+
+func getSSOOrgArg(c *gin.Context) (*Org, error) {
+	orgID, err := primitive.ObjectIDFromHex(c.Param("org"))
+	if err != nil {
+		return nil, errors.New("invalid organization")
+	}
+	org, err := getOrg(orgID, false)
+	if err != nil {
+		return nil, errors.New("cannot find organization")
+	}
+	if org.SSO == nil || !org.SSO.Enabled {
+		return nil, errors.New("single sign-on is not enabled for the organization")
+	}
+	return org, nil
+}
+
+/**
+ * @api {get} /samlMetadata/:org Service provider metadata of an organization
+ * @apiVersion 0.0.1
+ * @apiSuccess {String} metadata Saml metadata xml for the identity provider
+ * @apiGroup authentication
+ */
+func samlMetadata(c *gin.Context) {
+	orgID, err := primitive.ObjectIDFromHex(c.Param("org"))
+	if err != nil {
+		handleError("invalid organization", http.StatusBadRequest, c.Writer)
+		return
+	}
+	orgIDString := orgID.Hex()
+	c.Writer.Header().Set("Content-Type", "application/samlmetadata+xml")
+	c.Writer.Write([]byte(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="` + escapeSAMLAttr(getSAMLEntityID(orgIDString)) + `">` +
+		`<md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="` + samlProtocolNamespace + `">` +
+		`<md:NameIDFormat>` + samlEmailFormat + `</md:NameIDFormat>` +
+		`<md:AssertionConsumerService Binding="` + samlPostBinding + `" Location="` + escapeSAMLAttr(getSAMLACSURL(orgIDString)) + `" index="0" isDefault="true"/>` +
+		`</md:SPSSODescriptor></md:EntityDescriptor>`))
+}
+
+/**
+ * @api {get} /samlLogin Start single sign-on
+ * @apiVersion 0.0.1
+ * @apiParam {String} email User email, to find the organization of the domain
+ * @apiParam {String} org Organization id, instead of the email
+ * @apiParam {String} redirect Website path to go to after login
+ * @apiSuccess (302) Redirect to the identity provider
+ * @apiGroup authentication
+ */
+func samlLogin(c *gin.Context) {
+	response := c.Writer
+	redirect := c.Query("redirect")
+	if !isWebsitePath(redirect) {
+		handleError("redirect has to be a website path", http.StatusBadRequest, response)
+		return
+	}
+	var org *Org
+	var err error
+	if email := c.Query("email"); len(email) > 0 {
+		org, err = getSSOOrg(email)
+		if err != nil {
+			handleError(err.Error(), http.StatusBadRequest, response)
+			return
+		}
+	} else if orgID, err := primitive.ObjectIDFromHex(c.Query("org")); err == nil {
+		org, _ = getOrg(orgID, false)
+	}
+	if org == nil || org.SSO == nil || !org.SSO.Enabled {
+		handleError("single sign-on is not enabled for this organization", http.StatusBadRequest, response)
+		return
+	}
+	requestID, err := generateSAMLID()
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	err = redisClient.Set(samlRequestPath+requestID, org.ID, time.Duration(samlRequestExpiration)*time.Minute).Err()
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	authnRequest := `<samlp:AuthnRequest xmlns:samlp="` + samlProtocolNamespace + `" xmlns:saml="` + samlAssertionNamespace + `"` +
+		` ID="` + requestID + `" Version="2.0" IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `"` +
+		` Destination="` + escapeSAMLAttr(org.SSO.IDPSSOURL) + `"` +
+		` AssertionConsumerServiceURL="` + escapeSAMLAttr(getSAMLACSURL(org.ID)) + `" ProtocolBinding="` + samlPostBinding + `">` +
+		`<saml:Issuer>` + escapeSAMLAttr(getSAMLEntityID(org.ID)) + `</saml:Issuer>` +
+		`<samlp:NameIDPolicy Format="` + samlEmailFormat + `" AllowCreate="true"/>` +
+		`</samlp:AuthnRequest>`
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	writer.Write([]byte(authnRequest))
+	writer.Close()
+	ssoURL, err := url.Parse(org.SSO.IDPSSOURL)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	query := ssoURL.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(compressed.Bytes()))
+	if len(redirect) > 0 {
+		query.Set("RelayState", redirect)
+	}
+	ssoURL.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, ssoURL.String())
+}
+
+/**
+ * @api {post} /samlACS/:org Finish single sign-on
+ * @apiVersion 0.0.1
+ * @apiParam {String} SAMLResponse Response of the identity provider
+ * @apiParam {String} RelayState Website path to go to after login
+ * @apiSuccess (302) Redirect to the website /login/saml, with token, refreshToken and redirect, or
+ * totpRequired and totpToken for /loginTOTP, or error in the fragment
+ * @apiGroup authentication
+ */
+func samlACS(c *gin.Context) {
+	org, err := getSSOOrgArg(c)
+	if err != nil {
+		redirectExternalLoginError(c, samlWebsitePath, err.Error())
+		return
+	}
+	assertionData, err := parseSAMLResponse(org, c.PostForm("SAMLResponse"))
+	if err != nil {
+		redirectExternalLoginError(c, samlWebsitePath, err.Error())
+		return
+	}
+	if len(assertionData.InResponseTo) > 0 {
+		requestOrg, err := redisClient.Get(samlRequestPath + assertionData.InResponseTo).Result()
+		if err != nil || requestOrg != org.ID {
+			redirectExternalLoginError(c, samlWebsitePath, "login attempt expired")
+			return
+		}
+		if deleted, err := redisClient.Del(samlRequestPath + assertionData.InResponseTo).Result(); err != nil || deleted == 0 {
+			redirectExternalLoginError(c, samlWebsitePath, "login attempt expired")
+			return
+		}
+	} else if !org.SSO.AllowIDPInitiated {
+		redirectExternalLoginError(c, samlWebsitePath, "start the login from the website")
+		return
+	}
+	// assertions can only be used once while they are valid
+	firstUse, err := redisClient.SetNX(samlAssertionPath+org.ID+"-"+assertionData.ID, 1, time.Until(assertionData.Expires)+time.Duration(samlClockSkew)*time.Second).Result()
+	if err != nil || !firstUse {
+		redirectExternalLoginError(c, samlWebsitePath, "assertion was already used")
+		return
+	}
+	var foundDomain = false
+	emailDomain := getEmailDomain(assertionData.Email)
+	for _, domain := range org.SSO.Domains {
+		if domain.Verified && domain.Domain == emailDomain {
+			foundDomain = true
+			break
+		}
+	}
+	if !foundDomain {
+		redirectExternalLoginError(c, samlWebsitePath, "email domain does not belong to the organization")
+		return
+	}
+	userID, err := provisionSSOUser(org, assertionData.Email, c.ClientIP())
+	if err != nil {
+		redirectExternalLoginError(c, samlWebsitePath, err.Error())
+		return
+	}
+	redirect := c.PostForm("RelayState")
+	if !isWebsitePath(redirect) {
+		redirect = ""
+	}
+	finishExternalLogin(c, samlWebsitePath, userID, samlProviderName, redirect)
+}
+
+func getSSOArgs(params graphql.ResolveParams) (*Org, string, error) {
+	orgIDString, userIDString, err := getOrgArgs(params)
+	if err != nil {
+		return nil, "", err
+	}
+	org, err := checkOrgAccess(orgIDString, userIDString, orgManageRoles)
+	if err != nil {
+		return nil, "", err
+	}
+	if org.SSO == nil {
+		org.SSO = &OrgSSO{
+			Domains: []*SSODomain{},
+		}
+	}
+	return org, userIDString, nil
+}
+
+func getSSODomainArg(params graphql.ResolveParams) (string, error) {
+	if params.Args["domain"] == nil {
+		return "", errors.New("domain not provided")
+	}
+	domain, ok := params.Args["domain"].(string)
+	if !ok {
+		return "", errors.New("cannot cast domain to string")
+	}
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@/ ") {
+		return "", errors.New("invalid domain given")
+	}
+	return domain, nil
+}
+
+func setOrgSSO(org *Org, userIDString string, ip string) error {
+	orgID, err := primitive.ObjectIDFromHex(org.ID)
+	if err != nil {
+		return err
+	}
+	org.Updated = time.Now().Unix()
+	_, err = orgCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": orgID,
+	}, bson.M{
+		"$set": bson.M{
+			"sso":     org.SSO,
+			"updated": org.Updated,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	recordAudit(auditSSOChangeAction, userIDString, orgType, org.ID, org.Owner, ip, bson.M{
+		"enabled":  org.SSO.Enabled,
+		"required": org.SSO.Required,
+		"entityid": org.SSO.IDPEntityID,
+		"domains":  org.SSO.Domains,
+	})
+	return nil
+}
+
+var samlMutationFields = graphql.Fields{
+	"updateOrgSSO": &graphql.Field{
+		Type:        OrgType,
+		Description: "Change the single sign-on settings of an organization",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"enabled": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"required": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "block password logins for the domains",
+			},
+			"idpentityid": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"idpssourl": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"idpcertificate": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "pem or base64 signing certificate",
+			},
+			"allowidpinitiated": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"defaultrole": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			org, userIDString, err := getSSOArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			for _, argName := range []string{"enabled", "required", "allowidpinitiated"} {
+				if params.Args[argName] == nil {
+					continue
+				}
+				argValue, ok := params.Args[argName].(bool)
+				if !ok {
+					return nil, errors.New("cannot cast " + argName + " to bool")
+				}
+				switch argName {
+				case "enabled":
+					org.SSO.Enabled = argValue
+				case "required":
+					org.SSO.Required = argValue
+				case "allowidpinitiated":
+					org.SSO.AllowIDPInitiated = argValue
+				}
+			}
+			for _, argName := range []string{"idpentityid", "idpssourl", "idpcertificate", "defaultrole"} {
+				if params.Args[argName] == nil {
+					continue
+				}
+				argValue, ok := params.Args[argName].(string)
+				if !ok {
+					return nil, errors.New("cannot cast " + argName + " to string")
+				}
+				switch argName {
+				case "idpentityid":
+					org.SSO.IDPEntityID = argValue
+				case "idpssourl":
+					ssoURL, err := url.Parse(argValue)
+					if err != nil || (ssoURL.Scheme != "https" && ssoURL.Scheme != "http") {
+						return nil, errors.New("invalid identity provider url")
+					}
+					org.SSO.IDPSSOURL = argValue
+				case "idpcertificate":
+					if _, err := parseSAMLCertificate(argValue); err != nil {
+						return nil, err
+					}
+					org.SSO.IDPCertificate = argValue
+				case "defaultrole":
+					if !findInArray(argValue, validOrgRoles) || argValue == orgOwnerRole {
+						return nil, errors.New("invalid role given")
+					}
+					org.SSO.DefaultRole = argValue
+				}
+			}
+			if org.SSO.Enabled && (len(org.SSO.IDPEntityID) == 0 || len(org.SSO.IDPSSOURL) == 0 || len(org.SSO.IDPCertificate) == 0) {
+				return nil, errors.New("identity provider entity id, url and certificate are needed for single sign-on")
+			}
+			if err = setOrgSSO(org, userIDString, getParamsIP(params)); err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+	"addOrgSSODomain": &graphql.Field{
+		Type:        OrgType,
+		Description: "Add an email domain to verify with a dns txt record",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"domain": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			org, userIDString, err := getSSOArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			domain, err := getSSODomainArg(params)
+			if err != nil {
+				return nil, err
+			}
+			for _, ssoDomain := range org.SSO.Domains {
+				if ssoDomain.Domain == domain {
+					return nil, errors.New("domain was already added")
+				}
+			}
+			token := make([]byte, 16)
+			if _, err = rand.Read(token); err != nil {
+				return nil, err
+			}
+			org.SSO.Domains = append(org.SSO.Domains, &SSODomain{
+				Domain: domain,
+				Token:  samlDomainTokenPrefix + hex.EncodeToString(token),
+			})
+			if err = setOrgSSO(org, userIDString, getParamsIP(params)); err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+	"verifyOrgSSODomain": &graphql.Field{
+		Type:        OrgType,
+		Description: "Check the dns txt record of a domain",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"domain": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			org, userIDString, err := getSSOArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			domain, err := getSSODomainArg(params)
+			if err != nil {
+				return nil, err
+			}
+			var ssoDomain *SSODomain
+			for _, currentDomain := range org.SSO.Domains {
+				if currentDomain.Domain == domain {
+					ssoDomain = currentDomain
+					break
+				}
+			}
+			if ssoDomain == nil {
+				return nil, errors.New("domain was not added")
+			}
+			domainOrg, err := getSSOOrg("@" + domain)
+			if err != nil {
+				return nil, err
+			}
+			if domainOrg != nil && domainOrg.ID != org.ID {
+				return nil, errors.New("domain belongs to another organization")
+			}
+			records, err := net.LookupTXT(samlDomainRecordPrefix + domain)
+			if err != nil {
+				return nil, errors.New("cannot find txt record " + samlDomainRecordPrefix + domain)
+			}
+			if !findInArray(ssoDomain.Token, records) {
+				return nil, errors.New("txt record does not have the token")
+			}
+			ssoDomain.Verified = true
+			if err = setOrgSSO(org, userIDString, getParamsIP(params)); err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+	"removeOrgSSODomain": &graphql.Field{
+		Type:        OrgType,
+		Description: "Remove an email domain from single sign-on",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"domain": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			org, userIDString, err := getSSOArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			domain, err := getSSODomainArg(params)
+			if err != nil {
+				return nil, err
+			}
+			domains := []*SSODomain{}
+			for _, ssoDomain := range org.SSO.Domains {
+				if ssoDomain.Domain != domain {
+					domains = append(domains, ssoDomain)
+				}
+			}
+			if len(domains) == len(org.SSO.Domains) {
+				return nil, errors.New("domain was not added")
+			}
+			org.SSO.Domains = domains
+			if err = setOrgSSO(org, userIDString, getParamsIP(params)); err != nil {
+				return nil, err
+			}
+			return org, nil
+		},
+	},
+}
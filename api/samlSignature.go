@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// xml signature checks for saml responses. only what identity providers use in practice is
+// supported: enveloped signatures with exclusive canonicalization, rsa keys and sha256 or sha512.
+// the document is kept as a tree with the original prefixes, which encoding/xml does not do.
+// the key always comes from the organization settings, never from the document.
+
+var xmlNamespaceURI = "http://www.w3.org/XML/1998/namespace"
+
+var xmlDSigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+var xmlExcC14NAlgorithm = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+var xmlEnvelopedSignatureAlgorithm = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+var xmlSignatureAlgorithms = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512": crypto.SHA512,
+}
+
+var xmlDigestAlgorithms = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+// xmlNode element or text in a parsed document. text nodes have no local name
+type xmlNode struct {
+	Prefix   string
+	Local    string
+	Space    string
+	Attrs    []xml.Attr
+	Text     string
+	Children []*xmlNode
+	Parent   *xmlNode
+}
+
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	var current *xmlNode
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, errors.New("xml has more than one root element")
+			}
+			node := &xmlNode{
+				Prefix: token.Name.Space,
+				Local:  token.Name.Local,
+				Attrs:  token.Copy().Attr,
+				Parent: current,
+			}
+			node.Space = lookupXMLNamespace(node, node.Prefix)
+			if len(node.Prefix) > 0 && len(node.Space) == 0 {
+				return nil, errors.New("undeclared xml prefix " + node.Prefix)
+			}
+			if current == nil {
+				root = node
+			} else {
+				current.Children = append(current.Children, node)
+			}
+			current = node
+		case xml.EndElement:
+			if current == nil || current.Prefix != token.Name.Space || current.Local != token.Name.Local {
+				return nil, errors.New("unexpected xml end element " + token.Name.Local)
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, &xmlNode{
+					Text:   string(token),
+					Parent: current,
+				})
+			}
+		case xml.Directive:
+			return nil, errors.New("xml directives are not allowed")
+		}
+	}
+	if root == nil || current != nil {
+		return nil, errors.New("incomplete xml document")
+	}
+	return root, nil
+}
+
+// lookupXMLNamespace returns the namespace the prefix is bound to at the node
+func lookupXMLNamespace(node *xmlNode, prefix string) string {
+	if prefix == "xml" {
+		return xmlNamespaceURI
+	}
+	for ; node != nil; node = node.Parent {
+		for _, attr := range node.Attrs {
+			if (len(prefix) == 0 && len(attr.Name.Space) == 0 && attr.Name.Local == "xmlns") ||
+				(len(prefix) > 0 && attr.Name.Space == "xmlns" && attr.Name.Local == prefix) {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+func getXMLAttr(node *xmlNode, name string) string {
+	for _, attr := range node.Attrs {
+		if len(attr.Name.Space) == 0 && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func getXMLChildren(node *xmlNode, space string, local string) []*xmlNode {
+	children := []*xmlNode{}
+	for _, child := range node.Children {
+		if child.Local == local && child.Space == space {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// getXMLChild returns the first child element with the name, or nil
+func getXMLChild(node *xmlNode, space string, local string) *xmlNode {
+	if node == nil {
+		return nil
+	}
+	for _, child := range node.Children {
+		if child.Local == local && child.Space == space {
+			return child
+		}
+	}
+	return nil
+}
+
+func getXMLText(node *xmlNode) string {
+	if node == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, child := range node.Children {
+		if len(child.Local) == 0 {
+			text.WriteString(child.Text)
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+var xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func isXMLNamespaceAttr(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || (len(attr.Name.Space) == 0 && attr.Name.Local == "xmlns")
+}
+
+// writeXMLExcC14N writes the exclusive canonical form of the node. rendered has the namespaces
+// already declared by output ancestors, skip is left out (the enveloped signature)
+func writeXMLExcC14N(buffer *bytes.Buffer, node *xmlNode, rendered map[string]string, inclusivePrefixes []string, skip *xmlNode) {
+	if node == skip {
+		return
+	}
+	if len(node.Local) == 0 {
+		buffer.WriteString(xmlTextEscaper.Replace(node.Text))
+		return
+	}
+	utilized := []string{node.Prefix}
+	for _, attr := range node.Attrs {
+		if !isXMLNamespaceAttr(attr) && len(attr.Name.Space) > 0 && attr.Name.Space != "xml" {
+			utilized = append(utilized, attr.Name.Space)
+		}
+	}
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if len(lookupXMLNamespace(node, prefix)) > 0 {
+			utilized = append(utilized, prefix)
+		}
+	}
+	nodeRendered := map[string]string{}
+	for prefix, space := range rendered {
+		nodeRendered[prefix] = space
+	}
+	declarations := []string{}
+	for _, prefix := range utilized {
+		space := lookupXMLNamespace(node, prefix)
+		renderedSpace, isRendered := nodeRendered[prefix]
+		if (isRendered && renderedSpace == space) || (!isRendered && len(space) == 0) {
+			continue
+		}
+		nodeRendered[prefix] = space
+		declarations = append(declarations, prefix)
+	}
+	sort.Strings(declarations)
+	attrs := []xml.Attr{}
+	for _, attr := range node.Attrs {
+		if !isXMLNamespaceAttr(attr) {
+			attrs = append(attrs, attr)
+		}
+	}
+	attrSpace := func(attr xml.Attr) string {
+		if len(attr.Name.Space) == 0 {
+			return ""
+		}
+		return lookupXMLNamespace(node, attr.Name.Space)
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrSpace(attrs[i]) != attrSpace(attrs[j]) {
+			return attrSpace(attrs[i]) < attrSpace(attrs[j])
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+	name := node.Local
+	if len(node.Prefix) > 0 {
+		name = node.Prefix + ":" + node.Local
+	}
+	buffer.WriteString("<" + name)
+	for _, prefix := range declarations {
+		if len(prefix) == 0 {
+			buffer.WriteString(` xmlns="` + xmlAttrEscaper.Replace(nodeRendered[prefix]) + `"`)
+		} else {
+			buffer.WriteString(` xmlns:` + prefix + `="` + xmlAttrEscaper.Replace(nodeRendered[prefix]) + `"`)
+		}
+	}
+	for _, attr := range attrs {
+		attrName := attr.Name.Local
+		if len(attr.Name.Space) > 0 {
+			attrName = attr.Name.Space + ":" + attr.Name.Local
+		}
+		buffer.WriteString(" " + attrName + `="` + xmlAttrEscaper.Replace(attr.Value) + `"`)
+	}
+	buffer.WriteString(">")
+	for _, child := range node.Children {
+		writeXMLExcC14N(buffer, child, nodeRendered, inclusivePrefixes, skip)
+	}
+	buffer.WriteString("</" + name + ">")
+}
+
+// getXMLInclusivePrefixes returns the prefix list of an exclusive canonicalization method
+func getXMLInclusivePrefixes(method *xmlNode) []string {
+	inclusiveNamespaces := getXMLChild(method, xmlExcC14NAlgorithm, "InclusiveNamespaces")
+	if inclusiveNamespaces == nil {
+		return nil
+	}
+	return strings.Fields(getXMLAttr(inclusiveNamespaces, "PrefixList"))
+}
+
+func decodeXMLBase64(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+}
+
+// parseSAMLCertificate reads a pem or base64 der certificate with an rsa key
+func parseSAMLCertificate(certificate string) (*x509.Certificate, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		der = block.Bytes
+	} else {
+		var err error
+		der, err = decodeXMLBase64(certificate)
+		if err != nil {
+			return nil, errors.New("certificate is not pem or base64")
+		}
+	}
+	parsedCertificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := parsedCertificate.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, errors.New("certificate does not have an rsa key")
+	}
+	return parsedCertificate, nil
+}
+
+// hasXMLSignature returns true if the element has an enveloped signature
+func hasXMLSignature(node *xmlNode) bool {
+	return len(getXMLChildren(node, xmlDSigNamespace, "Signature")) > 0
+}
+
+// verifyXMLSignature checks the enveloped signature of the element. the signature has to be a
+// direct child that references the element itself, so no other part of the document is trusted
+func verifyXMLSignature(node *xmlNode, certificate *x509.Certificate) error {
+	signatures := getXMLChildren(node, xmlDSigNamespace, "Signature")
+	if len(signatures) != 1 {
+		return errors.New("element needs exactly one signature")
+	}
+	signature := signatures[0]
+	id := getXMLAttr(node, "ID")
+	if len(id) == 0 {
+		return errors.New("signed element has no id")
+	}
+	signedInfo := getXMLChild(signature, xmlDSigNamespace, "SignedInfo")
+	if signedInfo == nil {
+		return errors.New("signature has no signed info")
+	}
+	canonicalizationMethod := getXMLChild(signedInfo, xmlDSigNamespace, "CanonicalizationMethod")
+	if canonicalizationMethod == nil || getXMLAttr(canonicalizationMethod, "Algorithm") != xmlExcC14NAlgorithm {
+		return errors.New("unsupported canonicalization method")
+	}
+	signatureMethod := getXMLChild(signedInfo, xmlDSigNamespace, "SignatureMethod")
+	if signatureMethod == nil {
+		return errors.New("signature has no signature method")
+	}
+	signatureHash, ok := xmlSignatureAlgorithms[getXMLAttr(signatureMethod, "Algorithm")]
+	if !ok {
+		return errors.New("unsupported signature method")
+	}
+	references := getXMLChildren(signedInfo, xmlDSigNamespace, "Reference")
+	if len(references) != 1 || getXMLAttr(references[0], "URI") != "#"+id {
+		return errors.New("signature does not reference the signed element")
+	}
+	reference := references[0]
+	var foundEnveloped = false
+	var referencePrefixes []string
+	var foundCanonicalization = false
+	for _, transform := range getXMLChildren(getXMLChild(reference, xmlDSigNamespace, "Transforms"), xmlDSigNamespace, "Transform") {
+		switch getXMLAttr(transform, "Algorithm") {
+		case xmlEnvelopedSignatureAlgorithm:
+			foundEnveloped = true
+		case xmlExcC14NAlgorithm:
+			foundCanonicalization = true
+			referencePrefixes = getXMLInclusivePrefixes(transform)
+		default:
+			return errors.New("unsupported signature transform")
+		}
+	}
+	if !foundEnveloped || !foundCanonicalization {
+		return errors.New("signature needs enveloped and exclusive canonicalization transforms")
+	}
+	digestMethod := getXMLChild(reference, xmlDSigNamespace, "DigestMethod")
+	if digestMethod == nil {
+		return errors.New("signature has no digest method")
+	}
+	digestHash, ok := xmlDigestAlgorithms[getXMLAttr(digestMethod, "Algorithm")]
+	if !ok {
+		return errors.New("unsupported digest method")
+	}
+	expectedDigest, err := decodeXMLBase64(getXMLText(getXMLChild(reference, xmlDSigNamespace, "DigestValue")))
+	if err != nil {
+		return errors.New("invalid digest value")
+	}
+	var canonical bytes.Buffer
+	writeXMLExcC14N(&canonical, node, map[string]string{}, referencePrefixes, signature)
+	digest := digestHash.New()
+	digest.Write(canonical.Bytes())
+	if subtle.ConstantTimeCompare(digest.Sum(nil), expectedDigest) != 1 {
+		return errors.New("digest of signed element does not match")
+	}
+	signatureValue, err := decodeXMLBase64(getXMLText(getXMLChild(signature, xmlDSigNamespace, "SignatureValue")))
+	if err != nil {
+		return errors.New("invalid signature value")
+	}
+	canonical.Reset()
+	writeXMLExcC14N(&canonical, signedInfo, map[string]string{}, getXMLInclusivePrefixes(canonicalizationMethod), nil)
+	signedInfoHash := signatureHash.New()
+	signedInfoHash.Write(canonical.Bytes())
+	publicKey := certificate.PublicKey.(*rsa.PublicKey)
+	if err = rsa.VerifyPKCS1v15(publicKey, signatureHash, signedInfoHash.Sum(nil), signatureValue); err != nil {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
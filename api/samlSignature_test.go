@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseXMLTree(t *testing.T) {
+	for _, testCase := range []struct {
+		name  string
+		xml   string
+		valid bool
+	}{
+		{"elements and text", `<a><b>text</b></a>`, true},
+		{"declared prefix", `<x:a xmlns:x="urn:x"><x:b/></x:a>`, true},
+		{"declaration and comments", `<?xml version="1.0"?><!-- comment --><a><!-- comment --></a>`, true},
+		{"undeclared prefix", `<x:a/>`, false},
+		{"prefix declared on a sibling", `<a><b xmlns:x="urn:x"/><x:c/></a>`, false},
+		{"doctype", `<!DOCTYPE a [<!ENTITY b "c">]><a>&b;</a>`, false},
+		{"two root elements", `<a/><b/>`, false},
+		{"mismatched end element", `<a><b></a></b>`, false},
+		{"end element with another prefix", `<x:a xmlns:x="urn:x" xmlns:y="urn:x"></y:a>`, false},
+		{"unclosed element", `<a><b/>`, false},
+		{"empty document", ``, false},
+	} {
+		_, err := parseXMLTree([]byte(testCase.xml))
+		if testCase.valid && err != nil {
+			t.Errorf("%s: cannot parse: %s", testCase.name, err)
+		} else if !testCase.valid && err == nil {
+			t.Errorf("%s: expected an error", testCase.name)
+		}
+	}
+	root, err := parseXMLTree([]byte(`<x:a xmlns:x="urn:x" xmlns="urn:default"><b>one<!-- comment -->two</b></x:a>`))
+	if err != nil {
+		t.Fatalf("cannot parse: %s", err)
+	}
+	child := getXMLChild(root, "urn:default", "b")
+	if root.Space != "urn:x" || child == nil || child.Parent != root {
+		t.Fatalf("namespaces were not resolved: %+v", root)
+	}
+	// comments are left out, so they cannot cut text in two
+	if text := getXMLText(child); text != "onetwo" {
+		t.Fatalf("expected the text around the comment, got %q", text)
+	}
+}
+
+func TestWriteXMLExcC14N(t *testing.T) {
+	for _, testCase := range []struct {
+		name              string
+		xml               string
+		child             bool
+		inclusivePrefixes []string
+		expected          string
+	}{
+		{
+			"empty elements are expanded",
+			`<a><b/></a>`,
+			false, nil,
+			`<a><b></b></a>`,
+		},
+		{
+			"unused namespaces are left out",
+			`<a xmlns:unused="urn:unused">text</a>`,
+			false, nil,
+			`<a>text</a>`,
+		},
+		{
+			"namespaces are declared where first used",
+			`<x:a xmlns:x="urn:x" xmlns:y="urn:y"><x:b><y:c/></x:b></x:a>`,
+			false, nil,
+			`<x:a xmlns:x="urn:x"><x:b><y:c xmlns:y="urn:y"></y:c></x:b></x:a>`,
+		},
+		{
+			"namespaces of ancestors left out of the output are declared",
+			`<x:a xmlns:x="urn:x" xmlns:y="urn:y" xmlns="urn:default"><x:b y:attr="1"><c/></x:b></x:a>`,
+			true, nil,
+			`<x:b xmlns:x="urn:x" xmlns:y="urn:y" y:attr="1"><c xmlns="urn:default"></c></x:b>`,
+		},
+		{
+			"inclusive prefixes are declared without being used",
+			`<x:a xmlns:x="urn:x" xmlns:y="urn:y"><x:b/></x:a>`,
+			true, []string{"y"},
+			`<x:b xmlns:x="urn:x" xmlns:y="urn:y"></x:b>`,
+		},
+		{
+			"declarations are sorted by prefix and attributes by namespace",
+			`<a xmlns:z="urn:a" xmlns:b="urn:b" z="1" z:y="2" b:x="3" a="4"/>`,
+			false, nil,
+			`<a xmlns:b="urn:b" xmlns:z="urn:a" a="4" z="1" z:y="2" b:x="3"></a>`,
+		},
+		{
+			"text and attributes are escaped",
+			`<a attr="&quot;&lt;&gt;&#9;&#10;">&lt;&amp;&gt;&quot;&#13;</a>`,
+			false, nil,
+			`<a attr="&quot;&lt;>&#x9;&#xA;">&lt;&amp;&gt;"&#xD;</a>`,
+		},
+		{
+			"comments are left out",
+			`<a>one<!-- comment -->two</a>`,
+			false, nil,
+			`<a>onetwo</a>`,
+		},
+	} {
+		node, err := parseXMLTree([]byte(testCase.xml))
+		if err != nil {
+			t.Fatalf("%s: cannot parse: %s", testCase.name, err)
+		}
+		if testCase.child {
+			node = node.Children[0]
+		}
+		var canonical bytes.Buffer
+		writeXMLExcC14N(&canonical, node, map[string]string{}, testCase.inclusivePrefixes, nil)
+		if canonical.String() != testCase.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", testCase.name, testCase.expected, canonical.String())
+		}
+	}
+}
+
+// newTestSAMLCertificate returns a key and a self signed certificate for it
+func newTestSAMLCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "idp.example.com",
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	certificatePEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+	return key, certificate, string(certificatePEM)
+}
+
+// replaceTestXML replaces old in the document, failing if it is not there
+func replaceTestXML(t *testing.T, document string, old string, new string) string {
+	t.Helper()
+	if !strings.Contains(document, old) {
+		t.Fatalf("cannot find %s in the document", old)
+	}
+	return strings.Replace(document, old, new, 1)
+}
+
+// TestVerifyXMLSignature checks a response signed by google workspace, and changes to it
+func TestVerifyXMLSignature(t *testing.T) {
+	responseXML, err := ioutil.ReadFile("testdata/saml_google_response.xml")
+	if err != nil {
+		t.Fatalf("cannot read response: %s", err)
+	}
+	response := string(responseXML)
+	certificatePEM, err := ioutil.ReadFile("testdata/saml_google_certificate.pem")
+	if err != nil {
+		t.Fatalf("cannot read certificate: %s", err)
+	}
+	certificate, err := parseSAMLCertificate(string(certificatePEM))
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	_, otherCertificate, _ := newTestSAMLCertificate(t)
+	nameID := "ross@octolabs.io</saml2:NameID>"
+	assertionStart := `<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" `
+	signatureStart := strings.Index(response, "<ds:Signature ")
+	signatureEnd := strings.Index(response, "</ds:Signature>") + len("</ds:Signature>")
+	if signatureStart < 0 || signatureEnd < signatureStart {
+		t.Fatal("cannot find the signature of the response")
+	}
+	for _, testCase := range []struct {
+		name        string
+		document    string
+		assertion   bool
+		certificate *x509.Certificate
+		valid       bool
+		nameID      string
+	}{
+		{
+			name:        "signed by the identity provider",
+			document:    response,
+			certificate: certificate,
+			valid:       true,
+			nameID:      "ross@octolabs.io",
+		},
+		{
+			name:        "one byte of the name id changed",
+			document:    replaceTestXML(t, response, nameID, "ross@octolabs.iq</saml2:NameID>"),
+			certificate: certificate,
+		},
+		{
+			// the comment is not part of the signed form, but cannot cut the name id short
+			name:        "comment in the name id",
+			document:    replaceTestXML(t, response, nameID, "ross@<!-- comment -->octolabs.io</saml2:NameID>"),
+			certificate: certificate,
+			valid:       true,
+			nameID:      "ross@octolabs.io",
+		},
+		{
+			name:        "comment and text added to the name id",
+			document:    replaceTestXML(t, response, nameID, "ross@octolabs.io<!-- comment -->.evil.com</saml2:NameID>"),
+			certificate: certificate,
+		},
+		{
+			name: "unsigned assertion injected next to the signed one",
+			document: replaceTestXML(t, response, assertionStart, assertionStart+`ID="_evil"><saml2:Subject>`+
+				`<saml2:NameID>admin@octolabs.io</saml2:NameID></saml2:Subject></saml2:Assertion>`+assertionStart),
+			certificate: certificate,
+		},
+		{
+			name:        "reference to another element",
+			document:    replaceTestXML(t, response, `URI="#_fc141db284eb3098605351bde4d9be59"`, `URI="#_9e764952e6a261e19409a3825581033d"`),
+			certificate: certificate,
+		},
+		{
+			name:        "signature moved to an element with another id",
+			document:    replaceTestXML(t, response, `ID="_fc141db284eb3098605351bde4d9be59"`, `ID="_evil"`),
+			certificate: certificate,
+		},
+		{
+			name:        "unsigned response",
+			document:    response[:signatureStart] + response[signatureEnd:],
+			certificate: certificate,
+		},
+		{
+			name:        "unsigned assertion",
+			document:    response,
+			assertion:   true,
+			certificate: certificate,
+		},
+		{
+			name:        "wrong certificate",
+			document:    response,
+			certificate: otherCertificate,
+		},
+	} {
+		root, err := parseXMLTree([]byte(testCase.document))
+		if err != nil {
+			t.Fatalf("%s: cannot parse: %s", testCase.name, err)
+		}
+		assertion := getXMLChild(root, samlAssertionNamespace, "Assertion")
+		node := root
+		if testCase.assertion {
+			node = assertion
+		}
+		err = verifyXMLSignature(node, testCase.certificate)
+		if testCase.valid && err != nil {
+			t.Errorf("%s: signature does not verify: %s", testCase.name, err)
+		} else if !testCase.valid && err == nil {
+			t.Errorf("%s: expected the signature to be rejected", testCase.name)
+		}
+		if len(testCase.nameID) > 0 {
+			subject := getXMLChild(assertion, samlAssertionNamespace, "Subject")
+			if text := getXMLText(getXMLChild(subject, samlAssertionNamespace, "NameID")); text != testCase.nameID {
+				t.Errorf("%s: expected name id %s, got %s", testCase.name, testCase.nameID, text)
+			}
+		}
+	}
+}
+
+// signTestSAMLAssertion replaces the signature comment in the assertion with an enveloped signature
+func signTestSAMLAssertion(t *testing.T, document string, key *rsa.PrivateKey) string {
+	t.Helper()
+	root, err := parseXMLTree([]byte(document))
+	if err != nil {
+		t.Fatalf("cannot parse: %s", err)
+	}
+	assertion := getXMLChild(root, samlAssertionNamespace, "Assertion")
+	var canonical bytes.Buffer
+	writeXMLExcC14N(&canonical, assertion, map[string]string{}, nil, nil)
+	digest := sha256.Sum256(canonical.Bytes())
+	// already in canonical form, so it is signed as it is
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + xmlDSigNamespace + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + xmlExcC14NAlgorithm + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + getXMLAttr(assertion, "ID") + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + xmlEnvelopedSignatureAlgorithm + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + xmlExcC14NAlgorithm + `"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo>`
+	signedInfoHash := sha256.Sum256([]byte(signedInfo))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, signedInfoHash[:])
+	if err != nil {
+		t.Fatalf("cannot sign: %s", err)
+	}
+	return replaceTestXML(t, document, "<!-- signature -->", `<ds:Signature xmlns:ds="`+xmlDSigNamespace+`">`+signedInfo+
+		`<ds:SignatureValue>`+base64.StdEncoding.EncodeToString(signature)+`</ds:SignatureValue></ds:Signature>`)
+}
+
+// TestParseSAMLResponseSignedAssertion checks responses where only the assertion is signed
+func TestParseSAMLResponseSignedAssertion(t *testing.T) {
+	apiURL = "https://api.example.com"
+	key, _, certificatePEM := newTestSAMLCertificate(t)
+	_, _, otherCertificatePEM := newTestSAMLCertificate(t)
+	org := &Org{
+		ID: "org",
+		SSO: &OrgSSO{
+			IDPEntityID:    "https://idp.example.com",
+			IDPCertificate: certificatePEM,
+		},
+	}
+	now := time.Now().UTC()
+	notBefore := now.Add(-time.Minute).Format(time.RFC3339)
+	notOnOrAfter := now.Add(5 * time.Minute).Format(time.RFC3339)
+	acsURL := getSAMLACSURL(org.ID)
+	issuer := `<saml:Issuer>` + org.SSO.IDPEntityID + `</saml:Issuer>`
+	assertion := `<saml:Assertion xmlns:saml="` + samlAssertionNamespace + `" ID="_assertion" Version="2.0" IssueInstant="` + now.Format(time.RFC3339) + `">` +
+		issuer + `<!-- signature -->` +
+		`<saml:Subject><saml:NameID Format="` + samlEmailFormat + `">user@example.com</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + samlBearerMethod + `"><saml:SubjectConfirmationData InResponseTo="_request" ` +
+		`NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + acsURL + `"/></saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + notBefore + `" NotOnOrAfter="` + notOnOrAfter + `"><saml:AudienceRestriction>` +
+		`<saml:Audience>` + getSAMLEntityID(org.ID) + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`</saml:Assertion>`
+	response := `<samlp:Response xmlns:samlp="` + samlProtocolNamespace + `" ID="_response" InResponseTo="_request" Version="2.0" ` +
+		`IssueInstant="` + now.Format(time.RFC3339) + `" Destination="` + acsURL + `">` +
+		`<samlp:Status><samlp:StatusCode Value="` + samlSuccessStatus + `"/></samlp:Status>` +
+		assertion + `</samlp:Response>`
+	signedResponse := signTestSAMLAssertion(t, response, key)
+	evilAssertion := `<saml:Assertion xmlns:saml="` + samlAssertionNamespace + `" ID="_evil">` + issuer +
+		`<saml:Subject><saml:NameID Format="` + samlEmailFormat + `">admin@example.com</saml:NameID></saml:Subject>`
+	signedAssertionStart := strings.Index(signedResponse, "<saml:Assertion ")
+	signedAssertionEnd := strings.Index(signedResponse, "</samlp:Response>")
+	for _, testCase := range []struct {
+		name        string
+		document    string
+		certificate string
+		valid       bool
+	}{
+		{"signed assertion", signedResponse, certificatePEM, true},
+		{"unsigned assertion", response, certificatePEM, false},
+		{"wrong certificate", signedResponse, otherCertificatePEM, false},
+		{"name id changed", replaceTestXML(t, signedResponse, "user@example.com", "admin@example.com"), certificatePEM, false},
+		{
+			"unsigned assertion injected after the signed one",
+			replaceTestXML(t, signedResponse, "</samlp:Response>", evilAssertion+"</saml:Assertion></samlp:Response>"),
+			certificatePEM, false,
+		},
+		{
+			"signed assertion wrapped in an unsigned one",
+			signedResponse[:signedAssertionStart] + evilAssertion + signedResponse[signedAssertionStart:signedAssertionEnd] +
+				"</saml:Assertion></samlp:Response>",
+			certificatePEM, false,
+		},
+	} {
+		org.SSO.IDPCertificate = testCase.certificate
+		assertionData, err := parseSAMLResponse(org, base64.StdEncoding.EncodeToString([]byte(testCase.document)))
+		if !testCase.valid {
+			if err == nil {
+				t.Errorf("%s: expected the response to be rejected, logged in %s", testCase.name, assertionData.Email)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: cannot parse response: %s", testCase.name, err)
+		} else if assertionData.Email != "user@example.com" || assertionData.ID != "_assertion" {
+			t.Errorf("%s: unexpected assertion %+v", testCase.name, assertionData)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// logins create a session with a short lived access token and a refresh token. refresh tokens
+// look like <session id>.<secret>, and are replaced every time they are used. using a replaced
+// refresh token again revokes the session. access tokens carry the session id, and getTokenData
+// rejects them once the session key is gone from redis.
+
+// Session login of a user on a device
+type Session struct {
+	ID       string `json:"id"`
+	User     string `json:"user"`
+	Device   string `json:"device"`
+	IP       string `json:"ip"`
+	LastUsed int64  `json:"lastused"`
+	Expires  int64  `json:"expires"`
+	Created  int64  `json:"created"`
+	Current  bool   `json:"current"`
+}
+
+// SessionType graphql session object
+var SessionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Session",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.String,
+		},
+		"device": &graphql.Field{
+			Type:        graphql.String,
+			Description: "user agent of the login",
+		},
+		"ip": &graphql.Field{
+			Type:        graphql.String,
+			Description: "ip of the last refresh",
+		},
+		"lastused": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"expires": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"created": &graphql.Field{
+			Type: graphql.Int,
+		},
+		"current": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "session of this request",
+		},
+	},
+})
+
+func getSessionRedisKey(sessionIDString string) string {
+	return "session-" + sessionIDString
+}
+
+func hashRefreshSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func newRefreshSecret() (string, error) {
+	secretBytes := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secretBytes), nil
+}
+
+func getRequestDevice(request *http.Request) string {
+	device := request.UserAgent()
+	if len(device) > sessionDeviceMaxLength {
+		device = device[:sessionDeviceMaxLength]
+	}
+	return device
+}
+
+// getSessionToken returns an access token for the session
+func getSessionToken(account *Account, sessionIDString string) (string, error) {
+	expirationTime := time.Now().Add(time.Duration(accessTokenExpiration) * time.Minute)
+	return signToken(loginClaims{
+		account.ID,
+		account.Email,
+		account.Type,
+		account.Plan,
+		sessionIDString,
+		jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+			Issuer:    jwtIssuer,
+		},
+	})
+}
+
+// createSession logs the user in, returning the access token and refresh token
+func createSession(userID primitive.ObjectID, device string, ip string) (string, string, error) {
+	account, err := getAccount(userID, false)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return "", "", err
+	}
+	sessionID := primitive.NewObjectID()
+	now := time.Now()
+	expires := now.Add(time.Duration(refreshTokenExpiration) * time.Hour)
+	_, err = sessionCollection.InsertOne(ctxMongo, bson.M{
+		"_id":          sessionID,
+		"user":         account.ID,
+		"device":       device,
+		"ip":           ip,
+		"refreshhash":  hashRefreshSecret(secret),
+		"previoushash": "",
+		"lastused":     now.Unix(),
+		"expires":      expires.Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	err = redisClient.Set(getSessionRedisKey(sessionID.Hex()), account.ID, time.Until(expires)).Err()
+	if err != nil {
+		return "", "", err
+	}
+	token, err := getSessionToken(account, sessionID.Hex())
+	if err != nil {
+		return "", "", err
+	}
+	return token, sessionID.Hex() + "." + secret, nil
+}
+
+// refreshSession rotates the refresh token, returning a new access token and refresh token
+func refreshSession(refreshToken string, ip string) (string, string, error) {
+	tokenParts := strings.Split(refreshToken, ".")
+	if len(tokenParts) != 2 {
+		return "", "", errors.New("invalid refresh token")
+	}
+	sessionID, err := primitive.ObjectIDFromHex(tokenParts[0])
+	if err != nil {
+		return "", "", errors.New("invalid refresh token")
+	}
+	var sessionData bson.M
+	err = sessionCollection.FindOne(ctxMongo, bson.M{
+		"_id": sessionID,
+	}).Decode(&sessionData)
+	if err == mongo.ErrNoDocuments {
+		return "", "", errors.New("session was revoked")
+	} else if err != nil {
+		return "", "", err
+	}
+	secretHash := hashRefreshSecret(tokenParts[1])
+	refreshHash, _ := sessionData["refreshhash"].(string)
+	previousHash, _ := sessionData["previoushash"].(string)
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(refreshHash)) != 1 {
+		if len(previousHash) > 0 && subtle.ConstantTimeCompare([]byte(secretHash), []byte(previousHash)) == 1 {
+			// a refresh token that was already used means it was copied
+			if err = revokeSession(sessionID); err != nil {
+				return "", "", err
+			}
+			return "", "", errors.New("refresh token was already used, session revoked")
+		}
+		return "", "", errors.New("invalid refresh token")
+	}
+	if expires, _ := sessionData["expires"].(int64); time.Now().Unix() > expires {
+		return "", "", errors.New("session expired")
+	}
+	userIDString, _ := sessionData["user"].(string)
+	userID, err := primitive.ObjectIDFromHex(userIDString)
+	if err != nil {
+		return "", "", err
+	}
+	account, err := getAccount(userID, false)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	expires := now.Add(time.Duration(refreshTokenExpiration) * time.Hour)
+	// only the current refresh token can be rotated, so parallel refreshes cannot both succeed
+	res, err := sessionCollection.UpdateOne(ctxMongo, bson.M{
+		"_id":         sessionID,
+		"refreshhash": refreshHash,
+	}, bson.M{
+		"$set": bson.M{
+			"refreshhash":  hashRefreshSecret(secret),
+			"previoushash": refreshHash,
+			"ip":           ip,
+			"lastused":     now.Unix(),
+			"expires":      expires.Unix(),
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if res.ModifiedCount == 0 {
+		return "", "", errors.New("refresh token was already used")
+	}
+	err = redisClient.Set(getSessionRedisKey(sessionID.Hex()), account.ID, time.Until(expires)).Err()
+	if err != nil {
+		return "", "", err
+	}
+	token, err := getSessionToken(account, sessionID.Hex())
+	if err != nil {
+		return "", "", err
+	}
+	return token, sessionID.Hex() + "." + secret, nil
+}
+
+// checkSession returns an error if the session was revoked or expired
+func checkSession(sessionIDString string) error {
+	count, err := redisClient.Exists(getSessionRedisKey(sessionIDString)).Result()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("session was revoked")
+	}
+	return nil
+}
+
+func revokeSession(sessionID primitive.ObjectID) error {
+	if err := redisClient.Del(getSessionRedisKey(sessionID.Hex())).Err(); err != nil {
+		return err
+	}
+	_, err := sessionCollection.DeleteOne(ctxMongo, bson.M{
+		"_id": sessionID,
+	})
+	return err
+}
+
+// revokeUserSessions revokes all sessions of the user, except the given session if there is one
+func revokeUserSessions(userIDString string, exceptSessionIDString string) error {
+	cursor, err := sessionCollection.Find(ctxMongo, bson.M{
+		"user": userIDString,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctxMongo)
+	for cursor.Next(ctxMongo) {
+		sessionID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			return errors.New("cannot find session id")
+		}
+		if sessionID.Hex() == exceptSessionIDString {
+			continue
+		}
+		if err = revokeSession(sessionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * @api {put} /refreshToken Get a new access token
+ * @apiVersion 0.0.1
+ * @apiParam {String} refreshToken Refresh token from login or the last refresh
+ * @apiSuccess {String} token User token for authenticated requests
+ * @apiSuccess {String} refreshToken New refresh token, the old one stops working
+ * @apiGroup authentication
+ */
+func refreshLoginToken(c *gin.Context) {
+	response := c.Writer
+	request := c.Request
+	if request.Method != http.MethodPut {
+		handleError("refresh http method not PUT", http.StatusBadRequest, response)
+		return
+	}
+	var refreshdata map[string]interface{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		handleError("error getting request body: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	err = json.Unmarshal(body, &refreshdata)
+	if err != nil {
+		handleError("error parsing request body: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	if refreshdata["refreshToken"] == nil {
+		handleError("no refresh token provided", http.StatusBadRequest, response)
+		return
+	}
+	refreshToken, ok := refreshdata["refreshToken"].(string)
+	if !ok {
+		handleError("refresh token cannot be cast to string", http.StatusBadRequest, response)
+		return
+	}
+	tokenString, newRefreshToken, err := refreshSession(refreshToken, c.ClientIP())
+	if err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write([]byte(`{ "token": "` + tokenString + `", "refreshToken": "` + newRefreshToken + `" }`))
+}
+
+var sessionQueryFields = graphql.Fields{
+	"sessions": &graphql.Field{
+		Type:        graphql.NewList(SessionType),
+		Description: "Get the sessions of your account",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			currentSessionIDString, _ := claims["session"].(string)
+			cursor, err := sessionCollection.Find(ctxMongo, bson.M{
+				"user": userIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer cursor.Close(ctxMongo)
+			sessions := []*Session{}
+			for cursor.Next(ctxMongo) {
+				var session Session
+				if err = cursor.Decode(&session); err != nil {
+					return nil, err
+				}
+				sessionID, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+				if !ok {
+					return nil, errors.New("cannot find session id")
+				}
+				session.ID = sessionID.Hex()
+				session.Created = objectidTimestamp(sessionID).Unix()
+				session.Current = session.ID == currentSessionIDString
+				sessions = append(sessions, &session)
+			}
+			return sessions, nil
+		},
+	},
+}
+
+var sessionMutationFields = graphql.Fields{
+	"revokeSession": &graphql.Field{
+		Type:        graphql.String,
+		Description: "Log out a session",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			if params.Args["id"] == nil {
+				return nil, errors.New("session id not provided")
+			}
+			sessionIDString, ok := params.Args["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast session id to string")
+			}
+			sessionID, err := primitive.ObjectIDFromHex(sessionIDString)
+			if err != nil {
+				return nil, err
+			}
+			count, err := sessionCollection.CountDocuments(ctxMongo, bson.M{
+				"_id":  sessionID,
+				"user": userIDString,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, errors.New("cannot find session")
+			}
+			if err = revokeSession(sessionID); err != nil {
+				return nil, err
+			}
+			return sessionIDString, nil
+		},
+	},
+	"revokeAllSessions": &graphql.Field{
+		Type:        graphql.String,
+		Description: "Log out all sessions",
+		Args: graphql.FieldConfigArgument{
+			"keepCurrent": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "stay logged in with the session of this request",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			userIDString, ok := claims["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			var exceptSessionIDString = ""
+			if params.Args["keepCurrent"] != nil {
+				keepCurrent, ok := params.Args["keepCurrent"].(bool)
+				if !ok {
+					return nil, errors.New("cannot cast keep current to bool")
+				}
+				if keepCurrent {
+					exceptSessionIDString, _ = claims["session"].(string)
+				}
+			}
+			if err = revokeUserSessions(userIDString, exceptSessionIDString); err != nil {
+				return nil, err
+			}
+			return userIDString, nil
+		},
+	},
+}
@@ -295,7 +295,7 @@ func writeFile(c *gin.Context) {
 		return
 	}
 	accessKey := request.URL.Query().Get("accesskey")
-	ownerID, err := validateStorageEditRequest(request, postIDObj, posttype, accessKey)
+	ownerID, orgString, err := validateStorageEditRequest(request, postIDObj, posttype, accessKey)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
@@ -322,21 +322,10 @@ func writeFile(c *gin.Context) {
 	}
 	defer file.Close()
 	if posttype == responseType || posttype == formType {
-		account, err := getAccount(ownerID, false)
-		if err != nil {
+		if err = checkStorageLimit(ownerID, orgString, fileHeader.Size); err != nil {
 			handleError(err.Error(), http.StatusBadRequest, response)
 			return
 		}
-		productData, err := getProductFromUserData(account)
-		if err != nil {
-			handleError(err.Error(), http.StatusUnauthorized, response)
-			return
-		}
-		storageRemaining := int64(productData.MaxStorage) - account.Storage
-		if fileHeader.Size > int64(storageRemaining) {
-			handleError("not enough storage remaining", http.StatusBadRequest, response)
-			return
-		}
 	}
 	var byteswritten int64
 	switch filetype {
@@ -366,14 +355,7 @@ func writeFile(c *gin.Context) {
 		break
 	}
 	if posttype == responseType || posttype == formType {
-		_, err = userCollection.UpdateOne(ctxMongo, bson.M{
-			"_id": ownerID,
-		}, bson.M{
-			"$inc": bson.M{
-				"storage": byteswritten,
-			},
-		})
-		if err != nil {
+		if err = changeOwnerStorage(ownerID, orgString, byteswritten); err != nil {
 			handleError(err.Error(), http.StatusBadRequest, response)
 			return
 		}
@@ -511,7 +493,7 @@ func deleteFiles(c *gin.Context) {
 			return
 		}
 	}
-	ownerID, err := validateStorageEditRequest(request, postIDObj, posttype, accessKey)
+	ownerID, orgString, err := validateStorageEditRequest(request, postIDObj, posttype, accessKey)
 	if err != nil {
 		handleError(err.Error(), http.StatusBadRequest, response)
 		return
@@ -531,7 +513,7 @@ func deleteFiles(c *gin.Context) {
 		bytesRemoved += newBytesRemoved
 	}
 	if posttype == responseType || posttype == formType {
-		if err = changeUserStorage(ownerID, -1*bytesRemoved); err != nil {
+		if err = changeOwnerStorage(ownerID, orgString, -1*bytesRemoved); err != nil {
 			handleError(err.Error(), http.StatusBadRequest, response)
 			return
 		}
@@ -551,70 +533,88 @@ func changeUserStorage(ownerID primitive.ObjectID, amountChange int64) error {
 	if err != nil {
 		return err
 	}
+	publishAccountChanged(ownerID)
 	return nil
 }
 
-func validateStorageEditRequest(request *http.Request, postID primitive.ObjectID, posttype string, accessKey string) (primitive.ObjectID, error) {
+func validateStorageEditRequest(request *http.Request, postID primitive.ObjectID, posttype string, accessKey string) (primitive.ObjectID, string, error) {
 	accessToken := request.URL.Query().Get("updateToken")
 	postIDString := postID.Hex()
 	var ownerString string
+	var orgString string
 	var err error
 	if len(accessToken) > 0 && posttype == responseType {
 		// validate access token
 		var tokenResponseIDString string
 		tokenResponseIDString, ownerString, _, err = getResponseEditTokenData(accessToken, editAccessLevel)
 		if err != nil {
-			return primitive.NilObjectID, err
+			return primitive.NilObjectID, "", err
 		}
 		_, err = primitive.ObjectIDFromHex(tokenResponseIDString)
 		if err != nil {
-			return primitive.NilObjectID, err
+			return primitive.NilObjectID, "", err
 		}
 		if tokenResponseIDString != postIDString {
-			return primitive.NilObjectID, err
+			return primitive.NilObjectID, "", err
 		}
+		responseData, err := getResponse(postID, false)
+		if err != nil {
+			return primitive.NilObjectID, "", err
+		}
+		orgString = responseData.Org
 	} else {
 		accessToken = getAuthToken(request)
 		if _, err := getTokenData(accessToken); err != nil {
-			return primitive.NilObjectID, err
+			return primitive.NilObjectID, "", err
+		}
+		var scope = apiKeyFormsManageScope
+		if posttype == responseType {
+			scope = apiKeyResponsesManageScope
+		}
+		if err = checkAPIKeyScope(accessToken, scope); err != nil {
+			return primitive.NilObjectID, "", err
 		}
 		_, err = validateAdmin(accessToken)
 		isAdmin := err == nil
 		if !isAdmin {
 			if posttype == blogType {
-				return primitive.NilObjectID, errors.New("you need to be admin to edit blogs")
+				return primitive.NilObjectID, "", errors.New("you need to be admin to edit blogs")
 			} else if posttype == formType {
 				formData, err := checkFormAccess(postID, accessToken, accessKey, editAccessLevel, false)
 				if err != nil {
-					return primitive.NilObjectID, err
+					return primitive.NilObjectID, "", err
 				}
 				ownerString = formData.Owner
+				orgString = formData.Org
 			} else if posttype == responseType {
 				responseData, err := checkResponseAccess(postID, accessToken, editAccessLevel, false)
 				if err != nil {
-					return primitive.NilObjectID, err
+					return primitive.NilObjectID, "", err
 				}
 				ownerString = responseData.Owner
+				orgString = responseData.Org
 			}
 		} else if posttype == responseType {
 			responseData, err := getResponse(postID, false)
 			if err != nil {
-				return primitive.NilObjectID, err
+				return primitive.NilObjectID, "", err
 			}
 			ownerString = responseData.Owner
+			orgString = responseData.Org
 		} else if posttype == formType {
 			formData, err := getForm(postID, false)
 			if err != nil {
-				return primitive.NilObjectID, err
+				return primitive.NilObjectID, "", err
 			}
 			ownerString = formData.Owner
+			orgString = formData.Org
 		}
 	}
 	ownerID, err := primitive.ObjectIDFromHex(ownerString)
 	if err != nil {
-		return primitive.NilObjectID, err
+		return primitive.NilObjectID, "", err
 	}
-	return ownerID, nil
+	return ownerID, orgString, nil
 }
 
 func getFile(c *gin.Context) {
@@ -667,6 +667,10 @@ func getFile(c *gin.Context) {
 	if posttype == formType {
 		if updateToken == "" {
 			accessToken := getAuthToken(request)
+			if err = checkAPIKeyScope(accessToken, apiKeyFormsReadScope); err != nil {
+				handleError(err.Error(), http.StatusUnauthorized, response)
+				return
+			}
 			_, err := checkFormAccess(postIDObj, accessToken, accessKey, viewAccessLevel, false)
 			if err != nil {
 				handleError("no form access: "+err.Error(), http.StatusUnauthorized, response)
@@ -686,6 +690,10 @@ func getFile(c *gin.Context) {
 	} else if posttype == responseType {
 		if updateToken == "" {
 			accessToken := getAuthToken(request)
+			if err = checkAPIKeyScope(accessToken, apiKeyResponsesReadScope); err != nil {
+				handleError(err.Error(), http.StatusUnauthorized, response)
+				return
+			}
 			_, err := checkResponseAccess(postIDObj, accessToken, viewAccessLevel, false)
 			if err != nil {
 				handleError("no response access: "+err.Error(), http.StatusUnauthorized, response)
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var responseAddedPath = "response-added-"
+
+var projectChangedPath = "project-changed-"
+
+var accountChangedPath = "account-changed-"
+
+// ProjectChangeType change to a project for subscriptions
+var ProjectChangeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ProjectChange",
+	Fields: graphql.Fields{
+		"type": &graphql.Field{
+			Type:        graphql.String,
+			Description: "update or delete",
+		},
+		"project": &graphql.Field{
+			Type: ProjectType,
+		},
+	},
+})
+
+func publishResponseAdded(formIDString string, responseData map[string]interface{}) {
+	// the caller still returns the response, so remove the private fields from a copy
+	publishData := make(map[string]interface{}, len(responseData))
+	for key, value := range responseData {
+		publishData[key] = value
+	}
+	delete(publishData, "nullifier")
+	delete(publishData, "uniquekeys")
+	publishSubscription(responseAddedPath+formIDString, publishData)
+}
+
+func publishProjectChanged(project *Project, changeType string) {
+	// access, tags and categories depend on the user, so subscribers get them from the project query
+	projectData := *project
+	projectData.Access = nil
+	projectData.LinkAccess = nil
+	projectData.Tags = nil
+	projectData.Categories = nil
+	publishSubscription(projectChangedPath+project.ID, map[string]interface{}{
+		"type":    changeType,
+		"project": projectData,
+	})
+}
+
+func publishProjectChangedByID(projectID primitive.ObjectID) {
+	project, err := getProject(projectID, true)
+	if err != nil {
+		logger.Error("cannot get changed project: " + err.Error())
+		return
+	}
+	publishProjectChanged(project, validProjectChangeTypes[0])
+}
+
+func publishAccountChanged(accountID primitive.ObjectID) {
+	account, err := getAccount(accountID, true)
+	if err != nil {
+		logger.Error("cannot get changed account: " + err.Error())
+		return
+	}
+	account.Password = ""
+	if account.TOTP != nil {
+		// only whether it is on, for totpenabled
+		account.TOTP = &TOTPData{
+			Enabled: account.TOTP.Enabled,
+		}
+	}
+	account.SubscriptionID = ""
+	account.StripeIDs = nil
+	publishSubscription(accountChangedPath+account.ID, account)
+}
+
+var subscriptionFields = graphql.Fields{
+	"responseAdded": &graphql.Field{
+		Type:        ResponseType,
+		Description: "Subscribe to new responses to a form",
+		Args: graphql.FieldConfigArgument{
+			"form": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			return resolveSubscription(params, func(setup *SubscriptionSetup) (interface{}, error) {
+				if params.Args["form"] == nil {
+					return nil, errors.New("form id not provided")
+				}
+				formIDString, ok := params.Args["form"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast form id to string")
+				}
+				formID, err := primitive.ObjectIDFromHex(formIDString)
+				if err != nil {
+					return nil, err
+				}
+				var accessKey = ""
+				if params.Args["accessKey"] != nil {
+					accessKey, ok = params.Args["accessKey"].(string)
+					if !ok {
+						return nil, errors.New("cannot cast access key to string")
+					}
+				}
+				accessToken := params.Context.Value(tokenKey).(string)
+				checkAccess := func() error {
+					_, err := checkFormAccess(formID, accessToken, accessKey, viewAccessLevel, false)
+					return err
+				}
+				if err = checkAccess(); err != nil {
+					return nil, err
+				}
+				setup.Topic = responseAddedPath + formIDString
+				setup.CheckAccess = checkAccess
+				return nil, nil
+			})
+		},
+	},
+	"commentChanged": &graphql.Field{
+		Type:        CommentChangeType,
+		Description: "Subscribe to comments on a form",
+		Args: graphql.FieldConfigArgument{
+			"form": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			return resolveSubscription(params, func(setup *SubscriptionSetup) (interface{}, error) {
+				if params.Args["form"] == nil {
+					return nil, errors.New("form id not provided")
+				}
+				formIDString, ok := params.Args["form"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast form id to string")
+				}
+				formID, err := primitive.ObjectIDFromHex(formIDString)
+				if err != nil {
+					return nil, err
+				}
+				var accessKey = ""
+				if params.Args["accessKey"] != nil {
+					accessKey, ok = params.Args["accessKey"].(string)
+					if !ok {
+						return nil, errors.New("cannot cast access key to string")
+					}
+				}
+				accessToken := params.Context.Value(tokenKey).(string)
+				checkAccess := func() error {
+					_, err := checkFormAccess(formID, accessToken, accessKey, viewAccessLevel, false)
+					return err
+				}
+				if err = checkAccess(); err != nil {
+					return nil, err
+				}
+				setup.Topic = commentChangedPath + formIDString
+				setup.CheckAccess = checkAccess
+				return nil, nil
+			})
+		},
+	},
+	"projectChanged": &graphql.Field{
+		Type:        ProjectChangeType,
+		Description: "Subscribe to changes to a project",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"accessKey": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "sharable link key for project",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			return resolveSubscription(params, func(setup *SubscriptionSetup) (interface{}, error) {
+				if params.Args["id"] == nil {
+					return nil, errors.New("project id not provided")
+				}
+				projectIDString, ok := params.Args["id"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast project id to string")
+				}
+				projectID, err := primitive.ObjectIDFromHex(projectIDString)
+				if err != nil {
+					return nil, err
+				}
+				var accessKey = ""
+				if params.Args["accessKey"] != nil {
+					accessKey, ok = params.Args["accessKey"].(string)
+					if !ok {
+						return nil, errors.New("cannot cast access key to string")
+					}
+				}
+				accessToken := params.Context.Value(tokenKey).(string)
+				checkAccess := func() error {
+					_, _, err := checkProjectAccess(projectID, accessToken, accessKey, viewAccessLevel, false)
+					return err
+				}
+				if err = checkAccess(); err != nil {
+					return nil, err
+				}
+				setup.Topic = projectChangedPath + projectIDString
+				setup.CheckAccess = checkAccess
+				return nil, nil
+			})
+		},
+	},
+	"accountChanged": &graphql.Field{
+		Type:        AccountType,
+		Description: "Subscribe to changes to your account",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			return resolveSubscription(params, func(setup *SubscriptionSetup) (interface{}, error) {
+				accessToken := params.Context.Value(tokenKey).(string)
+				claims, err := getTokenData(accessToken)
+				if err != nil {
+					return nil, err
+				}
+				userIDString, ok := claims["id"].(string)
+				if !ok {
+					return nil, errors.New("cannot cast user id to string")
+				}
+				setup.Topic = accountChangedPath + userIDString
+				setup.CheckAccess = func() error {
+					_, err := getTokenData(accessToken)
+					return err
+				}
+				return nil, nil
+			})
+		},
+	},
+}
@@ -2,44 +2,85 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v7"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
 	json "github.com/json-iterator/go"
+	"github.com/rs/xid"
 )
 
-// ConnectionACKMessage message for getting connection data
-type ConnectionACKMessage struct {
-	OperationID string `json:"id,omitempty"`
-	Type        string `json:"type"`
-	Payload     struct {
-		Query string `json:"query"`
-	} `json:"payload,omitempty"`
+// see https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md
+var graphqlWSProtocol = "graphql-ws"
+
+// see https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+var graphqlTransportWSProtocol = "graphql-transport-ws"
+
+// OperationMessage message sent over a subscription websocket
+type OperationMessage struct {
+	OperationID string          `json:"id,omitempty"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+}
+
+// OperationPayload graphql request to start a subscription
+type OperationPayload struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
 }
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-	Subprotocols: []string{"graphql-ws"},
+	CheckOrigin:     checkSubscriptionOrigin,
+	Subprotocols:    []string{graphqlTransportWSProtocol, graphqlWSProtocol},
+}
+
+// SubscriptionSocket websocket with its protocol and operations
+type SubscriptionSocket struct {
+	Conn        *websocket.Conn
+	Protocol    string
+	WriteLock   sync.Mutex
+	Initialized bool
+	AccessToken string
+	Operations  map[string]*Subscriber
+}
+
+// SubscriptionSetup filled in by the resolver of a subscription field when an operation starts
+type SubscriptionSetup struct {
+	// Topic redis pubsub channel with the data for the subscription
+	Topic string
+	// Key subscribers to a topic with the same key replace each other, empty for one per operation
+	Key     string
+	OnStart func() error
+	OnAlive func() error
+	OnStop  func() error
+	// CheckAccess runs before each published event, ending the operation once access is gone
+	CheckAccess func() error
 }
 
 // Subscriber websocket subscriber object
 type Subscriber struct {
-	FormID        string
-	Conn          *websocket.Conn
+	Topic         string
+	Key           string
+	Socket        *SubscriptionSocket
 	RequestString string
+	Variables     map[string]interface{}
+	OperationName string
 	OperationID   string
-	ID            string
+	Setup         *SubscriptionSetup
 }
 
-// Connection websocket connection
+// Connection redis pubsub connection for a topic, shared by its subscribers
 type Connection struct {
 	Conn          *redis.PubSub
 	Subscribers   map[string]Subscriber
@@ -47,15 +88,164 @@ type Connection struct {
 	AlreadySaving bool
 }
 
+// subscribersLock guards the subscribers of every connection
+var subscribersLock sync.Mutex
+
 func rootSubscription() *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: "Subscription",
 		Fields: graphql.Fields{
-			"formUpdates": collaborationFields["formUpdates"],
+			"formUpdates":    collaborationFields["formUpdates"],
+			"responseAdded":  subscriptionFields["responseAdded"],
+			"projectChanged": subscriptionFields["projectChanged"],
+			"accountChanged": subscriptionFields["accountChanged"],
+			"commentChanged": subscriptionFields["commentChanged"],
 		},
 	})
 }
 
+// checkSubscriptionOrigin allows the same origins as the cors config
+func checkSubscriptionOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		// not sent by a browser
+		return true
+	}
+	if corsConfig.AllowAllOrigins {
+		return true
+	}
+	for _, allowedOrigin := range corsConfig.AllowOrigins {
+		if strings.EqualFold(origin, allowedOrigin) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeSocketMessage(socket *SubscriptionSocket, messageType string, operationID string, payload interface{}) error {
+	message := map[string]interface{}{
+		"type": messageType,
+	}
+	if len(operationID) > 0 {
+		message["id"] = operationID
+	}
+	if payload != nil {
+		message["payload"] = payload
+	}
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	socket.WriteLock.Lock()
+	defer socket.WriteLock.Unlock()
+	if err = socket.Conn.SetWriteDeadline(time.Now().Add(time.Duration(subscriptionWriteTimeout) * time.Second)); err != nil {
+		return err
+	}
+	return socket.Conn.WriteMessage(websocket.TextMessage, messageJSON)
+}
+
+func writeSocketResult(socket *SubscriptionSocket, operationID string, result *graphql.Result) error {
+	if socket.Protocol == graphqlTransportWSProtocol {
+		return writeSocketMessage(socket, "next", operationID, result)
+	}
+	return writeSocketMessage(socket, "data", operationID, result)
+}
+
+func writeSocketError(socket *SubscriptionSocket, operationID string, errs []gqlerrors.FormattedError) error {
+	if socket.Protocol == graphqlTransportWSProtocol {
+		return writeSocketMessage(socket, "error", operationID, errs)
+	}
+	var payload interface{}
+	if len(errs) > 0 {
+		payload = errs[0]
+	}
+	return writeSocketMessage(socket, "error", operationID, payload)
+}
+
+func closeSocket(socket *SubscriptionSocket, code int, reason string) {
+	socket.WriteLock.Lock()
+	err := socket.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	socket.WriteLock.Unlock()
+	if err != nil && err != websocket.ErrCloseSent {
+		logger.Error("cannot send close message: " + err.Error())
+	}
+	if err = socket.Conn.Close(); err != nil {
+		logger.Error("cannot close connection: " + err.Error())
+	}
+}
+
+// rejectSocketMessage handles a message that breaks the protocol
+func rejectSocketMessage(socket *SubscriptionSocket, operationID string, code int, reason string) bool {
+	if socket.Protocol == graphqlTransportWSProtocol {
+		// the newer protocol closes the socket on any protocol error
+		closeSocket(socket, code, reason)
+		return false
+	}
+	if err := writeSocketError(socket, operationID, gqlerrors.FormatErrors(errors.New(reason))); err != nil {
+		logger.Error("failed to write to ws connection: " + err.Error())
+		return false
+	}
+	return true
+}
+
+func initSubscriptionSocket(socket *SubscriptionSocket, payload json.RawMessage) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	var initPayload map[string]interface{}
+	if err := json.Unmarshal(payload, &initPayload); err != nil {
+		return err
+	}
+	var authToken string
+	for key, value := range initPayload {
+		if strings.EqualFold(key, "authorization") {
+			authToken, _ = value.(string)
+			break
+		}
+	}
+	if len(authToken) == 0 {
+		return nil
+	}
+	splitToken := strings.Split(authToken, "Bearer ")
+	if len(splitToken) > 1 {
+		authToken = splitToken[1]
+	}
+	if isAPIKey(authToken) {
+		return errors.New("api keys cannot be used for subscriptions")
+	}
+	if _, err := getTokenData(authToken); err != nil {
+		return err
+	}
+	socket.AccessToken = authToken
+	return nil
+}
+
+func keepSubscriptionSocketAlive(socket *SubscriptionSocket, done chan bool) {
+	keepAliveDuration := time.Duration(subscriptionKeepAlive) * time.Second
+	ticker := time.NewTicker(keepAliveDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// pings find connections that dropped without closing
+			if err := socket.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepAliveDuration)); err != nil {
+				return
+			}
+			var err error
+			if socket.Protocol == graphqlTransportWSProtocol {
+				err = writeSocketMessage(socket, "ping", "", nil)
+			} else {
+				err = writeSocketMessage(socket, "ka", "", nil)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
 func subscriptionsHandler(c *gin.Context) {
 	response := c.Writer
 	request := c.Request
@@ -65,143 +255,369 @@ func subscriptionsHandler(c *gin.Context) {
 		logger.Error(message)
 		return
 	}
-	connectionACK, err := json.Marshal(map[string]string{
-		"type": "connection_ack",
-	})
-	if err != nil {
-		message := "failed to marshal ws connection ack: " + err.Error()
-		logger.Error(message)
+	socket := &SubscriptionSocket{
+		Conn:       conn,
+		Protocol:   conn.Subprotocol(),
+		Operations: map[string]*Subscriber{},
 	}
-	if err := conn.WriteMessage(websocket.TextMessage, connectionACK); err != nil {
-		message := "failed to write to ws connection: " + err.Error()
-		logger.Error(message)
-		return
+	if socket.Protocol != graphqlTransportWSProtocol {
+		socket.Protocol = graphqlWSProtocol
 	}
-	go func() {
-		for {
-			_, p, err := conn.ReadMessage()
-			if err != nil {
-				return
+	presenceTimeoutDuration := time.Duration(presenceTimeout) * time.Second
+	conn.SetReadDeadline(time.Now().Add(time.Duration(subscriptionInitTimeout) * time.Second))
+	conn.SetPongHandler(func(string) error {
+		if !socket.Initialized {
+			return nil
+		}
+		conn.SetReadDeadline(time.Now().Add(presenceTimeoutDuration))
+		for _, subscriber := range socket.Operations {
+			if subscriber.Setup.OnAlive == nil {
+				continue
 			}
-			var msg ConnectionACKMessage
-			if err := json.Unmarshal(p, &msg); err != nil {
-				message := "failed to unmarshal: " + err.Error()
-				logger.Error(message)
+			if err := subscriber.Setup.OnAlive(); err != nil {
+				logger.Error("cannot keep subscription alive: " + err.Error())
+			}
+		}
+		return nil
+	})
+	go readSubscriptionSocket(socket)
+}
+
+func readSubscriptionSocket(socket *SubscriptionSocket) {
+	presenceTimeoutDuration := time.Duration(presenceTimeout) * time.Second
+	done := make(chan bool)
+	defer func() {
+		close(done)
+		for _, subscriber := range socket.Operations {
+			leaveSubscription(subscriber)
+		}
+		if err := socket.Conn.Close(); err != nil {
+			logger.Error("cannot close connection: " + err.Error())
+		}
+	}()
+	for {
+		_, p, err := socket.Conn.ReadMessage()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && !socket.Initialized &&
+				socket.Protocol == graphqlTransportWSProtocol {
+				closeSocket(socket, 4408, "Connection initialisation timeout")
+			}
+			return
+		}
+		if socket.Initialized {
+			socket.Conn.SetReadDeadline(time.Now().Add(presenceTimeoutDuration))
+		}
+		var msg OperationMessage
+		if err := json.Unmarshal(p, &msg); err != nil {
+			if !rejectSocketMessage(socket, "", 4400, "Invalid message received") {
 				return
 			}
-			if msg.Type == "start" {
-				payload := graphql.Do(graphql.Params{
-					Schema:        schema,
-					RequestString: msg.Payload.Query,
-					Context:       context.WithValue(context.Background(), getTokenKey, true),
-				})
-				if payload.HasErrors() {
-					message, err := json.Marshal(map[string]interface{}{
-						"type":    "data",
-						"id":      msg.OperationID,
-						"payload": payload,
-					})
-					if err != nil {
-						logger.Error("failed to marshal message: " + err.Error())
-					} else if err = conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "connection_init":
+			if socket.Initialized {
+				if socket.Protocol == graphqlTransportWSProtocol {
+					closeSocket(socket, 4429, "Too many initialisation requests")
+					return
+				}
+				continue
+			}
+			if err := initSubscriptionSocket(socket, msg.Payload); err != nil {
+				if socket.Protocol == graphqlTransportWSProtocol {
+					closeSocket(socket, 4403, "Forbidden")
+				} else {
+					if err = writeSocketMessage(socket, "connection_error", "", map[string]string{
+						"message": err.Error(),
+					}); err != nil {
 						logger.Error("failed to write to ws connection: " + err.Error())
 					}
-					if err = conn.Close(); err != nil {
-						logger.Error("cannot close connection: " + err.Error())
-					}
-					return
 				}
-				updatesAccessTokenString := payload.Data.(map[string]interface{})["formUpdates"].(map[string]interface{})["id"].(string)
-				formIDString, _, connectionIDString, _ := getFormUpdateClaimsData(updatesAccessTokenString, editAccessLevel)
-				payload = graphql.Do(graphql.Params{
-					Schema:        schema,
-					RequestString: msg.Payload.Query,
-					Context:       context.WithValue(context.Background(), getConnectionIDKey, connectionIDString),
-				})
-				message, err := json.Marshal(map[string]interface{}{
-					"type":    "data",
-					"id":      msg.OperationID,
-					"payload": payload,
-				})
-				if err != nil {
-					logger.Info("failed to marshal message: " + err.Error())
-				} else if err = conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+			socket.Initialized = true
+			socket.Conn.SetReadDeadline(time.Now().Add(presenceTimeoutDuration))
+			if err := writeSocketMessage(socket, "connection_ack", "", nil); err != nil {
+				logger.Error("failed to write to ws connection: " + err.Error())
+				return
+			}
+			if socket.Protocol == graphqlWSProtocol {
+				// the legacy protocol expects a keep alive right after the ack
+				if err := writeSocketMessage(socket, "ka", "", nil); err != nil {
 					logger.Error("failed to write to ws connection: " + err.Error())
+					return
 				}
-				if payload.HasErrors() || err != nil {
-					if err = conn.Close(); err != nil {
-						logger.Error("cannot close connection: " + err.Error())
-					}
+			}
+			go keepSubscriptionSocketAlive(socket, done)
+		case "start", "subscribe":
+			if (msg.Type == "start") != (socket.Protocol == graphqlWSProtocol) {
+				if !rejectSocketMessage(socket, msg.OperationID, 4400, "Invalid message type "+msg.Type) {
 					return
 				}
-				var subscriber = Subscriber{
-					FormID:        formIDString,
-					Conn:          conn,
-					RequestString: msg.Payload.Query,
-					OperationID:   msg.OperationID,
+				continue
+			}
+			if !socket.Initialized {
+				if !rejectSocketMessage(socket, msg.OperationID, 4401, "Unauthorized") {
+					return
 				}
-				currentConnection, _ := connections.Load(formIDString)
-				if currentConnection == nil {
-					pubsub := redisClient.Subscribe(updateFormPath + formIDString)
-					currentTime := time.Now()
-					connections.Store(formIDString, Connection{
-						pubsub,
-						map[string]Subscriber{},
-						currentTime,
-						false,
-					})
-					logger.Info("start websocket sender")
-					go websocketSubscriptionSender(formIDString)
-				} else {
-					logger.Info("current connection not nil...")
+				continue
+			}
+			if len(msg.OperationID) == 0 {
+				if !rejectSocketMessage(socket, "", 4400, "No operation id given") {
+					return
 				}
-				currentConnection, _ = connections.Load(formIDString)
-				currentSub, ok := currentConnection.(Connection).Subscribers[connectionIDString]
-				if ok {
-					if err = currentSub.Conn.Close(); err != nil {
-						logger.Error("cannot close connection: " + err.Error())
-					}
-					delete(currentConnection.(Connection).Subscribers, connectionIDString)
+				continue
+			}
+			if _, ok := socket.Operations[msg.OperationID]; ok {
+				if !rejectSocketMessage(socket, msg.OperationID, 4409, "Subscriber for "+msg.OperationID+" already exists") {
+					return
 				}
-				currentConnection.(Connection).Subscribers[connectionIDString] = subscriber
-				logger.Info("saved new subscriber")
+				continue
+			}
+			var payload OperationPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				if !rejectSocketMessage(socket, msg.OperationID, 4400, "Invalid subscription payload") {
+					return
+				}
+				continue
+			}
+			startSubscription(socket, msg.OperationID, &payload)
+		case "stop", "complete":
+			stopSubscription(socket, msg.OperationID)
+		case "ping":
+			if err := writeSocketMessage(socket, "pong", "", nil); err != nil {
+				logger.Error("failed to write to ws connection: " + err.Error())
+				return
+			}
+		case "pong":
+		case "connection_terminate":
+			return
+		default:
+			if !rejectSocketMessage(socket, msg.OperationID, 4400, "Invalid message type "+msg.Type) {
+				return
 			}
 		}
-	}()
+	}
 }
 
-func websocketSubscriptionSender(formIDString string) {
-	for {
-		connection, ok := connections.Load(formIDString)
-		if !ok {
-			logger.Info("cannot find connection...")
+// hasSubscriptionData is false when every subscription field resolved to null
+func hasSubscriptionData(result *graphql.Result) bool {
+	resultData, ok := result.Data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, fieldData := range resultData {
+		if fieldData != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func startSubscription(socket *SubscriptionSocket, operationID string, payload *OperationPayload) {
+	setup := &SubscriptionSetup{}
+	ctx := context.WithValue(context.Background(), tokenKey, socket.AccessToken)
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  payload.Query,
+		VariableValues: payload.Variables,
+		OperationName:  payload.OperationName,
+		Context:        context.WithValue(ctx, subscriptionSetupKey, setup),
+	})
+	if !result.HasErrors() && len(setup.Topic) == 0 {
+		result.Errors = gqlerrors.FormatErrors(errors.New("no subscription field found"))
+	}
+	if result.HasErrors() {
+		if err := writeSocketError(socket, operationID, result.Errors); err != nil {
+			logger.Error("failed to write to ws connection: " + err.Error())
+		}
+		return
+	}
+	if hasSubscriptionData(result) {
+		if err := writeSocketResult(socket, operationID, result); err != nil {
+			logger.Error("failed to write to ws connection: " + err.Error())
 			return
 		}
-		connectionData := connection.(Connection)
-		for msg := range connectionData.Conn.Channel() {
-			for userIDString, subscriber := range connectionData.Subscribers {
-				payload := graphql.Do(graphql.Params{
-					Schema:        schema,
-					RequestString: subscriber.RequestString,
-					Context:       context.WithValue(context.Background(), dataKey, msg.Payload),
-				})
-				message, err := json.Marshal(map[string]interface{}{
-					"type":    "data",
-					"id":      subscriber.OperationID,
-					"payload": payload,
-				})
-				if err != nil {
-					logger.Error("error marshalling data: " + err.Error())
-					continue
-				}
-				if err := subscriber.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-					if err == websocket.ErrCloseSent {
-						delete(connectionData.Subscribers, userIDString)
-					} else {
-						logger.Error("failed to write to ws connection: " + err.Error())
-					}
-				}
-			}
+	}
+	var subscriber = &Subscriber{
+		Topic:         setup.Topic,
+		Key:           setup.Key,
+		Socket:        socket,
+		RequestString: payload.Query,
+		Variables:     payload.Variables,
+		OperationName: payload.OperationName,
+		OperationID:   operationID,
+		Setup:         setup,
+	}
+	if len(subscriber.Key) == 0 {
+		subscriber.Key = xid.New().String()
+	}
+	subscribersLock.Lock()
+	currentConnection, ok := connections.Load(subscriber.Topic)
+	if !ok {
+		pubsub := redisClient.Subscribe(subscriber.Topic)
+		currentConnection = Connection{
+			pubsub,
+			map[string]Subscriber{},
+			time.Now(),
+			false,
+		}
+		connections.Store(subscriber.Topic, currentConnection)
+		logger.Info("start websocket sender")
+		go websocketSubscriptionSender(subscriber.Topic, pubsub)
+	}
+	currentSub, replaced := currentConnection.(Connection).Subscribers[subscriber.Key]
+	currentConnection.(Connection).Subscribers[subscriber.Key] = *subscriber
+	subscribersLock.Unlock()
+	if replaced {
+		// the key moved to a new operation, so end the old one
+		if err := writeSocketMessage(currentSub.Socket, "complete", currentSub.OperationID, nil); err != nil {
+			logger.Error("failed to write to ws connection: " + err.Error())
+		}
+	}
+	socket.Operations[operationID] = subscriber
+	logger.Info("saved new subscriber")
+	if setup.OnStart != nil {
+		if err := setup.OnStart(); err != nil {
+			logger.Error("cannot start subscription: " + err.Error())
+		}
+	}
+}
+
+func stopSubscription(socket *SubscriptionSocket, operationID string) {
+	subscriber, ok := socket.Operations[operationID]
+	if !ok {
+		return
+	}
+	delete(socket.Operations, operationID)
+	leaveSubscription(subscriber)
+	if socket.Protocol == graphqlWSProtocol {
+		if err := writeSocketMessage(socket, "complete", operationID, nil); err != nil {
+			logger.Error("failed to write to ws connection: " + err.Error())
+		}
+	}
+}
+
+func websocketSubscriptionSender(topic string, pubsub *redis.PubSub) {
+	for msg := range pubsub.Channel() {
+		subscribersLock.Lock()
+		connection, ok := connections.Load(topic)
+		if !ok || connection.(Connection).Conn != pubsub {
+			subscribersLock.Unlock()
+			return
+		}
+		// resolve and write without the lock, so a slow socket does not hold up every topic
+		subscribers := make([]Subscriber, 0, len(connection.(Connection).Subscribers))
+		for _, subscriber := range connection.(Connection).Subscribers {
+			subscribers = append(subscribers, subscriber)
+		}
+		subscribersLock.Unlock()
+		for i := range subscribers {
+			sendSubscriptionData(&subscribers[i], msg.Payload)
+		}
+	}
+}
+
+// sendSubscriptionData resolves the operation with published data and writes it to the subscriber
+func sendSubscriptionData(subscriber *Subscriber, data string) {
+	if subscriber.Setup.CheckAccess != nil {
+		// access can be removed, or the session revoked, while the operation is running
+		if err := subscriber.Setup.CheckAccess(); err != nil {
+			endSubscription(subscriber, err)
+			return
+		}
+	}
+	ctx := context.WithValue(context.Background(), tokenKey, subscriber.Socket.AccessToken)
+	payload := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  subscriber.RequestString,
+		VariableValues: subscriber.Variables,
+		OperationName:  subscriber.OperationName,
+		Context:        context.WithValue(ctx, dataKey, data),
+	})
+	if err := writeSocketResult(subscriber.Socket, subscriber.OperationID, payload); err != nil {
+		if err == websocket.ErrCloseSent {
+			leaveSubscription(subscriber)
+		} else {
+			logger.Error("failed to write to ws connection: " + err.Error())
 		}
 	}
 }
+
+// endSubscription stops sending to an operation from the server side, telling the client why
+func endSubscription(subscriber *Subscriber, reason error) {
+	leaveSubscription(subscriber)
+	if err := writeSocketError(subscriber.Socket, subscriber.OperationID, gqlerrors.FormatErrors(reason)); err != nil {
+		logger.Error("failed to write to ws connection: " + err.Error())
+		return
+	}
+	if subscriber.Socket.Protocol == graphqlWSProtocol {
+		// the legacy protocol only ends an operation with complete
+		if err := writeSocketMessage(subscriber.Socket, "complete", subscriber.OperationID, nil); err != nil {
+			logger.Error("failed to write to ws connection: " + err.Error())
+		}
+	}
+}
+
+// leaveSubscription removes a finished operation from its topic, unless the
+// key has since been taken over by a newer operation
+func leaveSubscription(subscriber *Subscriber) {
+	subscribersLock.Lock()
+	currentConnection, ok := connections.Load(subscriber.Topic)
+	if !ok {
+		subscribersLock.Unlock()
+		return
+	}
+	subscribers := currentConnection.(Connection).Subscribers
+	currentSub, ok := subscribers[subscriber.Key]
+	if !ok || currentSub.Socket != subscriber.Socket || currentSub.OperationID != subscriber.OperationID {
+		subscribersLock.Unlock()
+		return
+	}
+	delete(subscribers, subscriber.Key)
+	if len(subscribers) == 0 {
+		// nobody is listening, so stop the sender
+		connections.Delete(subscriber.Topic)
+		if err := currentConnection.(Connection).Conn.Close(); err != nil {
+			logger.Error("problem closing redis pubsub connection: " + err.Error())
+		}
+	}
+	subscribersLock.Unlock()
+	if subscriber.Setup.OnStop != nil {
+		if err := subscriber.Setup.OnStop(); err != nil {
+			logger.Error("cannot stop subscription: " + err.Error())
+		}
+	}
+}
+
+// publishSubscription sends data to everyone subscribed to the topic
+func publishSubscription(topic string, data interface{}) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		logger.Error("cannot marshal subscription data: " + err.Error())
+		return
+	}
+	if err = redisClient.Publish(topic, dataJSON).Err(); err != nil {
+		logger.Error("cannot publish subscription data: " + err.Error())
+	}
+}
+
+// resolveSubscription resolves a subscription field with published data, or
+// sets up the subscription when an operation starts
+func resolveSubscription(params graphql.ResolveParams, setupSubscription func(setup *SubscriptionSetup) (interface{}, error)) (interface{}, error) {
+	if data := params.Context.Value(dataKey); data != nil {
+		var dataObj map[string]interface{}
+		if err := json.UnmarshalFromString(data.(string), &dataObj); err != nil {
+			return nil, err
+		}
+		return dataObj, nil
+	}
+	setup, ok := params.Context.Value(subscriptionSetupKey).(*SubscriptionSetup)
+	if !ok {
+		return nil, nil
+	}
+	if len(setup.Topic) > 0 {
+		return nil, errors.New("only one subscription field can be used per operation")
+	}
+	return setupSubscription(setup)
+}
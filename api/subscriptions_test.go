@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func newTestSchema(t *testing.T) graphql.Schema {
+	t.Helper()
+	testSchema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:        rootQuery(),
+		Mutation:     rootMutation(),
+		Subscription: rootSubscription(),
+	})
+	if err != nil {
+		t.Fatalf("cannot create schema: %s", err)
+	}
+	return testSchema
+}
+
+// resolveTestSubscription resolves the subscription with published data, the way
+// websocketSubscriptionSender does
+func resolveTestSubscription(t *testing.T, requestString string, data interface{}) map[string]interface{} {
+	t.Helper()
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("cannot marshal data: %s", err)
+	}
+	ctx := context.WithValue(context.Background(), tokenKey, "")
+	result := graphql.Do(graphql.Params{
+		Schema:        newTestSchema(t),
+		RequestString: requestString,
+		Context:       context.WithValue(ctx, dataKey, string(dataJSON)),
+	})
+	if result.HasErrors() {
+		t.Fatalf("cannot resolve subscription: %v", result.Errors)
+	}
+	return result.Data.(map[string]interface{})
+}
+
+// TestCommentChangedSubscription resolves the comment subscription with data in the shape
+// publishCommentChanged sends, the way websocketSubscriptionSender does
+func TestCommentChangedSubscription(t *testing.T) {
+	comment := &Comment{
+		ID:     "comment",
+		Form:   "form",
+		Author: "author",
+		Text:   "text",
+	}
+	data := resolveTestSubscription(t, `subscription { commentChanged(form: "form") { type comment { id form text } } }`, map[string]interface{}{
+		"type":    validCommentChangeTypes[0],
+		"comment": comment,
+	})
+	change := data["commentChanged"].(map[string]interface{})
+	if change["type"] != validCommentChangeTypes[0] {
+		t.Fatalf("unexpected change type %v", change["type"])
+	}
+	resolved := change["comment"].(map[string]interface{})
+	if resolved["id"] != comment.ID || resolved["form"] != comment.Form || resolved["text"] != comment.Text {
+		t.Fatalf("unexpected comment %v", resolved)
+	}
+}
+
+func TestAccountChangedTOTPEnabled(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		// publishAccountChanged only keeps whether two factor authentication is on
+		data := resolveTestSubscription(t, `subscription { accountChanged { email totpenabled } }`, &Account{
+			Email: "user@example.com",
+			TOTP: &TOTPData{
+				Enabled: enabled,
+			},
+		})
+		account := data["accountChanged"].(map[string]interface{})
+		if account["totpenabled"] != enabled {
+			t.Fatalf("expected totpenabled %t, got %v", enabled, account["totpenabled"])
+		}
+	}
+}
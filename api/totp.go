@@ -0,0 +1,522 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	json "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// two factor authentication with time based codes (rfc 6238). when it is enabled, logging in with
+// a password returns a totp token instead of a session, which is exchanged for a session with a
+// code or a recovery code at /loginTOTP.
+
+// TOTPData two factor settings of an account
+type TOTPData struct {
+	Secret        string   `json:"secret"`
+	Enabled       bool     `json:"enabled"`
+	LastCounter   int64    `json:"lastcounter"`
+	RecoveryCodes []string `json:"recoverycodes"`
+}
+
+// TOTPEnrollment secret for setting up an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPEnrollmentType graphql totp enrollment object
+var TOTPEnrollmentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "TOTPEnrollment",
+	Fields: graphql.Fields{
+		"secret": &graphql.Field{
+			Type: graphql.String,
+		},
+		"uri": &graphql.Field{
+			Type:        graphql.String,
+			Description: "otpauth uri to show as a qr code",
+		},
+	},
+})
+
+type totpLoginClaims struct {
+	ID   string `json:"id"`
+	TOTP bool   `json:"totp"`
+	jwt.StandardClaims
+}
+
+func generateTOTPSecret() (string, error) {
+	secretBytes := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes), nil
+}
+
+func getTOTPCode(secret string, counter int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	counterBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(counterBytes, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counterBytes)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// checkTOTPCode returns the counter of the code if it is valid for the current time, allowing
+// one step of clock drift
+func checkTOTPCode(secret string, code string, lastCounter int64) (int64, error) {
+	currentCounter := time.Now().Unix() / int64(totpPeriod)
+	for _, counter := range []int64{currentCounter, currentCounter - 1, currentCounter + 1} {
+		expectedCode, err := getTOTPCode(secret, counter)
+		if err != nil {
+			return 0, err
+		}
+		if hmac.Equal([]byte(expectedCode), []byte(code)) {
+			if counter <= lastCounter {
+				return 0, errors.New("code was already used")
+			}
+			return counter, nil
+		}
+	}
+	return 0, errors.New("invalid code")
+}
+
+func getTOTPURI(secret string, email string) string {
+	issuer := jwtIssuer
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+email) + "?" + url.Values{
+		"secret":    []string{secret},
+		"issuer":    []string{issuer},
+		"algorithm": []string{"SHA1"},
+		"digits":    []string{"6"},
+		"period":    []string{fmt.Sprint(totpPeriod)},
+	}.Encode()
+}
+
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(hash[:])
+}
+
+// generateRecoveryCodes returns the codes to show the user and the hashes to save
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, numRecoveryCodes)
+	hashes := make([]string, numRecoveryCodes)
+	for i := range codes {
+		codeBytes := make([]byte, 5)
+		if _, err := rand.Read(codeBytes); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(codeBytes)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func getAccountTOTP(userID primitive.ObjectID) (*TOTPData, error) {
+	account, err := getAccount(userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if account.TOTP == nil {
+		return &TOTPData{}, nil
+	}
+	return account.TOTP, nil
+}
+
+// verifyTOTP checks a code or recovery code for the account, counting the attempt against the rate limit
+func verifyTOTP(userID primitive.ObjectID, code string, recoveryCode string) error {
+	if err := checkRateLimit("totp-"+userID.Hex(), totpAttemptLimit, time.Duration(totpAttemptWindow)*time.Minute); err != nil {
+		return err
+	}
+	totp, err := getAccountTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if len(totp.Secret) == 0 {
+		return errors.New("two factor authentication is not set up")
+	}
+	if len(recoveryCode) > 0 {
+		if !totp.Enabled {
+			return errors.New("two factor authentication is not enabled")
+		}
+		// pulling the code makes it single use, even with parallel requests
+		res, err := userCollection.UpdateOne(ctxMongo, bson.M{
+			"_id":                userID,
+			"totp.recoverycodes": hashRecoveryCode(recoveryCode),
+		}, bson.M{
+			"$pull": bson.M{
+				"totp.recoverycodes": hashRecoveryCode(recoveryCode),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			return errors.New("invalid recovery code")
+		}
+		return nil
+	}
+	counter, err := checkTOTPCode(totp.Secret, code, totp.LastCounter)
+	if err != nil {
+		return err
+	}
+	res, err := userCollection.UpdateOne(ctxMongo, bson.M{
+		"_id": userID,
+		"totp.lastcounter": bson.M{
+			"$lt": counter,
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"totp.lastcounter": counter,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return errors.New("code was already used")
+	}
+	return nil
+}
+
+func getTOTPLoginToken(userIDString string) (string, error) {
+	expirationTime := time.Now().Add(time.Duration(totpLoginExpiration) * time.Minute)
+	return signToken(totpLoginClaims{
+		userIDString,
+		true,
+		jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+			Issuer:    jwtIssuer,
+		},
+	})
+}
+
+/**
+ * @api {put} /loginTOTP Finish login with two factor authentication
+ * @apiVersion 0.0.1
+ * @apiParam {String} totpToken Token from /loginEmailPassword
+ * @apiParam {String} code Code from the authenticator app
+ * @apiParam {String} recoveryCode Recovery code, instead of the code
+ * @apiSuccess {String} token User token for authenticated requests
+ * @apiSuccess {String} refreshToken Token to get a new user token with /refreshToken
+ * @apiGroup authentication
+ */
+func loginTOTP(c *gin.Context) {
+	response := c.Writer
+	request := c.Request
+	if request.Method != http.MethodPut {
+		handleError("login http method not PUT", http.StatusBadRequest, response)
+		return
+	}
+	var logindata map[string]interface{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		handleError("error getting request body: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	err = json.Unmarshal(body, &logindata)
+	if err != nil {
+		handleError("error parsing request body: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	if logindata["totpToken"] == nil {
+		handleError("no totp token provided", http.StatusBadRequest, response)
+		return
+	}
+	totpToken, ok := logindata["totpToken"].(string)
+	if !ok {
+		handleError("totp token cannot be cast to string", http.StatusBadRequest, response)
+		return
+	}
+	code, _ := logindata["code"].(string)
+	recoveryCode, _ := logindata["recoveryCode"].(string)
+	if len(code) == 0 && len(recoveryCode) == 0 {
+		handleError("no code provided", http.StatusBadRequest, response)
+		return
+	}
+	token, err := jwt.ParseWithClaims(totpToken, &totpLoginClaims{}, getTokenKey)
+	if err != nil || !token.Valid {
+		handleError("invalid totp token", http.StatusUnauthorized, response)
+		return
+	}
+	claims, ok := token.Claims.(*totpLoginClaims)
+	if !ok || !claims.TOTP {
+		handleError("invalid totp token", http.StatusUnauthorized, response)
+		return
+	}
+	userIDString := claims.ID
+	userID, err := primitive.ObjectIDFromHex(userIDString)
+	if err != nil {
+		handleError(err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	if err = verifyTOTP(userID, code, recoveryCode); err != nil {
+		handleError(err.Error(), http.StatusUnauthorized, response)
+		return
+	}
+	tokenString, refreshToken, err := createSession(userID, getRequestDevice(request), c.ClientIP())
+	if err != nil {
+		handleError("error creating session: "+err.Error(), http.StatusBadRequest, response)
+		return
+	}
+	recordAudit(auditLoginAction, userIDString, userType, userIDString, userIDString, c.ClientIP(), bson.M{
+		"totp":         true,
+		"recoverycode": len(recoveryCode) > 0,
+	})
+	logger.Info("User login",
+		zap.String("id", userIDString),
+	)
+	response.Header().Set("Content-Type", "application/json")
+	response.Write([]byte(`{ "token": "` + tokenString + `", "refreshToken": "` + refreshToken + `" }`))
+}
+
+func getTOTPArgs(params graphql.ResolveParams) (primitive.ObjectID, string, error) {
+	claims, err := getTokenData(params.Context.Value(tokenKey).(string))
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	userIDString, ok := claims["id"].(string)
+	if !ok {
+		return primitive.NilObjectID, "", errors.New("cannot cast user id to string")
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDString)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	if params.Args["code"] == nil {
+		return userID, "", nil
+	}
+	code, ok := params.Args["code"].(string)
+	if !ok {
+		return primitive.NilObjectID, "", errors.New("cannot cast code to string")
+	}
+	return userID, code, nil
+}
+
+var totpMutationFields = graphql.Fields{
+	"enrollTOTP": &graphql.Field{
+		Type:        TOTPEnrollmentType,
+		Description: "Start setting up two factor authentication, confirm with confirmTOTP",
+		Args:        graphql.FieldConfigArgument{},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			userID, _, err := getTOTPArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			account, err := getAccount(userID, false)
+			if err != nil {
+				return nil, err
+			}
+			if account.TOTP != nil && account.TOTP.Enabled {
+				return nil, errors.New("two factor authentication is already enabled")
+			}
+			secret, err := generateTOTPSecret()
+			if err != nil {
+				return nil, err
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": userID,
+			}, bson.M{
+				"$set": bson.M{
+					"totp": bson.M{
+						"secret":        secret,
+						"enabled":       false,
+						"lastcounter":   int64(0),
+						"recoverycodes": bson.A{},
+					},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &TOTPEnrollment{
+				Secret: secret,
+				URI:    getTOTPURI(secret, account.Email),
+			}, nil
+		},
+	},
+	"confirmTOTP": &graphql.Field{
+		Type:        graphql.NewList(graphql.String),
+		Description: "Enable two factor authentication with a code from the app, returns the recovery codes",
+		Args: graphql.FieldConfigArgument{
+			"code": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			userID, code, err := getTOTPArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			totp, err := getAccountTOTP(userID)
+			if err != nil {
+				return nil, err
+			}
+			if totp.Enabled {
+				return nil, errors.New("two factor authentication is already enabled")
+			}
+			if err = verifyTOTP(userID, code, ""); err != nil {
+				return nil, err
+			}
+			codes, hashes, err := generateRecoveryCodes()
+			if err != nil {
+				return nil, err
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": userID,
+			}, bson.M{
+				"$set": bson.M{
+					"totp.enabled":       true,
+					"totp.recoverycodes": hashes,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			recordAudit(auditTOTPChangeAction, userID.Hex(), userType, userID.Hex(), userID.Hex(), getParamsIP(params), bson.M{
+				"enabled": true,
+			})
+			publishAccountChanged(userID)
+			return codes, nil
+		},
+	},
+	"regenerateRecoveryCodes": &graphql.Field{
+		Type:        graphql.NewList(graphql.String),
+		Description: "Replace the recovery codes, needs a code from the app",
+		Args: graphql.FieldConfigArgument{
+			"code": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			userID, code, err := getTOTPArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if err = verifyTOTP(userID, code, ""); err != nil {
+				return nil, err
+			}
+			codes, hashes, err := generateRecoveryCodes()
+			if err != nil {
+				return nil, err
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id":          userID,
+				"totp.enabled": true,
+			}, bson.M{
+				"$set": bson.M{
+					"totp.recoverycodes": hashes,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			return codes, nil
+		},
+	},
+	"disableTOTP": &graphql.Field{
+		Type:        AccountType,
+		Description: "Turn off two factor authentication, needs a code from the app",
+		Args: graphql.FieldConfigArgument{
+			"code": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			userID, code, err := getTOTPArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			if err = verifyTOTP(userID, code, ""); err != nil {
+				return nil, err
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": userID,
+			}, bson.M{
+				"$unset": bson.M{
+					"totp": 1,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			recordAudit(auditTOTPChangeAction, userID.Hex(), userType, userID.Hex(), userID.Hex(), getParamsIP(params), bson.M{
+				"enabled": false,
+			})
+			publishAccountChanged(userID)
+			return getAccount(userID, false)
+		},
+	},
+	"resetTOTP": &graphql.Field{
+		Type:        AccountType,
+		Description: "Turn off two factor authentication for a user that lost their device",
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			claims, err := validateAdmin(params.Context.Value(tokenKey).(string))
+			if err != nil {
+				return nil, err
+			}
+			if params.Args["id"] == nil {
+				return nil, errors.New("user id not provided")
+			}
+			idString, ok := params.Args["id"].(string)
+			if !ok {
+				return nil, errors.New("cannot cast user id to string")
+			}
+			id, err := primitive.ObjectIDFromHex(idString)
+			if err != nil {
+				return nil, err
+			}
+			_, err = userCollection.UpdateOne(ctxMongo, bson.M{
+				"_id": id,
+			}, bson.M{
+				"$unset": bson.M{
+					"totp": 1,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			adminIDString, _ := claims["id"].(string)
+			logger.Info("Admin totp reset",
+				zap.String("id", idString),
+				zap.String("admin", adminIDString),
+			)
+			recordAudit(auditTOTPChangeAction, adminIDString, userType, idString, idString, getParamsIP(params), bson.M{
+				"enabled": false,
+				"reset":   true,
+			})
+			publishAccountChanged(id)
+			return getAccount(id, false)
+		},
+	},
+}
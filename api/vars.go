@@ -24,15 +24,35 @@ var blogMongoName = "blogs"
 
 var shortLinkMongoName = "shortlink"
 
+var formHistoryMongoName = "formhistory"
+
+var commentMongoName = "comments"
+
+var formUpdateLogMongoName = "formupdatelog"
+
+var formSaveFailureMongoName = "formsavefailures"
+
+var orgMongoName = "orgs"
+
+var groupMongoName = "groups"
+
+var auditMongoName = "auditlog"
+
+var apiKeyMongoName = "apikeys"
+
+var sessionMongoName = "sessions"
+
+var jwtKeyMongoName = "jwtkeys"
+
 type key string
 
 const tokenKey key = "token"
 
-const getTokenKey key = "misc"
-
 const dataKey key = "data"
 
-const getConnectionIDKey key = "connection"
+const subscriptionSetupKey key = "subscriptionSetup"
+
+const ipKey key = "ip"
 
 var graphiQL = false
 
@@ -46,6 +66,8 @@ var formType = "form"
 
 var projectType = "project"
 
+var orgType = "org"
+
 var responseType = "response"
 
 var blogType = "blog"
@@ -61,6 +83,124 @@ var validOrganization = []string{
 	"tag",
 }
 
+var validOrgRoles = []string{
+	"owner",
+	"admin",
+	"member",
+	"viewer",
+}
+
+var orgOwnerRole = validOrgRoles[0]
+
+var orgManageRoles = []string{
+	validOrgRoles[0],
+	validOrgRoles[1],
+}
+
+var orgEditRoles = []string{
+	validOrgRoles[0],
+	validOrgRoles[1],
+	validOrgRoles[2],
+}
+
+var validAuditActions = []string{
+	"login",
+	"register",
+	"passwordReset",
+	"accessChange",
+	"linkAccessChange",
+	"formDelete",
+	"projectDelete",
+	"responseDelete",
+	"planChange",
+	"identityLink",
+	"ssoChange",
+	"lockout",
+	"emailChange",
+	"totpChange",
+}
+
+var auditLoginAction = validAuditActions[0]
+
+var auditRegisterAction = validAuditActions[1]
+
+var auditPasswordResetAction = validAuditActions[2]
+
+var auditAccessChangeAction = validAuditActions[3]
+
+var auditLinkAccessChangeAction = validAuditActions[4]
+
+var auditFormDeleteAction = validAuditActions[5]
+
+var auditProjectDeleteAction = validAuditActions[6]
+
+var auditResponseDeleteAction = validAuditActions[7]
+
+var auditPlanChangeAction = validAuditActions[8]
+
+var auditIdentityLinkAction = validAuditActions[9]
+
+var auditSSOChangeAction = validAuditActions[10]
+
+var auditLockoutAction = validAuditActions[11]
+
+var auditEmailChangeAction = validAuditActions[12]
+
+var auditTOTPChangeAction = validAuditActions[13]
+
+var validAPIKeyScopes = []string{
+	"forms:read",
+	"forms:manage",
+	"responses:read",
+	"responses:manage",
+}
+
+var apiKeyFormsReadScope = validAPIKeyScopes[0]
+
+var apiKeyFormsManageScope = validAPIKeyScopes[1]
+
+var apiKeyResponsesReadScope = validAPIKeyScopes[2]
+
+var apiKeyResponsesManageScope = validAPIKeyScopes[3]
+
+var apiKeyFormsReadFields = []string{
+	"form",
+	"forms",
+	"formHistory",
+	"formSaveFailures",
+	"comments",
+	"project",
+	"projects",
+}
+
+var apiKeyResponsesReadFields = []string{
+	"response",
+	"responses",
+}
+
+// graphql root fields api keys can use with each scope
+var apiKeyScopeFields = map[string][]string{
+	apiKeyFormsReadScope: apiKeyFormsReadFields,
+	apiKeyFormsManageScope: append([]string{
+		"addForm",
+		"updateForm",
+		"updateFormPart",
+		"undoFormPart",
+		"redoFormPart",
+		"retryFormSave",
+		"deleteForm",
+		"addProject",
+		"updateProject",
+		"deleteProject",
+	}, apiKeyFormsReadFields...),
+	apiKeyResponsesReadScope: apiKeyResponsesReadFields,
+	apiKeyResponsesManageScope: append([]string{
+		"addResponse",
+		"updateResponse",
+		"deleteResponse",
+	}, apiKeyResponsesReadFields...),
+}
+
 var superAdminType = "super"
 
 var adminType = "admin"
@@ -109,6 +249,8 @@ var responseFileIndex = "responsefiles"
 
 var blogFileIndex = "blogfiles"
 
+var exportFileIndex = "exports"
+
 var placeholderPath = "/placeholder"
 
 var originalPath = "/original"
@@ -190,6 +332,7 @@ var validFormItemTypes = []string{
 	"fileupload",
 	"fileattachment",
 	"media",
+	"payment",
 }
 
 var validResponseItemTypes = []string{
@@ -198,16 +341,19 @@ var validResponseItemTypes = []string{
 	validFormItemTypes[2],
 	validFormItemTypes[4],
 	validFormItemTypes[5],
+	validFormItemTypes[8],
 }
 
 var itemTypesRequireOptions = []string{
 	validFormItemTypes[0],
 	validFormItemTypes[1],
 	validFormItemTypes[4],
+	validFormItemTypes[8],
 }
 
 var itemTypesAllowMultipleOptions = []string{
 	validFormItemTypes[1],
+	validFormItemTypes[8],
 }
 
 var itemTypesText = []string{
@@ -218,6 +364,43 @@ var itemTypesFile = []string{
 	validFormItemTypes[5],
 }
 
+var itemTypesPayment = []string{
+	validFormItemTypes[8],
+}
+
+var validFormUpdateTypes = []string{
+	"update",
+	"join",
+	"leave",
+	"focus",
+}
+
+var validFormHistorySources = []string{
+	"edit",
+	"undo",
+	"redo",
+}
+
+var validCommentChangeTypes = []string{
+	"add",
+	"update",
+	"delete",
+}
+
+var validProjectChangeTypes = []string{
+	"update",
+	"delete",
+}
+
+var validDuplicateActions = []string{
+	"reject",
+	"flag",
+}
+
+var duplicateActionReject = validDuplicateActions[0]
+
+var duplicateActionFlag = validDuplicateActions[1]
+
 var validIntervals = []string{
 	"year",
 	"month",
@@ -237,10 +420,160 @@ var defaultCouponDuration = "repeating"
 
 var autosaveTime = 3 // seconds
 
+var formSaveRetryLimit = 10
+
+var formSaveMinBackoff = 5 // seconds
+
+var formSaveMaxBackoff = 10 // minutes
+
+var formSaveGapTimeout = 30 // seconds
+
+var presenceTimeout = 60 // seconds
+
+var undoLimit int64 = 100 // undo steps kept per user and form
+
+var commentMaxLength = 5000 // characters
+
+var auditMaxPerPage = 100 // entries
+
+var apiKeyPrefix = "ehk_"
+
+var apiKeySecretLength = 32 // bytes
+
+var apiKeyLastUsedInterval = 60 // seconds
+
+var accessTokenExpiration = 15 // minutes
+
+var refreshTokenExpiration = 720 // hours
+
+var refreshTokenLength = 32 // bytes
+
+var sessionDeviceMaxLength = 256 // characters
+
+var totpSecretLength = 20 // bytes
+
+var totpPeriod = 30 // seconds
+
+var totpLoginExpiration = 5 // minutes
+
+var totpAttemptLimit int64 = 5 // attempts per window
+
+var totpAttemptWindow = 15 // minutes
+
+var numRecoveryCodes = 10
+
+var validJWTAlgorithms = []string{
+	"HS256",
+	"RS256",
+	"EdDSA",
+}
+
+var jwtRSAKeySize = 2048 // bits
+
+var jwtKeyIDLength = 12 // bytes
+
+var jwtKeyRotationInterval = 720 // hours between new signing keys
+
+var jwtKeyPublishDelay = 24 // hours a key is published before it signs
+
+var jwtKeyRetention = 720 // hours a key stays published after it stopped signing
+
+var jwtKeyRotationCheck = 1 // hours
+
+var jwtKeyCacheTime = 5 // minutes
+
+var jwtKeyReloadInterval = 10 // seconds
+
+var loginIPLimit int64 = 30 // attempts per window
+
+var loginIPWindow = 10 // minutes
+
+var loginEmailLimit int64 = 20 // attempts per window
+
+var loginEmailWindow = 10 // minutes
+
+var loginFailureWindow = 15 // minutes
+
+var loginFreeFailures int64 = 3 // failures before logins are delayed
+
+var loginDelayBase = 1 // seconds, doubled for every further failure
+
+var loginMaxDelay = 60 // seconds
+
+var loginLockoutThreshold int64 = 10 // failures in the failure window
+
+var loginLockoutDuration = 30 // minutes
+
+var resetEmailIPLimit int64 = 10 // emails per window
+
+var resetEmailLimit int64 = 3 // emails per window
+
+var resetEmailWindow = 60 // minutes
+
+var resetIPLimit int64 = 10 // resets per window
+
+var resetWindow = 60 // minutes
+
+var emailConfirmLimit int64 = 5
+
+var emailConfirmWindow = 60 // minutes
+
+var linkPasswordIPLimit int64 = 30 // attempts per window
+
+var linkPasswordLimit int64 = 20 // attempts per link per window
+
+var linkPasswordWindow = 10 // minutes
+
+var maxAccountEmails = 5
+
+var exportExpiration = 48 // hours the export link works
+
+var exportRequestInterval = 24 // hours between exports
+
+var oidcDefaultScopes = "openid email profile"
+
+var oidcWebsitePath = "/login/oidc"
+
+var oidcSecretLength = 32 // bytes
+
+var oidcStateExpiration = 10 // minutes
+
+var oidcRequestTimeout = 10 // seconds
+
+var oidcMaxResponseSize int64 = 1 << 20 // bytes
+
+var oidcCacheTime = 24 // hours
+
+var oidcKeyRefreshInterval = 60 // seconds
+
+var samlProviderName = "saml"
+
+var samlRequestExpiration = 10 // minutes
+
+var samlClockSkew = 180 // seconds
+
+var samlMaxResponseSize = 1 << 20 // characters
+
+var samlDomainRecordPrefix = "_emailhacks-sso."
+
+var samlDomainTokenPrefix = "emailhacks-sso="
+
+var samlWebsitePath = "/login/saml"
+
+var subscriptionKeepAlive = 20 // seconds
+
+var subscriptionInitTimeout = 10 // seconds
+
+var subscriptionWriteTimeout = 10 // seconds
+
 var forexUpdateTime = 12 // hours
 
 var storageAccessTime = 5 // minutes
 
+var responseRateLimitIP int64 = 10 // responses per minute
+
+var responseRateLimitForm int64 = 300 // responses per minute
+
 var foreignExchangeURL = "https://api.exchangeratesapi.io/latest"
 
 // more configuration params
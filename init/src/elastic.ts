@@ -84,6 +84,22 @@ const linkAccessMappings = {
   },
   type: {
     type: 'keyword'
+  },
+  expires: {
+    type: 'date',
+    format: 'epoch_second'
+  },
+  maxuses: {
+    type: 'integer'
+  },
+  uses: {
+    type: 'integer'
+  },
+  domain: {
+    type: 'keyword'
+  },
+  protected: {
+    type: 'boolean'
   }
 }
 
@@ -98,6 +114,9 @@ export const formMappings = {
     owner: {
       type: 'keyword'
     },
+    org: {
+      type: 'keyword'
+    },
     multiple: {
       type: 'boolean'
     },
@@ -146,6 +165,9 @@ export const projectMappings = {
     owner: {
       type: 'keyword'
     },
+    org: {
+      type: 'keyword'
+    },
     access: {
       type: 'object'
     },
@@ -181,6 +203,15 @@ export const responseMappings = {
     owner: {
       type: 'keyword'
     },
+    org: {
+      type: 'keyword'
+    },
+    nullifier: {
+      type: 'keyword'
+    },
+    uniquekeys: {
+      type: 'keyword'
+    },
     form: {
       type: 'keyword'
     },